@@ -0,0 +1,385 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// Storage of the ACME server state.  Accounts are keyed by the SHA-256 JWK
+// thumbprint of their key, orders and authorizations by UUID.
+const (
+	acmeNoncePrefix         = "acme/nonces/"
+	acmeAccountPrefix       = "acme/accounts/"
+	acmeOrderPrefix         = "acme/orders/"
+	acmeAuthorizationPrefix = "acme/authorizations/"
+)
+
+const (
+	// acmeNonceLifetime is how long a nonce from new-nonce may be used for.
+	acmeNonceLifetime = time.Hour
+
+	// acmeOrderLifetime is how long an order and its authorizations may be
+	// completed for.
+	acmeOrderLifetime = 7 * 24 * time.Hour
+
+	// acmeChallengeTimeout bounds the request made to validate an http-01
+	// challenge.
+	acmeChallengeTimeout = 10 * time.Second
+
+	// acmeMaxChallengeResponse bounds the key authorization read from an
+	// http-01 challenge response.
+	acmeMaxChallengeResponse = 1024
+)
+
+// Statuses of ACME objects, from RFC 8555 section 7.1.6.
+const (
+	acmeStatusPending    = "pending"
+	acmeStatusReady      = "ready"
+	acmeStatusProcessing = "processing"
+	acmeStatusValid      = "valid"
+	acmeStatusInvalid    = "invalid"
+)
+
+const (
+	acmeIdentifierDNS   = "dns"
+	acmeChallengeHTTP01 = "http-01"
+)
+
+// acmeError is an ACME problem document, returned to clients as
+// application/problem+json with the HTTP status.
+type acmeError struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status,omitempty"`
+}
+
+func (e *acmeError) Error() string {
+	return e.Detail
+}
+
+// acmeProblem returns an acmeError of the type in the ACME error namespace.
+func acmeProblem(status int, errType string, format string, args ...interface{}) *acmeError {
+	return &acmeError{
+		Type:   "urn:ietf:params:acme:error:" + errType,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	}
+}
+
+// acmeIdentifier is the identifier of an order or authorization.
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// acmeAccount is a registered ACME account.
+type acmeAccount struct {
+	Status    string          `json:"status"`
+	Contact   []string        `json:"contact,omitempty"`
+	JWK       json.RawMessage `json:"jwk"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// acmeOrder is a request of an ACME account for a certificate.
+type acmeOrder struct {
+	AccountID        string           `json:"account_id"`
+	Status           string           `json:"status"`
+	Expires          time.Time        `json:"expires"`
+	Identifiers      []acmeIdentifier `json:"identifiers"`
+	AuthorizationIDs []string         `json:"authorization_ids"`
+	Serial           string           `json:"serial,omitempty"`
+	Certificate      string           `json:"certificate,omitempty"`
+	Error            *acmeError       `json:"error,omitempty"`
+}
+
+// acmeAuthorization is the authorization of an account for an identifier,
+// with its single http-01 challenge.
+type acmeAuthorization struct {
+	AccountID       string         `json:"account_id"`
+	Identifier      acmeIdentifier `json:"identifier"`
+	Status          string         `json:"status"`
+	Expires         time.Time      `json:"expires"`
+	Token           string         `json:"token"`
+	ChallengeStatus string         `json:"challenge_status"`
+	Validated       time.Time      `json:"validated,omitempty"`
+	Error           *acmeError     `json:"error,omitempty"`
+}
+
+// acmeRandom returns n random bytes encoded as unpadded base64url.
+func acmeRandom(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// newACMENonce stores and returns a nonce for the Replay-Nonce header.
+func (b *keyfactorBackend) newACMENonce(ctx context.Context, s logical.Storage) (string, error) {
+	nonce, err := acmeRandom(16)
+	if err != nil {
+		return "", err
+	}
+	entry, err := logical.StorageEntryJSON(acmeNoncePrefix+nonce, &usedNonceEntry{ExpiresAt: time.Now().Add(acmeNonceLifetime)})
+	if err != nil {
+		return "", err
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return "", err
+	}
+	return nonce, nil
+}
+
+// useACMENonce consumes a nonce issued by newACMENonce, reporting false if it
+// was not issued, was already used or has expired.
+func (b *keyfactorBackend) useACMENonce(ctx context.Context, s logical.Storage, nonce string) (bool, error) {
+	if nonce == "" || strings.ContainsAny(nonce, "/.") {
+		return false, nil
+	}
+	b.nonceLock.Lock()
+	defer b.nonceLock.Unlock()
+
+	entry, err := s.Get(ctx, acmeNoncePrefix+nonce)
+	if err != nil || entry == nil {
+		return false, err
+	}
+	if err := s.Delete(ctx, acmeNoncePrefix+nonce); err != nil {
+		return false, err
+	}
+	var issued usedNonceEntry
+	if err := entry.DecodeJSON(&issued); err != nil {
+		return false, err
+	}
+	return time.Now().Before(issued.ExpiresAt), nil
+}
+
+// tidyACMENonces deletes the ACME nonces that have expired unused.
+func (b *keyfactorBackend) tidyACMENonces(ctx context.Context, s logical.Storage) error {
+	b.nonceLock.Lock()
+	defer b.nonceLock.Unlock()
+
+	nonces, err := s.List(ctx, acmeNoncePrefix)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, nonce := range nonces {
+		entry, err := s.Get(ctx, acmeNoncePrefix+nonce)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+		var issued usedNonceEntry
+		if err := entry.DecodeJSON(&issued); err == nil && now.Before(issued.ExpiresAt) {
+			continue
+		}
+		if err := s.Delete(ctx, acmeNoncePrefix+nonce); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acmeRequest is a verified JWS-signed ACME request.  Account is nil for
+// requests signed with a jwk rather than the kid of an account.
+type acmeRequest struct {
+	Payload   []byte
+	JWK       *jose.JSONWebKey
+	AccountID string
+	Account   *acmeAccount
+}
+
+// verifyACMERequest verifies the flattened JWS of an ACME POST request: the
+// nonce must be unused, the url must be the one requested and the signature
+// must be made by the jwk in the header, if allowJWK is set, or else by the
+// key of the account named by the kid.
+func (b *keyfactorBackend) verifyACMERequest(ctx context.Context, req *logical.Request, config *keyfactorConfig, protected string, payload string, signature string, allowJWK bool) (*acmeRequest, error) {
+	jws, err := jose.ParseSigned(protected + "." + payload + "." + signature)
+	if err != nil {
+		return nil, acmeProblem(http.StatusBadRequest, "malformed", "unable to parse the JWS: %s", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, acmeProblem(http.StatusBadRequest, "malformed", "the JWS must have exactly one signature")
+	}
+	header := jws.Signatures[0].Protected
+
+	fresh, err := b.useACMENonce(ctx, req.Storage, header.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, acmeProblem(http.StatusBadRequest, "badNonce", "the nonce %q is not valid", header.Nonce)
+	}
+	if url, _ := header.ExtraHeaders["url"].(string); url != config.acmeURL(req.Path) {
+		return nil, acmeProblem(http.StatusUnauthorized, "unauthorized", "the url %q of the JWS does not match the request", url)
+	}
+
+	verified := &acmeRequest{}
+	switch {
+	case header.JSONWebKey != nil && header.KeyID != "":
+		return nil, acmeProblem(http.StatusBadRequest, "malformed", "the JWS must not contain both a jwk and a kid")
+	case header.JSONWebKey != nil:
+		if !allowJWK {
+			return nil, acmeProblem(http.StatusBadRequest, "malformed", "the JWS must be signed with the kid of an account")
+		}
+		if !header.JSONWebKey.Valid() || !header.JSONWebKey.IsPublic() {
+			return nil, acmeProblem(http.StatusBadRequest, "badPublicKey", "the jwk of the JWS is not a valid public key")
+		}
+		verified.JWK = header.JSONWebKey
+		if verified.AccountID, err = acmeThumbprint(verified.JWK); err != nil {
+			return nil, err
+		}
+	case header.KeyID != "":
+		accountId, found := strings.CutPrefix(header.KeyID, config.acmeURL("acme/account/"))
+		if !found || accountId == "" || strings.Contains(accountId, "/") {
+			return nil, acmeProblem(http.StatusBadRequest, "accountDoesNotExist", "unknown account %q", header.KeyID)
+		}
+		account, err := getACMEAccount(ctx, req.Storage, accountId)
+		if err != nil {
+			return nil, err
+		}
+		if account == nil {
+			return nil, acmeProblem(http.StatusBadRequest, "accountDoesNotExist", "unknown account %q", header.KeyID)
+		}
+		if account.Status != acmeStatusValid {
+			return nil, acmeProblem(http.StatusUnauthorized, "unauthorized", "the account is %s", account.Status)
+		}
+		verified.JWK = &jose.JSONWebKey{}
+		if err := verified.JWK.UnmarshalJSON(account.JWK); err != nil {
+			return nil, fmt.Errorf("unable to parse the key of ACME account %s: %w", accountId, err)
+		}
+		verified.AccountID = accountId
+		verified.Account = account
+	default:
+		return nil, acmeProblem(http.StatusBadRequest, "malformed", "the JWS must contain a jwk or a kid")
+	}
+
+	verified.Payload, err = jws.Verify(verified.JWK)
+	if err != nil {
+		return nil, acmeProblem(http.StatusBadRequest, "malformed", "invalid JWS signature: %s", err)
+	}
+	return verified, nil
+}
+
+// acmeThumbprint returns the unpadded base64url SHA-256 thumbprint of a JWK,
+// which identifies the account of the key.
+func acmeThumbprint(jwk *jose.JSONWebKey) (string, error) {
+	sum, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", acmeProblem(http.StatusBadRequest, "badPublicKey", "unable to compute the thumbprint of the jwk: %s", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(sum), nil
+}
+
+// acmeURL returns the external URL of a path of this mount.
+func (c *keyfactorConfig) acmeURL(path string) string {
+	return strings.TrimSuffix(c.ACMEBaseURL, "/") + "/" + path
+}
+
+// getACMEAccount returns the ACME account with the ID, or nil if there is none.
+func getACMEAccount(ctx context.Context, s logical.Storage, accountId string) (*acmeAccount, error) {
+	entry, err := s.Get(ctx, acmeAccountPrefix+accountId)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	account := &acmeAccount{}
+	if err := entry.DecodeJSON(account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// getACMEOrder returns the order with the ID if it belongs to the account.
+func getACMEOrder(ctx context.Context, s logical.Storage, accountId string, orderId string) (*acmeOrder, error) {
+	entry, err := s.Get(ctx, acmeOrderPrefix+orderId)
+	if err != nil {
+		return nil, err
+	}
+	order := &acmeOrder{}
+	if entry != nil {
+		if err := entry.DecodeJSON(order); err != nil {
+			return nil, err
+		}
+	}
+	if entry == nil || order.AccountID != accountId {
+		return nil, acmeProblem(http.StatusNotFound, "malformed", "order %s not found", orderId)
+	}
+	return order, nil
+}
+
+// getACMEAuthorization returns the authorization with the ID if it belongs
+// to the account.
+func getACMEAuthorization(ctx context.Context, s logical.Storage, accountId string, authzId string) (*acmeAuthorization, error) {
+	entry, err := s.Get(ctx, acmeAuthorizationPrefix+authzId)
+	if err != nil {
+		return nil, err
+	}
+	authz := &acmeAuthorization{}
+	if entry != nil {
+		if err := entry.DecodeJSON(authz); err != nil {
+			return nil, err
+		}
+	}
+	if entry == nil || authz.AccountID != accountId {
+		return nil, acmeProblem(http.StatusNotFound, "malformed", "authorization %s not found", authzId)
+	}
+	return authz, nil
+}
+
+// putACMEObject stores an ACME account, order or authorization.
+func putACMEObject(ctx context.Context, s logical.Storage, key string, object interface{}) error {
+	entry, err := logical.StorageEntryJSON(key, object)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// validateHTTP01 fetches the http-01 challenge response of the token from the
+// domain and checks that it is the key authorization of the account key.
+func validateHTTP01(ctx context.Context, domain string, token string, thumbprint string) error {
+	ctx, cancel := context.WithTimeout(ctx, acmeChallengeTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+domain+"/.well-known/acme-challenge/"+token, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("unable to fetch the challenge response: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the challenge response has status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, acmeMaxChallengeResponse))
+	if err != nil {
+		return fmt.Errorf("unable to read the challenge response: %w", err)
+	}
+	if keyAuthorization := token + "." + thumbprint; strings.TrimSpace(string(body)) != keyAuthorization {
+		return fmt.Errorf("the challenge response does not match the key authorization %q", keyAuthorization)
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const kf_renew_path = "/Enrollment/Renew"
+
+const (
+	// autoRenewLogStoragePrefix records the last automatic renewal attempt
+	// for a certificate, keyed by the serial of the renewed certificate.
+	autoRenewLogStoragePrefix = "auto_renew_log/"
+
+	// defaultRenewBefore is used for roles with auto_renew set and no renew_before.
+	defaultRenewBefore = 30 * 24 * time.Hour
+
+	// autoRenewRetryInterval is the minimum time between failed renewal
+	// attempts for a certificate.
+	autoRenewRetryInterval = 24 * time.Hour
+)
+
+// autoRenewLogEntry records an automatic renewal attempt.
+type autoRenewLogEntry struct {
+	AttemptedAt time.Time `json:"attempted_at"`
+	NewSerial   string    `json:"new_serial,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// keyfactorRenewRequest is the body of a Keyfactor renewal request.
+type keyfactorRenewRequest struct {
+	CertificateId int `json:"CertificateId"`
+}
+
+// keyfactorRenewResponse is the subset of a Keyfactor renewal response
+// identifying the renewed certificate.
+type keyfactorRenewResponse struct {
+	Thumbprint string `json:"Thumbprint"`
+}
+
+// autoRenewCerts renews the stored, non-revoked certificates of roles with
+// auto_renew set that expire within the role's renew_before.  Attempts are
+// recorded under autoRenewLogStoragePrefix so a certificate is renewed once,
+// and failed renewals are retried after autoRenewRetryInterval.
+func (b *keyfactorBackend) autoRenewCerts(ctx context.Context, s logical.Storage) error {
+	config, err := b.fetchConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	serials, err := s.List(ctx, "certs/")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	roles := map[string]*roleEntry{}
+	var due []string
+	dueRoles := map[string]*roleEntry{}
+	for _, serial := range serials {
+		info, err := getCertInfo(ctx, s, serial)
+		if err != nil {
+			return err
+		}
+		if info == nil || info.Role == "" {
+			continue
+		}
+		role, ok := roles[info.Role]
+		if !ok {
+			role, err = b.getRole(ctx, s, info.Role)
+			if err != nil {
+				return err
+			}
+			roles[info.Role] = role
+		}
+		if role == nil || !role.AutoRenew {
+			continue
+		}
+
+		certEntry, err := s.Get(ctx, "certs/"+serial)
+		if err != nil {
+			return err
+		}
+		if certEntry == nil {
+			continue
+		}
+		cert, err := parseCertificatePEM(certEntry.Value)
+		if err != nil {
+			b.Logger().Debug("unable to parse stored certificate while checking for renewal", "serial", serial, "error", err)
+			continue
+		}
+		renewBefore := role.RenewBefore
+		if renewBefore <= 0 {
+			renewBefore = defaultRenewBefore
+		}
+		if cert.NotAfter.Before(now) || cert.NotAfter.Sub(now) > renewBefore {
+			continue
+		}
+
+		revokedEntry, err := s.Get(ctx, "revoked/"+serial)
+		if err != nil {
+			return err
+		}
+		if revokedEntry != nil {
+			continue
+		}
+
+		logEntry, err := s.Get(ctx, autoRenewLogStoragePrefix+serial)
+		if err != nil {
+			return err
+		}
+		if logEntry != nil {
+			var last autoRenewLogEntry
+			if err := logEntry.DecodeJSON(&last); err != nil {
+				return err
+			}
+			if last.NewSerial != "" || now.Sub(last.AttemptedAt) < autoRenewRetryInterval {
+				continue
+			}
+		}
+		due = append(due, serial)
+		dueRoles[serial] = role
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, config.listWorkers())
+	for _, serial := range due {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(serial string, role *roleEntry) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			attempt := autoRenewLogEntry{AttemptedAt: time.Now()}
+			newSerial, err := b.renewCert(ctx, s, serial)
+			if err != nil {
+				b.Logger().Warn("automatic renewal failed", "serial", serial, "error", err)
+				attempt.Error = err.Error()
+			} else {
+				b.Logger().Info("automatically renewed certificate", "serial", serial, "new_serial", newSerial)
+				attempt.NewSerial = newSerial
+				if role.RevokeOnRenew != nil && *role.RevokeOnRenew {
+					b.revokeRenewedCert(ctx, s, serial)
+				}
+			}
+
+			entry, err := logical.StorageEntryJSON(autoRenewLogStoragePrefix+serial, attempt)
+			if err == nil {
+				err = s.Put(ctx, entry)
+			}
+			if err != nil {
+				b.Logger().Error("unable to record automatic renewal attempt", "serial", serial, "error", err)
+			}
+		}(serial, dueRoles[serial])
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// revokeRenewedCert revokes a certificate that has been renewed, with the
+// superseded reason.  Failures are logged, as the renewal itself succeeded.
+func (b *keyfactorBackend) revokeRenewedCert(ctx context.Context, s logical.Storage, serial string) {
+	resp, err := revokeCert(ctx, b, &logical.Request{Storage: s}, serial, revocationReasonSuperseded, false)
+	if err == nil && resp != nil && resp.IsError() {
+		err = resp.Error()
+	}
+	if err != nil {
+		b.Logger().Warn("unable to revoke the renewed certificate", "serial", serial, "error", err)
+		return
+	}
+	b.Logger().Info("revoked the renewed certificate as superseded", "serial", serial)
+}
+
+// renewCert renews a certificate in Keyfactor on the instance that issued it
+// and stores the renewed certificate with the issuance details of the
+// original.  It returns the serial of the renewed certificate.
+func (b *keyfactorBackend) renewCert(ctx context.Context, s logical.Storage, serial string) (string, error) {
+	req := &logical.Request{Storage: s}
+
+	kfIdEntry, err := s.Get(ctx, "kfId/"+serial)
+	if err != nil {
+		return "", err
+	}
+	if kfIdEntry == nil {
+		return "", fmt.Errorf("no Keyfactor certificate ID is stored for serial %s", serial)
+	}
+	keyfactorId, err := decodeKeyfactorId(kfIdEntry)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse stored certificate ID for serial %s: %w", serial, err)
+	}
+	instance, err := getCertInstance(ctx, s, serial)
+	if err != nil {
+		return "", err
+	}
+	info, err := getCertInfo(ctx, s, serial)
+	if err != nil {
+		return "", err
+	}
+	if info == nil {
+		return "", fmt.Errorf("no issuance details are stored for serial %s", serial)
+	}
+
+	body, err := b.sendJSONToInstance(ctx, req, instance, http.MethodPost, kf_renew_path, keyfactorRenewRequest{CertificateId: keyfactorId})
+	if err != nil {
+		return "", err
+	}
+	var renewed keyfactorRenewResponse
+	if err := json.Unmarshal(body, &renewed); err != nil {
+		return "", fmt.Errorf("unable to parse renewal response: %w", err)
+	}
+	if renewed.Thumbprint == "" {
+		return "", errors.New("renewal response did not identify the renewed certificate")
+	}
+
+	// look up the renewed certificate by its thumbprint
+	config, err := b.fetchConfig(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	instanceConfig, err := config.instanceConfig(instance)
+	if err != nil {
+		return "", err
+	}
+	client, err := b.getInstanceClient(ctx, s, instance)
+	if err != nil {
+		return "", fmt.Errorf("error getting client: %w", err)
+	}
+	var results KeyfactorCertResponse
+	query := url.QueryEscape(fmt.Sprintf(`Thumbprint -eq "%s"`, renewed.Thumbprint))
+	if err := b.getKeyfactorJSON(ctx, s, client, instanceConfig, "/Certificates?pq.queryString="+query, &results); err != nil {
+		return "", err
+	}
+	if len(results) == 0 {
+		return "", fmt.Errorf("renewed certificate with thumbprint %s was not found in Keyfactor", renewed.Thumbprint)
+	}
+	result := results[0]
+	der, err := base64.StdEncoding.DecodeString(result.ContentBytes)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode the renewed certificate: %w", err)
+	}
+	if _, err := x509.ParseCertificate(der); err != nil {
+		return "", fmt.Errorf("unable to parse the renewed certificate: %w", err)
+	}
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+
+	err = b.storeIssuedCert(ctx, s, result.SerialNumber, certPEM, result.ID, 0, &certInfo{
+		Role:     info.Role,
+		CA:       info.CA,
+		Template: info.Template,
+		Instance: instance,
+		IssuedAt: time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return normalizeSerial(result.SerialNumber), nil
+}
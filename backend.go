@@ -10,18 +10,19 @@
 package kfbackend
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/errutil"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -45,17 +46,70 @@ type keyfactorBackend struct {
 	configLock   sync.RWMutex
 	cachedConfig *keyfactorConfig
 	client       *keyfactorClient
+
+	// instanceClients caches clients for the named instances in the configuration
+	instanceClients map[string]*keyfactorClient
+
+	// breaker guards outbound calls to Keyfactor
+	breaker circuitBreaker
+
+	expiryScanLock sync.Mutex
+	lastExpiryScan time.Time
+
+	// crlPushLock guards lastCRLFetch, when fetchAndPushCRL last ran
+	crlPushLock  sync.Mutex
+	lastCRLFetch time.Time
+
+	// activeIssuances counts the enrollments in progress
+	activeIssuances int64
+	// issuedTodayLock serializes updates of the daily issuance counters
+	issuedTodayLock sync.Mutex
+	// quotaLock serializes updates of the role issuance quota counters
+	quotaLock sync.Mutex
+	// nonceLock serializes checks of the used CSR nonces and ACME nonces
+	nonceLock sync.Mutex
+	// acmeLock serializes the finalization of ACME orders
+	acmeLock sync.Mutex
+	// roleCertLocks serialize the issuances of roles with max_stored_certs
+	// set, keyed by role name
+	roleCertLocks []*locksutil.LockEntry
+	// statsLock serializes updates of the daily issuance and revocation
+	// counters read by stats/history
+	statsLock sync.Mutex
+
+	// featuresLock guards cachedFeatures, the state of the feature flags
+	featuresLock   sync.RWMutex
+	cachedFeatures map[string]bool
+
+	// webhookQueue holds the webhook deliveries for the workers started by
+	// startWebhookWorkers
+	webhookQueue       chan webhookDelivery
+	webhookWorkersOnce sync.Once
 }
 
 // keyfactorBackend defines the target API keyfactorBackend
 // for Vault. It must include each path
 // and the secrets it will store.
 func backend() *keyfactorBackend {
-	var b = keyfactorBackend{}
+	var b = keyfactorBackend{
+		roleCertLocks: locksutil.CreateLocks(),
+	}
 
 	b.Backend = &framework.Backend{
 		Help: strings.TrimSpace(keyfactorHelp),
 		PathsSpecial: &logical.Paths{
+			Unauthenticated: []string{
+				"ocsp",
+				"acme/*",
+			},
+			Root: []string{
+				"sign-verbatim",
+				"sign-verbatim/*",
+				"cache/flush",
+				"import/bulk",
+				"sign-intermediate",
+				"config/reset",
+			},
 			LocalStorage: []string{},
 			SealWrapStorage: []string{
 				"config",
@@ -64,14 +118,46 @@ func backend() *keyfactorBackend {
 		},
 		Paths: framework.PathAppend(
 			pathConfig(&b),
+			pathConfigMigrate(&b),
+			pathConfigFeatures(&b),
+			pathConfigRotateCredentials(&b),
+			pathConfigReset(&b),
 			pathRoles(&b),
+			pathRoleMetadata(&b),
+			pathRoleExport(&b),
+			pathRolePolicy(&b),
+			pathProfilePolicies(&b),
 			pathCA(&b),
+			pathCAs(&b),
+			pathTemplateCerts(&b),
+			pathInfo(&b),
 			pathCerts(&b),
+			pathCertStatus(&b),
+			pathCertVerify(&b),
+			pathCertCompliance(&b),
+			pathEntityCerts(&b),
+			pathRevokeCN(&b),
+			pathSuspend(&b),
+			pathTidy(&b),
+			pathWarmUp(&b),
+			pathCache(&b),
+			pathQuota(&b),
+			pathStats(&b),
+			pathWebhooks(&b),
+			pathImport(&b),
+			pathSignIntermediate(&b),
+			pathSignSpiffe(&b),
+			pathSearch(&b),
+			pathOCSP(&b),
+			pathACME(&b),
 		),
-		Secrets:        []*framework.Secret{},
+		Secrets: []*framework.Secret{
+			secretCerts(&b),
+		},
 		BackendType:    logical.TypeLogical,
 		Invalidate:     b.invalidate,
 		InitializeFunc: b.Initialize,
+		PeriodicFunc:   b.periodicFunc,
 	}
 	return &b
 }
@@ -83,23 +169,42 @@ func (b *keyfactorBackend) reset() {
 	defer b.configLock.RUnlock()
 	b.cachedConfig = nil
 	b.client = nil
+	b.instanceClients = nil
+	b.breaker.reset()
 
+	b.featuresLock.Lock()
+	b.cachedFeatures = nil
+	b.featuresLock.Unlock()
 }
 
 func (b *keyfactorBackend) Initialize(ctx context.Context, req *logical.InitializationRequest) error {
-	b.configLock.RLock()
-	defer b.configLock.RUnlock()
 	if req == nil {
 		return fmt.Errorf("initialization request is nil")
 	}
+	b.logKeyfactorVersion(ctx, req.Storage)
+
+	// complete issuances interrupted by the previous shutdown
+	s, err := b.scopedStorage(ctx, req.Storage)
+	if err != nil {
+		b.Logger().Warn("unable to load the configuration to recover interrupted issuances", "error", err)
+		return nil
+	}
+	if err := b.processIssueWAL(ctx, s, 0); err != nil {
+		b.Logger().Warn("unable to recover interrupted issuances; they are retried periodically", "error", err)
+	}
 	return nil
 }
 
 // invalidate clears an existing client configuration in
 // the backend
 func (b *keyfactorBackend) invalidate(ctx context.Context, key string) {
-	if key == "config" {
+	switch key {
+	case configPath:
 		b.reset()
+	case featuresPath:
+		b.featuresLock.Lock()
+		b.cachedFeatures = nil
+		b.featuresLock.Unlock()
 	}
 }
 
@@ -129,88 +234,192 @@ func (b *keyfactorBackend) getClient(ctx context.Context, s logical.Storage) (*k
 	return b.client, nil
 }
 
-// Handle interface with Keyfactor API to enroll a certificate with given content
-func (b *keyfactorBackend) submitCSR(ctx context.Context, req *logical.Request, csr string, caName string, templateName string, metaDataJson string) ([]string, string, error) {
+// kf_enroll_path is the default of the enroll_api_path configuration field.
+const kf_enroll_path = "/Enrollment/CSR"
+
+// Handle interface with Keyfactor API to enroll a certificate with given content.
+// Returns the certificates, leaf first, the serial number and the Keyfactor
+// enrollment request ID.  With verify set, the certificate is downloaded from
+// Keyfactor and compared with the enrollment response before it is stored.
+func (b *keyfactorBackend) submitCSR(ctx context.Context, req *logical.Request, roleName string, csr string, caName string, templateName string, metaDataJson string, customFields map[string]string, comment string, verify bool) ([]string, string, int, error) {
 	config, err := b.fetchConfig(ctx, req.Storage)
 	if err != nil {
-		return nil, "", err
+		return nil, "", 0, err
 	}
 	if config == nil {
-		return nil, "", errors.New("configuration is empty")
+		return nil, "", 0, errors.New("configuration is empty")
+	}
+
+	target, err := config.resolveEnrollmentTarget(caName, templateName)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	caName = target.CA
+	templateName = target.Template
+
+	var role *roleEntry
+	if roleName != "" {
+		role, err = b.getRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		// the CA and custom field restrictions come from the role the
+		// requested role delegates to, if any
+		if role != nil {
+			issuingRole, err := b.delegatedRole(ctx, req.Storage, role)
+			if err != nil {
+				return nil, "", 0, err
+			}
+			if !issuingRole.allowsCA(caName) {
+				return nil, "", 0, errutil.UserError{Err: fmt.Sprintf("role %q is not allowed to enroll with CA %q", roleName, caName)}
+			}
+			if err := checkCustomFields(issuingRole, customFields); err != nil {
+				return nil, "", 0, err
+			}
+		}
+	}
+
+	if err := b.checkProfilePolicy(ctx, req, templateName); err != nil {
+		return nil, "", 0, err
+	}
+
+	if err := checkMetadataLimits(config, metaDataJson); err != nil {
+		return nil, "", 0, err
+	}
+	if err := validateMetadata(config, metaDataJson); err != nil {
+		return nil, "", 0, err
+	}
+
+	release, err := b.acquireIssuance(ctx, req.Storage, config)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer release()
+
+	// the issuance is counted against the quota of the role once every
+	// check has passed, and refunded if the certificate is not issued
+	issued := false
+	if role != nil {
+		if err := b.consumeRoleQuota(ctx, req.Storage, roleName, role); err != nil {
+			return nil, "", 0, err
+		}
+		defer func() {
+			if issued {
+				return
+			}
+			if err := b.refundRoleQuota(ctx, req.Storage, roleName, role); err != nil {
+				b.Logger().Warn("unable to refund the issuance quota of the role", "role", roleName, "error", err)
+			}
+		}()
+	}
+
+	// slow templates may be given longer than the operation timeout
+	templateTimeout, hasTemplateTimeout := config.TemplateTimeouts[templateName]
+	if hasTemplateTimeout {
+		ctx = withOperationTimeout(ctx, templateTimeout)
+	}
+
+	metaDataJson, err = b.withEntityMetadata(req, config, metaDataJson)
+	if err != nil {
+		return nil, "", 0, err
 	}
+	comment = enrollmentComment(req, config, comment)
+	requester := b.enrollmentRequester(req, config)
+	b.Logger().Debug(fmt.Sprintf("routing enrollment to instance %q with ca %q and template %q", target.Instance, caName, templateName))
 
 	location, _ := time.LoadLocation("UTC")
 	t := time.Now().In(location)
 	time := t.Format("2006-01-02T15:04:05")
 
 	// get client
-	client, err := b.getClient(ctx, req.Storage)
+	client, err := b.getInstanceClient(ctx, req.Storage, target.Instance)
 	if err != nil {
-		return nil, "", fmt.Errorf("error getting client: %w", err)
+		return nil, "", 0, fmt.Errorf("error getting client: %w", err)
 	}
+	config = target.Config
 
 	b.Logger().Debug("Closing idle connections")
 	client.httpClient.CloseIdleConnections()
 
 	// build request parameter structure
 
-	url := config.KeyfactorUrl + "/" + config.CommandAPIPath + "/Enrollment/CSR"
+	url := config.apiURL(config.enrollAPIPath())
 	b.Logger().Debug("url: " + url)
-	bodyContent := "{\"CSR\": \"" + csr + "\",\"CertificateAuthority\":\"" + caName + "\",\"IncludeChain\": true, \"Metadata\": " + metaDataJson + ", \"Timestamp\": \"" + time + "\",\"Template\": \"" + templateName + "\",\"SANs\": {}}"
-	payload := strings.NewReader(bodyContent)
-	b.Logger().Debug("body: " + bodyContent)
-	httpReq, err := http.NewRequest("POST", url, payload)
+	format := config.EnrollmentFormat
+	if format == "" {
+		format = enrollmentFormatJSON
+	}
+	var bodyContent []byte
+	contentType := "application/json"
+	if format == enrollmentFormatMultipart {
+		if len(customFields) > 0 {
+			return nil, "", 0, errutil.UserError{Err: "custom_fields are not supported with the multipart_form enrollment format"}
+		}
+		bodyContent, contentType, err = multipartEnrollmentBody(config, csr)
+		if err != nil {
+			return nil, "", 0, err
+		}
+	} else {
+		additionalFields := ""
+		if len(customFields) > 0 {
+			fieldsJson, err := json.Marshal(customFields)
+			if err != nil {
+				return nil, "", 0, err
+			}
+			additionalFields = ", \"AdditionalEnrollmentFields\": " + string(fieldsJson)
+		}
+		commentJson, err := json.Marshal(comment)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		requesterJson, err := json.Marshal(requester)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		bodyContent = []byte("{\"CSR\": \"" + csr + "\",\"CertificateAuthority\":\"" + caName + "\",\"IncludeChain\": true, \"Metadata\": " + metaDataJson + additionalFields + ", \"Comment\": " + string(commentJson) + ", \"Requester\": " + string(requesterJson) + ", \"Timestamp\": \"" + time + "\",\"Template\": \"" + templateName + "\",\"SANs\": {}}")
+		b.Logger().Debug("body: " + string(bodyContent))
+	}
+	payload := bytes.NewReader(bodyContent)
+	reqCtx, cancel := keyfactorRequestContext(ctx)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(reqCtx, "POST", url, payload)
 
 	if err != nil {
 		b.Logger().Info("Error forming request: {{err}}", err)
+		return nil, "", 0, err
 	}
 
 	httpReq.Header.Add("x-keyfactor-requested-with", "APIClient")
-	httpReq.Header.Add("content-type", "application/json")
+	httpReq.Header.Add("content-type", contentType)
 	httpReq.Header.Add("x-certificateformat", "PEM")
 
 	// Send request and check status
 
 	b.Logger().Debug("About to connect to " + config.KeyfactorUrl + "for csr submission")
-	res, err := client.httpClient.Do(httpReq)
+	_, body, err := b.sendKeyfactorRequestWithRetries(ctx, req.Storage, client, httpReq, config.enrollmentMaxRetries())
 	if err != nil {
-		b.Logger().Info("CSR Enrollment failed: {{err}}", err.Error())
-		return nil, "", err
-	}
-	if res.StatusCode != 200 {
-		b.Logger().Error("CSR Enrollment failed: server returned" + fmt.Sprint(res.StatusCode))
-		defer res.Body.Close()
-		body, _ := io.ReadAll(res.Body)
-		b.Logger().Error("Error response: " + string(body[:]))
-		return nil, "", fmt.Errorf("CSR Enrollment request failed with status code %d and error: "+string(body[:]), res.StatusCode)
+		b.Logger().Error("CSR Enrollment failed: " + err.Error())
+		if hasTemplateTimeout && errors.Is(err, context.DeadlineExceeded) {
+			return nil, "", 0, errutil.UserError{Err: fmt.Sprintf("keyfactor request timed out after the %s configured for template %s", templateTimeout, templateName)}
+		}
+		return nil, "", 0, err
 	}
 
-	// Read response and return certificate and key
-
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
+	// Parse response
+	b.Logger().Debug("response = " + string(body))
+	certs, serial, kfId, requestId, err := parseEnrollmentResponse(body, format)
 	if err != nil {
-		b.Logger().Error("Error reading response: {{err}}", err)
-		return nil, "", err
+		b.Logger().Error("CSR Enrollment returned an unexpected response: " + err.Error())
+		return nil, "", 0, err
 	}
 
-	// Parse response
-	var r map[string]interface{}
-	json.Unmarshal(body, &r)
-	b.Logger().Debug("response = ", r)
+	b.Logger().Debug("parsed response", "serial", serial, "certificates", len(certs))
 
-	inner := r["CertificateInformation"].(map[string]interface{})
-	certI := inner["Certificates"].([]interface{})
-	certs := make([]string, len(certI))
-	for i, v := range certI {
-		certs[i] = v.(string)
-		start := strings.Index(certs[i], "-----BEGIN CERTIFICATE-----")
-		certs[i] = certs[i][start:]
+	if verify {
+		if err := b.verifyEnrollment(ctx, req.Storage, client, config, int(kfId), certs[0]); err != nil {
+			return nil, "", 0, err
+		}
 	}
-	serial := inner["SerialNumber"].(string)
-	kfId := inner["KeyfactorID"].(float64)
-
-	b.Logger().Debug("parsed response: ", certI...)
 
 	if err != nil {
 		b.Logger().Error("unable to parse ca_chain response", fmt.Sprint(err))
@@ -225,35 +434,77 @@ func (b *keyfactorBackend) submitCSR(ctx context.Context, req *logical.Request,
 		b.Logger().Error("error storing the ca_chain locally", err)
 	}
 
-	key := "certs/" + normalizeSerial(serial)
-
-	entry := &logical.StorageEntry{
-		Key:   key,
-		Value: []byte(certs[0]),
-	}
-
-	b.Logger().Debug("cert entry.Value = ", string(entry.Value))
-
-	err = req.Storage.Put(ctx, entry)
+	err = b.storeIssuedCert(ctx, req.Storage, serial, certs[0], int(kfId), int(requestId), &certInfo{
+		Role:       roleName,
+		CA:         caName,
+		Template:   templateName,
+		Instance:   target.Instance,
+		IssuedAt:   t,
+		EntityID:   req.EntityID,
+		IssuerName: target.issuerName(),
+	})
 	if err != nil {
-		return nil, "", errwrap.Wrapf("unable to store certificate locally: {{err}}", err)
+		return nil, "", 0, err
 	}
 
-	kfIdEntry, err := logical.StorageEntryJSON("kfId/"+normalizeSerial(serial), kfId)
-	if err != nil {
-		return nil, "", err
+	if err := b.countIssuance(ctx, req.Storage, roleName); err != nil {
+		b.Logger().Warn("unable to count the issuance for the role", "role", roleName, "error", err)
 	}
-
-	err = req.Storage.Put(ctx, kfIdEntry)
-	if err != nil {
-		return nil, "", errwrap.Wrapf("unable to store the keyfactor ID for the certificate locally: {{err}}", err)
+	if err := b.countDailyStat(ctx, req.Storage, statsEventIssued); err != nil {
+		b.Logger().Warn("unable to count the issuance in the daily statistics", "error", err)
 	}
+	b.notifyWebhooksForCert(ctx, req.Storage, webhookEventIssue, normalizeSerial(serial), []byte(certs[0]))
 
-	return certs, serial, nil
+	issued = true
+	return certs, serial, int(requestId), nil
 }
 
 const keyfactorHelp = `
 The Keyfactor backend is a pki service that issues and manages certificates.
+
+Certificates are enrolled with Keyfactor Command and stored in Vault. The main paths are:
+
+  config              Connection and behaviour settings for Keyfactor.
+  config/features     Enable or disable optional features.
+  config/rotate-credentials
+                      Replace the Keyfactor password or client secret.
+  config/reset        Reset the backend to factory defaults (requires sudo).
+  roles/<name>        Policies restricting the certificates a role may request.
+  roles/<name>/update-metadata
+                      Update the Keyfactor metadata of a role's certificates.
+  roles/<name>/export-bundle
+                      Export a role's certificates as PKCS#7 or a PEM bundle.
+  roles/<name>/policy Generate a Vault policy for issuing with a role.
+  issue/<role>        Generate a key and issue a certificate for it.
+  sign/<role>         Issue a certificate for a CSR.
+  sign-verbatim       Issue a certificate for a CSR without role validation.
+  sign-intermediate   Sign an intermediate CA certificate.
+  sign-spiffe/<role>  Sign a SPIFFE SVID CSR in the trust domain of a role.
+  certs/              List, read and fetch the chain of stored certificates.
+  certs/<serial>/verify
+                      Verify a stored certificate against the CA chain.
+  certs/<serial>/compliance
+                      Check a stored certificate against the compliance rules.
+  certs/by-entity/<entity_id>
+                      List the certificates issued by a Vault entity.
+  revoke              Revoke a certificate by serial number.
+  revoke-cn           Revoke every certificate with a common name.
+  suspend, unsuspend  Place a certificate on hold and lift the hold.
+  ca, ca/chain        Fetch the CA certificate and chain.
+  cas/<ca>/templates  List the templates available on a CA.
+  cas/<ca>/roles      List the roles that may enroll with a CA.
+  templates/<t>/certs List the certificates issued with a template.
+  tidy                Clean up stale storage entries.
+  quota               Read resource limits and their current usage.
+  stats/history       Read the daily counts of issued and revoked certificates.
+  webhooks/<name>     Notify URLs of certificate issuance, revocation and expiry.
+  import/bulk         Register certificates already managed in Keyfactor.
+  search/by-metadata  Search Keyfactor for certificates by metadata value.
+  ocsp                Answer OCSP requests from the local revocation state.
+  acme/directory      Issue certificates with ACME clients such as certbot.
+
+Use "vault path-help" on a path for its parameters, and read sys/internal/specs/openapi
+for the OpenAPI specification of the mount.
 `
 
 func (b *keyfactorBackend) isValidJSON(str string) bool {
@@ -0,0 +1,81 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// importCertFromKeyfactor looks up a certificate that is not stored locally in
+// Keyfactor by its serial number and stores it, so later reads are served from
+// storage.  It returns nil if Keyfactor has no certificate with the serial.
+func (b *keyfactorBackend) importCertFromKeyfactor(ctx context.Context, req *logical.Request, serial string) (*logical.StorageEntry, error) {
+	hexSerial := strings.ToUpper(strings.NewReplacer(":", "", "-", "").Replace(serial))
+	want, ok := new(big.Int).SetString(hexSerial, 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid serial number %q", serial)
+	}
+
+	config, err := b.fetchConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("unable to load configuration")
+	}
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	var results KeyfactorCertResponse
+	query := url.QueryEscape(fmt.Sprintf(`SerialNumber -eq "%s"`, hexSerial))
+	if err := b.getKeyfactorJSON(ctx, req.Storage, client, config, "/Certificates?pq.queryString="+query, &results); err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		content, err := fetchCertFromKeyfactor(ctx, req, b, fmt.Sprintf("%d", result.ID), false)
+		if err != nil {
+			return nil, err
+		}
+		certBytes, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			continue
+		}
+		cert, err := parseCertificatePEM(certBytes)
+		if err != nil || cert.SerialNumber.Cmp(want) != 0 {
+			continue
+		}
+
+		key := normalizeSerial(serial)
+		template, _ := result.TemplateName.(string)
+		err = b.storeIssuedCert(ctx, req.Storage, key, string(certBytes), result.ID, 0, &certInfo{
+			CA:       result.CertificateAuthorityName,
+			Template: template,
+			IssuedAt: cert.NotBefore,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		b.Logger().Info("imported certificate from Keyfactor", "serial", key, "keyfactor_id", result.ID)
+		return &logical.StorageEntry{Key: "certs/" + key, Value: certBytes}, nil
+	}
+	return nil, nil
+}
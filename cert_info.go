@@ -0,0 +1,161 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// certInfoStoragePrefix is where details about how a certificate was issued
+// are stored, keyed by serial number.
+const certInfoStoragePrefix = "certinfo/"
+
+// certInfo records how a certificate managed by this backend was issued.
+type certInfo struct {
+	Role     string    `json:"role"`
+	CA       string    `json:"ca"`
+	Template string    `json:"template"`
+	Instance string    `json:"instance,omitempty"`
+	IssuedAt time.Time `json:"issued_at"`
+	EntityID string    `json:"entity_id,omitempty"`
+
+	// IssuerName is the configured name of the issuer of the certificate.
+	IssuerName string `json:"issuer_name,omitempty"`
+}
+
+// getCertInfo returns the issuance details for the certificate with the
+// given serial, or nil if none were recorded.
+func getCertInfo(ctx context.Context, s logical.Storage, serial string) (*certInfo, error) {
+	entry, err := s.Get(ctx, certInfoStoragePrefix+normalizeSerial(serial))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	info := &certInfo{}
+	if err := entry.DecodeJSON(info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// certIssuerName returns the issuer name recorded for the certificate with
+// the given serial, or "" if none was recorded.
+func certIssuerName(ctx context.Context, s logical.Storage, serial string) (string, error) {
+	info, err := getCertInfo(ctx, s, serial)
+	if err != nil || info == nil {
+		return "", err
+	}
+	return info.IssuerName, nil
+}
+
+// putCertInfo stores the issuance details for the certificate with the given serial.
+func putCertInfo(ctx context.Context, s logical.Storage, serial string, info *certInfo) error {
+	entry, err := logical.StorageEntryJSON(certInfoStoragePrefix+normalizeSerial(serial), info)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// templateCertsStoragePrefix indexes the serial numbers of the certificates
+// issued with each Keyfactor template, as template_certs/<template>/<serial>.
+const templateCertsStoragePrefix = "template_certs/"
+
+// indexTemplateCert records that the certificate with the given serial was
+// issued with the template.
+func indexTemplateCert(ctx context.Context, s logical.Storage, template string, serial string) error {
+	if template == "" {
+		return nil
+	}
+	return s.Put(ctx, &logical.StorageEntry{
+		Key: templateCertsStoragePrefix + template + "/" + normalizeSerial(serial),
+	})
+}
+
+// entityCertsStoragePrefix is where the serial numbers of the certificates
+// issued by each Vault entity are indexed, as entity_certs/<entity_id>/<serial>.
+const entityCertsStoragePrefix = "entity_certs/"
+
+// indexEntityCert records that the certificate with the given serial was
+// issued by the Vault entity.
+func indexEntityCert(ctx context.Context, s logical.Storage, entityId string, serial string) error {
+	if entityId == "" {
+		return nil
+	}
+	return s.Put(ctx, &logical.StorageEntry{
+		Key: entityCertsStoragePrefix + entityId + "/" + normalizeSerial(serial),
+	})
+}
+
+// storeIssuedCert stores a certificate enrolled in Keyfactor along with its
+// Keyfactor certificate ID and, if known, enrollment request ID, the instance
+// that issued it and its issuance details.
+func (b *keyfactorBackend) storeIssuedCert(ctx context.Context, s logical.Storage, serial string, certPEM string, kfId int, requestId int, info *certInfo) error {
+	key := normalizeSerial(serial)
+	config, err := b.fetchConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	err = s.Put(ctx, &logical.StorageEntry{
+		Key:   "certs/" + key,
+		Value: []byte(certPEM),
+	})
+	if err != nil {
+		return errwrap.Wrapf("unable to store certificate locally: {{err}}", err)
+	}
+
+	kfIdEntry, err := keyfactorIdStorageEntry(key, kfId, requestId, config.keyfactorIdFormat())
+	if err != nil {
+		return err
+	}
+	err = s.Put(ctx, kfIdEntry)
+	if err != nil {
+		return errwrap.Wrapf("unable to store the keyfactor ID for the certificate locally: {{err}}", err)
+	}
+
+	if info.Instance != "" {
+		instanceEntry, err := logical.StorageEntryJSON(instanceStoragePrefix+key, info.Instance)
+		if err != nil {
+			return err
+		}
+		err = s.Put(ctx, instanceEntry)
+		if err != nil {
+			return errwrap.Wrapf("unable to store the keyfactor instance for the certificate locally: {{err}}", err)
+		}
+	}
+
+	err = putCertInfo(ctx, s, key, info)
+	if err != nil {
+		return errwrap.Wrapf("unable to store the issuance details for the certificate locally: {{err}}", err)
+	}
+
+	err = indexTemplateCert(ctx, s, info.Template, key)
+	if err != nil {
+		return errwrap.Wrapf("unable to index the certificate by template locally: {{err}}", err)
+	}
+
+	err = indexEntityCert(ctx, s, info.EntityID, key)
+	if err != nil {
+		return errwrap.Wrapf("unable to index the certificate by entity locally: {{err}}", err)
+	}
+
+	err = indexRoleCert(ctx, s, info.Role, key)
+	if err != nil {
+		return errwrap.Wrapf("unable to index the certificate by role locally: {{err}}", err)
+	}
+	return nil
+}
@@ -12,6 +12,8 @@ package kfbackend
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -22,10 +24,11 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -69,7 +72,7 @@ func fetchCAInfo(ctx context.Context, req *logical.Request, b *keyfactorBackend)
 
 	// with the certificate Id, we can retreive and store the CA certificate from Keyfactor
 
-	caCert, err := fetchCertFromKeyfactor(ctx, req, b, caId, false)
+	caCert, err := fetchCACertFromKeyfactor(ctx, req, b, caId, false)
 	if err != nil {
 		return nil, errutil.InternalError{Err: fmt.Sprintf("error getting certificate from Keyfactor: %s", err)}
 	}
@@ -123,7 +126,7 @@ func fetchCaChainInfo(ctx context.Context, req *logical.Request, b *keyfactorBac
 
 	// with the certificate Id, we can retreive and store the CA certificate from Keyfactor
 
-	caCert, err := fetchCertFromKeyfactor(ctx, req, b, caId, true)
+	caCert, err := fetchCACertFromKeyfactor(ctx, req, b, caId, true)
 	if err != nil {
 		return nil, errutil.InternalError{Err: fmt.Sprintf("error getting certificate from Keyfactor: %s", err)}
 	}
@@ -163,13 +166,18 @@ func getCAId(ctx context.Context, req *logical.Request, b *keyfactorBackend) (st
 		return "", nil
 	}
 
-	ca_name := strings.Split(config.CertAuthority, `\\`)[1]
+	caParts := strings.Split(config.CertAuthority, `\\`)
+	if len(caParts) < 2 {
+		return "", fmt.Errorf("the configured CA %q is not in the format <host\\\\logical name>", config.CertAuthority)
+	}
+	ca_name := caParts[1]
 
 	// This is only needed when running as a vault extension
 	b.Logger().Debug("Closing idle connections")
 	client, err := b.getClient(ctx, req.Storage)
 	if err != nil {
 		b.Logger().Error("unable to create the http client")
+		return "", err
 	}
 	client.httpClient.CloseIdleConnections()
 
@@ -180,7 +188,7 @@ func getCAId(ctx context.Context, req *logical.Request, b *keyfactorBackend) (st
 
 	// Build request
 
-	url := config.KeyfactorUrl + "/" + config.CommandAPIPath + "/Certificates?pq.queryString=CA%20-eq%20%22" + ca_name + "%22%20AND%20CertState%20-eq%20%226%22" // CertState 6 = cert
+	url := config.apiURL("/Certificates?pq.queryString=CA%20-eq%20%22" + ca_name + "%22%20AND%20CertState%20-eq%20%226%22") // CertState 6 = cert
 	b.Logger().Debug("url: " + url)
 	httpReq, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -192,7 +200,7 @@ func getCAId(ctx context.Context, req *logical.Request, b *keyfactorBackend) (st
 
 	// Send request and check status
 	b.Logger().Debug("About to connect to " + config.KeyfactorUrl + "for ca retrieval")
-	res, err := client.httpClient.Do(httpReq)
+	res, err := b.sendRequest(ctx, req.Storage, client, httpReq)
 	if err != nil {
 		b.Logger().Info("failed getting CA: {{err}}", err)
 		return "", err
@@ -200,7 +208,7 @@ func getCAId(ctx context.Context, req *logical.Request, b *keyfactorBackend) (st
 	if res.StatusCode != 200 {
 		b.Logger().Error("request failed: server returned" + fmt.Sprint(res.StatusCode))
 		defer res.Body.Close()
-		body, err := io.ReadAll(res.Body)
+		body, err := readKeyfactorResponse(ctx, config, res.Body)
 		if err != nil {
 			b.Logger().Info("Error reading response: {{err}}", err)
 			return "", err
@@ -214,41 +222,131 @@ func getCAId(ctx context.Context, req *logical.Request, b *keyfactorBackend) (st
 
 	// Parse response
 	var r KeyfactorCertResponse
-	err = json.NewDecoder(res.Body).Decode(&r)
+	body, err := readKeyfactorResponse(ctx, config, res.Body)
+	if err != nil {
+		return "", err
+	}
+	err = json.Unmarshal(body, &r)
 	if err != nil {
-		panic(err)
+		return "", fmt.Errorf("unable to parse the CA certificate search response: %w", err)
 	}
 	b.Logger().Debug("response = ", r)
 
+	if len(r) == 0 {
+		return "", fmt.Errorf("no certificate found in Keyfactor for CA %s", config.CertAuthority)
+	}
+
 	return fmt.Sprintf("%d", r[0].ID), nil
 }
 
-// Generate keypair and CSR
-func (b *keyfactorBackend) generateCSR(cn string, ip_sans []string, dns_sans []string) (string, []byte) {
-	keyBytes, _ := rsa.GenerateKey(rand.Reader, 2048)
+// Orders of the relative distinguished names in a subject.
+const (
+	// dnOrderRFC4514 encodes C first and CN last, the order of pkix.Name.
+	dnOrderRFC4514 = "rfc4514"
+	// dnOrderLegacy encodes CN first and C last.
+	dnOrderLegacy = "legacy"
+)
+
+// oidEmailAddress is the OID of the PKCS #9 emailAddress subject attribute.
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// oidSubjectSerialNumber is the OID of the X.520 serialNumber subject attribute.
+var oidSubjectSerialNumber = asn1.ObjectIdentifier{2, 5, 4, 5}
+
+// maxSubjectSerialNumberLength is the upper bound of the serialNumber
+// attribute in RFC 5280.
+const maxSubjectSerialNumberLength = 64
+
+// validateSubjectSerialNumber checks that a subject serialNumber attribute
+// is within the length allowed by RFC 5280.
+func validateSubjectSerialNumber(sn string) error {
+	if len(sn) > maxSubjectSerialNumberLength {
+		return fmt.Errorf("subject_serial_number must be at most %d characters", maxSubjectSerialNumberLength)
+	}
+	return nil
+}
+
+// Generate an RSA keypair of keyBits bits and a CSR signed with
+// signatureAlgorithm.  An empty common name produces a CSR with an
+// empty subject, identified by its SANs alone.  A non-empty email address is
+// added to the subject as an emailAddress attribute, and a non-empty subject
+// serial number as a serialNumber attribute.  The relative distinguished
+// names of the subject are encoded in dnOrder.  The extended key usages of
+// keyUsagePurpose are requested unless the extensions already request them.
+func (b *keyfactorBackend) generateCSR(keyBits int, signatureAlgorithm x509.SignatureAlgorithm, dnOrder string, cn string, email string, subjectSerialNumber string, keyUsagePurpose string, ip_sans []string, dns_sans []string, extensions []pkix.Extension) (string, []byte, error) {
+	keyBytes, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to generate a %d-bit RSA key: %w", keyBits, err)
+	}
 	subj := pkix.Name{
 		CommonName: cn,
 	}
+	if email != "" {
+		subj.ExtraNames = append(subj.ExtraNames, pkix.AttributeTypeAndValue{
+			Type:  oidEmailAddress,
+			Value: email,
+		})
+	}
+	if subjectSerialNumber != "" {
+		subj.ExtraNames = append(subj.ExtraNames, pkix.AttributeTypeAndValue{
+			Type:  oidSubjectSerialNumber,
+			Value: subjectSerialNumber,
+		})
+	}
 	rawSubj := subj.ToRDNSequence()
-	asn1Subj, _ := asn1.Marshal(rawSubj)
+	if dnOrder == dnOrderLegacy {
+		slices.Reverse(rawSubj)
+	}
+	asn1Subj, err := asn1.Marshal(rawSubj)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to encode the subject: %w", err)
+	}
 	var netIPSans []net.IP
 	for i := range ip_sans {
 		netIPSans = append(netIPSans, net.ParseIP(ip_sans[i]))
 	}
 
+	if !slices.ContainsFunc(extensions, func(ext pkix.Extension) bool { return ext.Id.Equal(oidExtensionExtKeyUsage) }) {
+		extKeyUsage, err := extKeyUsageExtension(keyUsagePurpose)
+		if err != nil {
+			return "", nil, err
+		}
+		extensions = append(slices.Clone(extensions), extKeyUsage)
+	}
+
 	csrtemplate := x509.CertificateRequest{
 		RawSubject:         asn1Subj,
-		SignatureAlgorithm: x509.SHA256WithRSA,
+		SignatureAlgorithm: signatureAlgorithm,
 		IPAddresses:        netIPSans,
 		DNSNames:           dns_sans,
+		ExtraExtensions:    extensions,
+	}
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &csrtemplate, keyBytes)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create the CSR with signature algorithm %s: %w", signatureAlgorithm, err)
 	}
-	csrBytes, _ := x509.CreateCertificateRequest(rand.Reader, &csrtemplate, keyBytes)
 	csrBuf := new(bytes.Buffer)
 	pem.Encode(csrBuf, &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes})
-	return csrBuf.String(), x509.MarshalPKCS1PrivateKey(keyBytes)
+	return csrBuf.String(), x509.MarshalPKCS1PrivateKey(keyBytes), nil
 }
 
+// kf_download_path is the default of the fetch_cert_api_path and
+// ca_cert_api_path configuration fields.
+const kf_download_path = "/Certificates/Download"
+
+// fetchCertFromKeyfactor downloads an issued certificate from Keyfactor.
 func fetchCertFromKeyfactor(ctx context.Context, req *logical.Request, b *keyfactorBackend, kfCertId string, includeChain bool) (string, error) {
+	return downloadFromKeyfactor(ctx, req, b, (*keyfactorConfig).fetchCertAPIPath, kfCertId, includeChain)
+}
+
+// fetchCACertFromKeyfactor downloads a CA certificate from Keyfactor.
+func fetchCACertFromKeyfactor(ctx context.Context, req *logical.Request, b *keyfactorBackend, caId string, includeChain bool) (string, error) {
+	return downloadFromKeyfactor(ctx, req, b, (*keyfactorConfig).caCertAPIPath, caId, includeChain)
+}
+
+// downloadFromKeyfactor downloads a certificate in PEM format from the API
+// path returned by apiPath for the configuration.
+func downloadFromKeyfactor(ctx context.Context, req *logical.Request, b *keyfactorBackend, apiPath func(*keyfactorConfig) string, kfCertId string, includeChain bool) (string, error) {
 	config, err := b.fetchConfig(ctx, req.Storage)
 	if err != nil {
 		return "", err
@@ -261,6 +359,7 @@ func fetchCertFromKeyfactor(ctx context.Context, req *logical.Request, b *keyfac
 	client, err := b.getClient(ctx, req.Storage)
 	if err != nil {
 		b.Logger().Error("unable to create the http client")
+		return "", err
 	}
 	// This is only needed when running as a vault extension
 	b.Logger().Debug("Closing idle connections")
@@ -272,7 +371,7 @@ func fetchCertFromKeyfactor(ctx context.Context, req *logical.Request, b *keyfac
 	}
 
 	// Build request
-	url := config.KeyfactorUrl + "Certificates/Download"
+	url := config.apiURL(apiPath(config))
 	b.Logger().Debug("url: " + url)
 	bodyContent := fmt.Sprintf(`{"CertID": %s, "IncludeChain": %s }`, kfCertId, include)
 	payload := strings.NewReader(bodyContent)
@@ -287,7 +386,7 @@ func fetchCertFromKeyfactor(ctx context.Context, req *logical.Request, b *keyfac
 
 	// Send request and check status
 	b.Logger().Debug("About to connect to " + config.KeyfactorUrl + "for cert retrieval")
-	res, err := client.httpClient.Do(httpReq)
+	res, err := b.sendRequest(ctx, req.Storage, client, httpReq)
 	if err != nil {
 		b.Logger().Info("failed getting cert: {{err}}", err)
 		return "", err
@@ -301,7 +400,7 @@ func fetchCertFromKeyfactor(ctx context.Context, req *logical.Request, b *keyfac
 	// Read response and return certificate and key
 	defer res.Body.Close()
 
-	body, err := io.ReadAll(res.Body)
+	body, err := readKeyfactorResponse(ctx, config, res.Body)
 	if err != nil {
 		b.Logger().Info("Error reading response: {{err}}", err)
 		return "", err
@@ -400,6 +499,531 @@ func parseOtherSANs(others []string) (map[string][]string, error) {
 	return result, nil
 }
 
+// parseCertificatePEM parses the first certificate in PEM encoded data.
+func parseCertificatePEM(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errors.New("no PEM data found in certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// caChainCacheKey is the storage key of the cached CA chain.
+const caChainCacheKey = "ca/chain_cache"
+
+const defaultCAChainCacheTTL = time.Hour
+
+// caChainCache is the CA chain cached in storage.
+type caChainCache struct {
+	Chain     []string  `json:"chain"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// getCAChain returns the intermediate and root chain of the configured CA,
+// issuing CA first.  The chain is cached in storage for the configured TTL.
+func (b *keyfactorBackend) getCAChain(ctx context.Context, req *logical.Request) ([]*x509.Certificate, error) {
+	config, err := b.fetchConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("unable to load configuration")
+	}
+	ttl := config.CAChainCacheTTL
+	if ttl <= 0 {
+		ttl = defaultCAChainCacheTTL
+	}
+
+	cacheEntry, err := req.Storage.Get(ctx, caChainCacheKey)
+	if err != nil {
+		return nil, err
+	}
+	if cacheEntry != nil {
+		var cache caChainCache
+		if err := cacheEntry.DecodeJSON(&cache); err == nil && time.Since(cache.FetchedAt) < ttl {
+			return parseCertsPEM(cache.Chain), nil
+		}
+	}
+
+	caId, err := getCAId(ctx, req, b)
+	if err != nil {
+		return nil, fmt.Errorf("error getting CA ID from Keyfactor: %w", err)
+	}
+	if caId == "" {
+		return nil, nil
+	}
+	content, err := fetchCACertFromKeyfactor(ctx, req, b, caId, true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting CA chain from Keyfactor: %w", err)
+	}
+	chainBytes, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode CA chain from Keyfactor: %w", err)
+	}
+
+	var chainPEM []string
+	for rest := chainBytes; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chainPEM = append(chainPEM, string(pem.EncodeToMemory(block)))
+		}
+	}
+
+	cacheEntry, err = logical.StorageEntryJSON(caChainCacheKey, &caChainCache{
+		Chain:     chainPEM,
+		FetchedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, cacheEntry); err != nil {
+		b.Logger().Warn("unable to cache the CA chain", "error", err)
+	}
+
+	return parseCertsPEM(chainPEM), nil
+}
+
+// issuingCA returns the PEM encoded issuing CA for an enrolled certificate.
+// The cached CA chain is used when it issued the certificate; otherwise the
+// chain returned with the enrollment is used.
+func (b *keyfactorBackend) issuingCA(ctx context.Context, req *logical.Request, certs []string) string {
+	fallback := ""
+	if len(certs) > 1 {
+		fallback = certs[1]
+	}
+
+	leaf, err := parseCertificatePEM([]byte(certs[0]))
+	if err != nil {
+		return fallback
+	}
+	chain, err := b.getCAChain(ctx, req)
+	if err != nil {
+		b.Logger().Debug("unable to load the cached CA chain, using the enrollment response", "error", err)
+		return fallback
+	}
+	if len(chain) == 0 || !isIssuerOf(chain[0], leaf) {
+		return fallback
+	}
+	return encodeCertsPEM(chain[:1])
+}
+
+// parseCertsPEM parses PEM encoded certificates, skipping any that are invalid.
+func parseCertsPEM(certsPEM []string) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for _, certPEM := range certsPEM {
+		if cert, err := parseCertificatePEM([]byte(certPEM)); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+// encodeCertsPEM concatenates the PEM encoding of the certificates.
+func encodeCertsPEM(certs []*x509.Certificate) string {
+	var bundle strings.Builder
+	for _, cert := range certs {
+		bundle.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	}
+	return bundle.String()
+}
+
+// maxCertChainDepth bounds the number of issuers followed when building a chain.
+const maxCertChainDepth = 10
+
+// buildCertChain returns the certificate followed by its issuers, leaf first
+// and root last.  Issuers are matched by Authority Key Identifier against the
+// locally stored CA chain and the intermediate CAs signed with
+// sign-intermediate and, failing that, looked up in Keyfactor.
+func (b *keyfactorBackend) buildCertChain(ctx context.Context, req *logical.Request, leaf *x509.Certificate) ([]*x509.Certificate, error) {
+	var pool []*x509.Certificate
+	caChainEntry, err := req.Storage.Get(ctx, "ca_chain/")
+	if err != nil {
+		return nil, err
+	}
+	if caChainEntry != nil {
+		var stored []string
+		if err := caChainEntry.DecodeJSON(&stored); err == nil {
+			for _, certPEM := range stored {
+				if cert, err := parseCertificatePEM([]byte(certPEM)); err == nil {
+					pool = append(pool, cert)
+				}
+			}
+		}
+	}
+
+	chain := []*x509.Certificate{leaf}
+	current := leaf
+	for depth := 0; depth < maxCertChainDepth; depth++ {
+		if isSelfSigned(current) {
+			return chain, nil
+		}
+
+		var issuer *x509.Certificate
+		for _, candidate := range pool {
+			if isIssuerOf(candidate, current) {
+				issuer = candidate
+				break
+			}
+		}
+		if issuer == nil && len(current.AuthorityKeyId) > 0 {
+			issuer, err = getIntermediateCA(ctx, req.Storage, current.AuthorityKeyId)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if issuer == nil {
+			issuer, err = b.fetchIssuerFromKeyfactor(ctx, req, current)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if issuer == nil {
+			b.Logger().Debug("unable to locate issuer, returning partial chain", "issuer", current.Issuer.String())
+			return chain, nil
+		}
+
+		chain = append(chain, issuer)
+		current = issuer
+	}
+	return nil, fmt.Errorf("certificate chain exceeds maximum depth of %d", maxCertChainDepth)
+}
+
+// isSelfSigned reports whether the certificate is a self-signed root.
+func isSelfSigned(cert *x509.Certificate) bool {
+	if len(cert.AuthorityKeyId) > 0 && len(cert.SubjectKeyId) > 0 {
+		return bytes.Equal(cert.AuthorityKeyId, cert.SubjectKeyId)
+	}
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject)
+}
+
+// isIssuerOf reports whether candidate issued cert, matching the Authority Key
+// Identifier when present and falling back to the issuer name.
+func isIssuerOf(candidate *x509.Certificate, cert *x509.Certificate) bool {
+	if len(cert.AuthorityKeyId) > 0 && len(candidate.SubjectKeyId) > 0 {
+		return bytes.Equal(cert.AuthorityKeyId, candidate.SubjectKeyId)
+	}
+	return bytes.Equal(cert.RawIssuer, candidate.RawSubject)
+}
+
+// fetchIssuerFromKeyfactor searches Keyfactor for certificates issued to the
+// common name of the certificate's issuer and returns the one whose Subject
+// Key Identifier matches the certificate's Authority Key Identifier.
+func (b *keyfactorBackend) fetchIssuerFromKeyfactor(ctx context.Context, req *logical.Request, cert *x509.Certificate) (*x509.Certificate, error) {
+	config, err := b.fetchConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("unable to load configuration")
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	query := url.QueryEscape(fmt.Sprintf(`IssuedCN -eq "%s"`, cert.Issuer.CommonName))
+	url := config.apiURL("/Certificates?pq.queryString=" + query)
+	b.Logger().Debug("url: " + url)
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Add("x-keyfactor-requested-with", "APIClient")
+	httpReq.Header.Add("x-keyfactor-api-version", "1")
+
+	_, body, err := b.sendKeyfactorRequest(ctx, req.Storage, client, httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	var r KeyfactorCertResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("unable to parse certificate search response: %w", err)
+	}
+
+	for _, result := range r {
+		content, err := fetchCertFromKeyfactor(ctx, req, b, fmt.Sprintf("%d", result.ID), false)
+		if err != nil {
+			return nil, err
+		}
+		certBytes, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			continue
+		}
+		candidate, err := parseCertificatePEM(certBytes)
+		if err != nil {
+			continue
+		}
+		if isIssuerOf(candidate, cert) {
+			return candidate, nil
+		}
+	}
+	return nil, nil
+}
+
+// parseOCSPServers returns the OCSP responder URLs from the Authority
+// Information Access extension of a PEM encoded certificate, if any.
+func parseOCSPServers(certPEM string) []string {
+	cert, err := parseCertificatePEM([]byte(certPEM))
+	if err != nil || cert.OCSPServer == nil {
+		return []string{}
+	}
+	return cert.OCSPServer
+}
+
+// logOCSPServerURL logs the OCSP responder URL configured for the role or
+// backend.  The URL is advisory only; the AIA extension in issued certificates
+// is controlled by the Keyfactor template.
+func (b *keyfactorBackend) logOCSPServerURL(ctx context.Context, s logical.Storage, role *roleEntry) {
+	ocspURL := role.OCSPServerURL
+	if ocspURL == "" {
+		config, err := b.fetchConfig(ctx, s)
+		if err != nil || config == nil {
+			return
+		}
+		ocspURL = config.OCSPServerURL
+	}
+	if ocspURL == "" {
+		return
+	}
+	b.Logger().Info("OCSP responder URL for issued certificate (advisory only; Keyfactor templates control the AIA extension content)", "ocsp_server_url", ocspURL)
+}
+
+// isWildcardName reports whether the name is a wildcard DNS name, e.g. "*.example.com".
+func isWildcardName(name string) bool {
+	return strings.HasPrefix(name, "*")
+}
+
+// validateWildcardNames checks any wildcard common name or DNS SANs against the
+// role.  Wildcards must be permitted by the role, may only replace the single
+// leftmost label, and the remaining domain must be one of the allowed domains.
+// When a wildcard SAN is requested the common name must match its pattern.
+func validateWildcardNames(role *roleEntry, cn string, dnsSans []string) error {
+	names := append([]string{cn}, dnsSans...)
+	var wildcardBases []string
+	for _, name := range names {
+		if !isWildcardName(name) {
+			continue
+		}
+		if !role.AllowWildcardCertificates {
+			return fmt.Errorf("wildcard name %s not allowed for role", name)
+		}
+		base := strings.TrimPrefix(name, "*.")
+		if base == name || base == "" || strings.Contains(base, "*") {
+			return fmt.Errorf("wildcard name %s must replace exactly one leftmost label, e.g. *.example.com", name)
+		}
+		allowed := false
+		for _, v := range role.AllowedDomains {
+			if v == "*" || v == base {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("wildcard name %s is not for a domain allowed by the role", name)
+		}
+		if name != cn {
+			wildcardBases = append(wildcardBases, base)
+		}
+	}
+
+	for _, base := range wildcardBases {
+		if cn == "*."+base {
+			continue
+		}
+		label, domain, found := strings.Cut(cn, ".")
+		if !found || label == "" || domain != base {
+			return fmt.Errorf("common name %s does not match wildcard name *.%s", cn, base)
+		}
+	}
+	return nil
+}
+
+// validateIPSANs checks that every IP SAN is an IP address, and rejects
+// loopback, multicast and link-local addresses unless the role allows them.
+func validateIPSANs(role *roleEntry, ipSans []string) error {
+	for _, ipSan := range ipSans {
+		ip := net.ParseIP(ipSan)
+		switch {
+		case ip == nil:
+			return fmt.Errorf("'%s' is not a valid IP address for ip_sans field", ipSan)
+		case ip.IsLoopback() && !role.AllowLoopbackIPSANs:
+			return fmt.Errorf("loopback address '%s' not allowed for role in ip_sans field", ipSan)
+		case ip.IsMulticast() && !role.AllowMulticastIPSANs:
+			return fmt.Errorf("multicast address '%s' not allowed for role in ip_sans field", ipSan)
+		case ip.IsLinkLocalUnicast() && !role.AllowLinkLocalIPSANs:
+			return fmt.Errorf("link-local address '%s' not allowed for role in ip_sans field", ipSan)
+		}
+	}
+	return nil
+}
+
+// checkSANCounts checks the number of SANs of each type a certificate
+// requests against the per-type limits of the role, and their total against
+// its max_san_count.
+func checkSANCounts(role *roleEntry, dnsSANs, ipSANs, uriSANs, emailSANs int) error {
+	if total := dnsSANs + ipSANs + uriSANs + emailSANs; role.MaxSANCount > 0 && total > role.MaxSANCount {
+		return fmt.Errorf("%d subject alternative names were requested but the role allows at most %d", total, role.MaxSANCount)
+	}
+	limits := []struct {
+		name      string
+		requested int
+		max       int
+	}{
+		{"DNS", dnsSANs, role.MaxDNSSANs},
+		{"IP", ipSANs, role.MaxIPSANs},
+		{"URI", uriSANs, role.MaxURISANs},
+		{"email", emailSANs, role.MaxEmailSANs},
+	}
+	for _, limit := range limits {
+		if limit.max > 0 && limit.requested > limit.max {
+			return fmt.Errorf("%d %s subject alternative names were requested but the role allows at most %d", limit.requested, limit.name, limit.max)
+		}
+	}
+	return nil
+}
+
+// ecCurveBits maps the curve names accepted by min_ec_key_curve to their size.
+var ecCurveBits = map[string]int{
+	"P-256": 256,
+	"P-384": 384,
+	"P-521": 521,
+}
+
+// rsaKeyBits returns the size of the RSA keys generated for the role: its
+// key_bits for RSA roles, and 2048 otherwise.
+func (r *roleEntry) rsaKeyBits() int {
+	if r.KeyType == "rsa" && r.KeyBits >= 2048 {
+		return r.KeyBits
+	}
+	return 2048
+}
+
+// checkMinKeyStrength returns an error if the public key is weaker than the
+// minimum RSA key size or EC curve of the role.
+func checkMinKeyStrength(role *roleEntry, publicKey crypto.PublicKey) error {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if bits := key.N.BitLen(); bits < role.MinRSAKeyBits {
+			return fmt.Errorf("RSA key of %d bits is below the minimum of %d bits required by the role", bits, role.MinRSAKeyBits)
+		}
+	case *ecdsa.PublicKey:
+		if minBits := ecCurveBits[role.MinECKeyCurve]; key.Curve.Params().BitSize < minBits {
+			return fmt.Errorf("EC key on curve %s is weaker than the minimum curve %s required by the role", key.Curve.Params().Name, role.MinECKeyCurve)
+		}
+	}
+	return nil
+}
+
+// signatureAlgorithms maps the names accepted by allowed_signature_algorithms
+// to the signature algorithms they stand for.
+var signatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"SHA256WithRSA":    x509.SHA256WithRSA,
+	"SHA384WithRSA":    x509.SHA384WithRSA,
+	"SHA512WithRSA":    x509.SHA512WithRSA,
+	"SHA256WithRSAPSS": x509.SHA256WithRSAPSS,
+	"SHA384WithRSAPSS": x509.SHA384WithRSAPSS,
+	"SHA512WithRSAPSS": x509.SHA512WithRSAPSS,
+	"ECDSAWithSHA256":  x509.ECDSAWithSHA256,
+	"ECDSAWithSHA384":  x509.ECDSAWithSHA384,
+	"ECDSAWithSHA512":  x509.ECDSAWithSHA512,
+	"PureEd25519":      x509.PureEd25519,
+}
+
+// signatureAlgorithmNames returns the names of the supported signature
+// algorithms in sorted order.
+func signatureAlgorithmNames() []string {
+	names := make([]string, 0, len(signatureAlgorithms))
+	for name := range signatureAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkSignatureAlgorithm returns an error if the role restricts signature
+// algorithms and the algorithm is not one of them.
+func checkSignatureAlgorithm(role *roleEntry, algorithm x509.SignatureAlgorithm) error {
+	if len(role.AllowedSignatureAlgorithms) == 0 {
+		return nil
+	}
+	for _, name := range role.AllowedSignatureAlgorithms {
+		if signatureAlgorithms[name] == algorithm {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature algorithm %s is not allowed by the role; allowed algorithms are %s", algorithm, strings.Join(role.AllowedSignatureAlgorithms, ", "))
+}
+
+// rsaSignatureAlgorithm returns the algorithm to sign CSRs for generated RSA
+// keys with: the first RSA algorithm allowed by the role, or SHA256WithRSA
+// if the role does not restrict signature algorithms.
+func (r *roleEntry) rsaSignatureAlgorithm() (x509.SignatureAlgorithm, error) {
+	if len(r.AllowedSignatureAlgorithms) == 0 {
+		return x509.SHA256WithRSA, nil
+	}
+	for _, name := range r.AllowedSignatureAlgorithms {
+		switch algorithm := signatureAlgorithms[name]; algorithm {
+		case x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+			x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+			return algorithm, nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("the role allows no RSA signature algorithm for generated keys; allowed algorithms are %s", strings.Join(r.AllowedSignatureAlgorithms, ", "))
+}
+
+// allowsCA reports whether the role may enroll with the CA, given by
+// logical name or as host\\logical name.  A role without allowed_cas
+// allows every CA.
+func (r *roleEntry) allowsCA(caName string) bool {
+	if len(r.AllowedCAs) == 0 {
+		return true
+	}
+	for _, allowed := range r.AllowedCAs {
+		if strings.EqualFold(caLogicalName(allowed), caLogicalName(caName)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCustomFields returns an error naming the first custom enrollment
+// field the role does not allow.
+func checkCustomFields(role *roleEntry, customFields map[string]string) error {
+	if slices.Contains(role.AllowedCustomFields, "*") {
+		return nil
+	}
+	for name := range customFields {
+		if !slices.Contains(role.AllowedCustomFields, name) {
+			return errutil.UserError{Err: fmt.Sprintf("custom field %q is not allowed by the role", name)}
+		}
+	}
+	return nil
+}
+
+// caLogicalName returns the logical name of a CA given as host\\logical name.
+func caLogicalName(caName string) string {
+	return caName[strings.LastIndex(caName, `\`)+1:]
+}
+
+// checkProhibitedCommonName returns an error if the common name matches any of
+// the prohibited names, ignoring case.
+func checkProhibitedCommonName(cn string, prohibitedLists ...[]string) error {
+	for _, prohibited := range prohibitedLists {
+		for _, name := range prohibited {
+			if strings.EqualFold(cn, name) {
+				return fmt.Errorf("common name '%s' is explicitly prohibited by policy", cn)
+			}
+		}
+	}
+	return nil
+}
+
 func normalizeSerial(serial string) string {
 	return strings.Replace(strings.ToLower(serial), ":", "-", -1)
 }
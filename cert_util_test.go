@@ -0,0 +1,44 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"slices"
+	"testing"
+)
+
+func TestGenerateCSRWithoutCommonName(t *testing.T) {
+	b := &keyfactorBackend{}
+	csrPEM, _, err := b.generateCSR(2048, x509.SHA256WithRSA, dnOrderRFC4514, "", "", "", keyUsagePurposeServer, []string{"10.0.0.1"}, []string{"svc.example.com"}, nil)
+	if err != nil {
+		t.Fatalf("generateCSR: %v", err)
+	}
+
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil {
+		t.Fatal("generateCSR did not return a PEM encoded CSR")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatalf("unable to parse the CSR: %v", err)
+	}
+
+	if len(csr.Subject.Names) != 0 || csr.Subject.CommonName != "" {
+		t.Errorf("expected an empty subject, got %q", csr.Subject.String())
+	}
+	if !slices.Equal(csr.DNSNames, []string{"svc.example.com"}) {
+		t.Errorf("expected the DNS SAN svc.example.com, got %v", csr.DNSNames)
+	}
+	if len(csr.IPAddresses) != 1 || csr.IPAddresses[0].String() != "10.0.0.1" {
+		t.Errorf("expected the IP SAN 10.0.0.1, got %v", csr.IPAddresses)
+	}
+}
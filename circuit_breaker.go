@@ -0,0 +1,167 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	defaultCircuitBreakerThreshold = 5
+	defaultCircuitBreakerTimeout   = 30 * time.Second
+)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker stops calls to Keyfactor after a number of consecutive
+// failures, and lets a single probe request through once the timeout has
+// elapsed to determine whether Keyfactor has recovered.
+type circuitBreaker struct {
+	lock     sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow reports whether a request may be sent, moving an open circuit to
+// half-open once the timeout has elapsed.
+func (c *circuitBreaker) allow(timeout time.Duration) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	switch c.state {
+	case circuitOpen:
+		if time.Since(c.openedAt) < timeout {
+			return false
+		}
+		c.state = circuitHalfOpen
+		c.probing = true
+		return true
+	case circuitHalfOpen:
+		// only the single probe request is allowed through
+		if c.probing {
+			return false
+		}
+		c.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// recordSuccess closes the circuit and clears the failure count.
+func (c *circuitBreaker) recordSuccess() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.state = circuitClosed
+	c.failures = 0
+	c.probing = false
+}
+
+// recordFailure counts a failure, opening the circuit once the threshold is
+// reached or when the half-open probe fails.  It returns the resulting state.
+func (c *circuitBreaker) recordFailure(threshold int) circuitState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.failures++
+	c.probing = false
+	if c.state == circuitHalfOpen || c.failures >= threshold {
+		c.state = circuitOpen
+		c.openedAt = time.Now()
+	}
+	return c.state
+}
+
+// reset closes the circuit, e.g. after the configuration has changed.
+func (c *circuitBreaker) reset() {
+	c.recordSuccess()
+}
+
+// status returns the state of the circuit, the consecutive failure count and
+// when the circuit last opened.
+func (c *circuitBreaker) status() (circuitState, int, time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.state, c.failures, c.openedAt
+}
+
+// circuitBreakerSettings returns the threshold and timeout from the
+// configuration, falling back to the defaults when they are unset.
+func (c *keyfactorConfig) circuitBreakerSettings() (int, time.Duration) {
+	threshold := defaultCircuitBreakerThreshold
+	timeout := defaultCircuitBreakerTimeout
+	if c != nil && c.CircuitBreakerThreshold > 0 {
+		threshold = c.CircuitBreakerThreshold
+	}
+	if c != nil && c.CircuitBreakerTimeout > 0 {
+		timeout = c.CircuitBreakerTimeout
+	}
+	return threshold, timeout
+}
+
+// sendRequest sends a request to Keyfactor through the circuit breaker.
+// Transport errors and server errors count as failures; while the circuit is
+// open logical.ErrReadOnly is returned without contacting Keyfactor.
+func (b *keyfactorBackend) sendRequest(ctx context.Context, s logical.Storage, client *keyfactorClient, httpReq *http.Request) (*http.Response, error) {
+	config, err := b.fetchConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	threshold, timeout := config.circuitBreakerSettings()
+	breakerEnabled, err := b.featureEnabled(ctx, s, featureCircuitBreaker)
+	if err != nil {
+		return nil, err
+	}
+	if !breakerEnabled {
+		return client.httpClient.Do(httpReq)
+	}
+
+	if !b.breaker.allow(timeout) {
+		b.Logger().Warn("circuit breaker is open, not sending request to Keyfactor", "url", httpReq.URL.String())
+		return nil, logical.ErrReadOnly
+	}
+
+	res, err := client.httpClient.Do(httpReq)
+	if err != nil || res.StatusCode >= http.StatusInternalServerError {
+		if state := b.breaker.recordFailure(threshold); state == circuitOpen {
+			b.Logger().Warn("circuit breaker opened after failed requests to Keyfactor", "threshold", threshold, "timeout", timeout.String())
+		}
+		return res, err
+	}
+
+	b.breaker.recordSuccess()
+	return res, nil
+}
@@ -0,0 +1,203 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// crlStorageKey holds the last CRL fetched every crl_push_interval.
+	crlStorageKey = "crl_push/crl"
+
+	// crlRequestTimeout bounds the download and the push of the CRL.
+	crlRequestTimeout = 30 * time.Second
+
+	// maxCRLSize is the largest CRL that is downloaded.
+	maxCRLSize = 64 << 20
+)
+
+// storedCRL is the CRL of the configured CA as last fetched.
+type storedCRL struct {
+	DER       []byte    `json:"der"`
+	URL       string    `json:"url"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// fetchAndPushCRL fetches the CRL of the configured CA once every
+// crl_push_interval, stores it and POSTs it to crl_push_url if one is
+// configured.
+//
+// The Command API does not serve CRLs, so the CRL is downloaded from the
+// distribution point that the CA includes in the certificates it issues and
+// its signature is checked against the CA certificate from Keyfactor.
+func (b *keyfactorBackend) fetchAndPushCRL(ctx context.Context, s logical.Storage) error {
+	config, err := b.fetchConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+	if config == nil || config.CRLPushInterval <= 0 {
+		return nil
+	}
+
+	b.crlPushLock.Lock()
+	if time.Since(b.lastCRLFetch) < config.CRLPushInterval {
+		b.crlPushLock.Unlock()
+		return nil
+	}
+	b.lastCRLFetch = time.Now()
+	b.crlPushLock.Unlock()
+
+	ca, crlURL, err := b.crlDistributionPoint(ctx, s)
+	if err != nil {
+		return fmt.Errorf("unable to find the CRL distribution point of the CA: %w", err)
+	}
+	if crlURL == "" {
+		b.Logger().Debug("no stored certificate issued by the CA has an http CRL distribution point, not fetching the CRL")
+		return nil
+	}
+
+	der, err := downloadCRL(ctx, crlURL)
+	if err != nil {
+		return fmt.Errorf("unable to download the CRL from %s: %w", crlURL, err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return fmt.Errorf("unable to parse the CRL from %s: %w", crlURL, err)
+	}
+	if err := crl.CheckSignatureFrom(ca); err != nil {
+		return fmt.Errorf("the CRL from %s is not signed by the CA: %w", crlURL, err)
+	}
+
+	entry, err := logical.StorageEntryJSON(crlStorageKey, &storedCRL{
+		DER:       der,
+		URL:       crlURL,
+		FetchedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return err
+	}
+	b.Logger().Debug("fetched CRL", "url", crlURL, "entries", len(crl.RevokedCertificateEntries))
+
+	if config.CRLPushURL == "" {
+		return nil
+	}
+	if err := pushCRL(ctx, config, der); err != nil {
+		return fmt.Errorf("unable to push the CRL to %s: %w", config.CRLPushURL, err)
+	}
+	b.Logger().Info("pushed CRL", "url", config.CRLPushURL)
+	return nil
+}
+
+// crlDistributionPoint returns the certificate of the configured CA and the
+// first http CRL distribution point of a stored certificate it issued.  The
+// URL is empty if no such certificate is stored.
+func (b *keyfactorBackend) crlDistributionPoint(ctx context.Context, s logical.Storage) (*x509.Certificate, string, error) {
+	chain, err := b.getCAChain(ctx, &logical.Request{Storage: s})
+	if err != nil {
+		return nil, "", err
+	}
+	if len(chain) == 0 {
+		return nil, "", errors.New("no CA is configured")
+	}
+
+	serials, err := s.List(ctx, "certs/")
+	if err != nil {
+		return nil, "", err
+	}
+	for _, serial := range serials {
+		certEntry, err := s.Get(ctx, "certs/"+serial)
+		if err != nil {
+			return nil, "", err
+		}
+		if certEntry == nil {
+			continue
+		}
+		cert, err := parseCertificatePEM(certEntry.Value)
+		if err != nil {
+			continue
+		}
+		for _, ca := range chain {
+			if !isIssuerOf(ca, cert) {
+				continue
+			}
+			for _, point := range cert.CRLDistributionPoints {
+				if strings.HasPrefix(point, "http://") || strings.HasPrefix(point, "https://") {
+					return ca, point, nil
+				}
+			}
+		}
+	}
+	return nil, "", nil
+}
+
+// downloadCRL downloads a DER or PEM encoded CRL and returns it DER encoded.
+func downloadCRL(ctx context.Context, crlURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, crlRequestTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, crlURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned status %d", res.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxCRLSize))
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(body); block != nil && block.Type == "X509 CRL" {
+		return block.Bytes, nil
+	}
+	return body, nil
+}
+
+// pushCRL POSTs the DER encoded CRL to crl_push_url.
+func pushCRL(ctx context.Context, config *keyfactorConfig, der []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, crlRequestTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, config.CRLPushURL, bytes.NewReader(der))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/pkix-crl")
+	if config.CRLPushUsername != "" {
+		httpReq.SetBasicAuth(config.CRLPushUsername, config.CRLPushPassword)
+	}
+
+	res, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("server returned status %d", res.StatusCode)
+	}
+	return nil
+}
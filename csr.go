@@ -0,0 +1,100 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+var (
+	oidPKCS7Data          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7SignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidPKCS7EnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+)
+
+// pkcs7ContentInfo is the ContentInfo structure of RFC 2315.
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// pkcs7SignedData holds the leading fields of the SignedData structure of
+// RFC 2315; the certificates and signer infos that follow are not needed.
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      pkcs7ContentInfo
+}
+
+// parseCSRPEM parses a PEM-encoded PKCS#10 certificate request, or one
+// carried as the content of PEM-encoded PKCS#7 signed data, and returns it
+// with its PKCS#10 PEM encoding.
+func parseCSRPEM(csr string) (*x509.CertificateRequest, string, error) {
+	block, _ := pem.Decode([]byte(csr))
+	if block == nil {
+		return nil, "", errors.New("csr must be PEM-encoded")
+	}
+
+	der := block.Bytes
+	switch block.Type {
+	case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+	case "PKCS7":
+		var err error
+		der, err = csrFromPKCS7(block.Bytes)
+		if err != nil {
+			return nil, "", err
+		}
+	default:
+		return nil, "", fmt.Errorf("unsupported PEM block type %q in csr; expected CERTIFICATE REQUEST or PKCS7", block.Type)
+	}
+
+	parsed, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to parse csr: %w", err)
+	}
+	return parsed, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), nil
+}
+
+// csrFromPKCS7 returns the DER encoded certificate request carried as the
+// data of a PKCS#7 signed data structure.
+func csrFromPKCS7(der []byte) ([]byte, error) {
+	var outer pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &outer); err != nil {
+		return nil, fmt.Errorf("unable to parse PKCS#7 csr: %w", err)
+	}
+	switch {
+	case outer.ContentType.Equal(oidPKCS7SignedData):
+	case outer.ContentType.Equal(oidPKCS7EnvelopedData):
+		return nil, errors.New("PKCS#7 enveloped data is encrypted for the CA and cannot be read by Vault; submit the PKCS#10 csr it contains instead")
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#7 content type %s in csr", outer.ContentType)
+	}
+
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(outer.Content.Bytes, &signedData); err != nil {
+		return nil, fmt.Errorf("unable to parse PKCS#7 signed data in csr: %w", err)
+	}
+	if !signedData.ContentInfo.ContentType.Equal(oidPKCS7Data) {
+		return nil, fmt.Errorf("unsupported PKCS#7 signed content type %s in csr", signedData.ContentInfo.ContentType)
+	}
+
+	var content []byte
+	if _, err := asn1.Unmarshal(signedData.ContentInfo.Content.Bytes, &content); err != nil {
+		return nil, fmt.Errorf("unable to read the certificate request from PKCS#7 signed data: %w", err)
+	}
+	if len(content) == 0 {
+		return nil, errors.New("PKCS#7 signed data in csr carries no certificate request")
+	}
+	return content, nil
+}
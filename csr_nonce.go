@@ -0,0 +1,97 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// usedNonceStoragePrefix records the hashes of the CSR nonces that have been
+// used, until they expire.
+const usedNonceStoragePrefix = "used_nonces/"
+
+// defaultNonceTTL is how long a nonce is remembered for roles without a max_ttl.
+const defaultNonceTTL = 24 * time.Hour
+
+// usedNonceEntry records when a used nonce may be forgotten.
+type usedNonceEntry struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// useCSRNonce records the nonce as used for ttl.  It reports false if the
+// nonce was already used and has not expired.
+func (b *keyfactorBackend) useCSRNonce(ctx context.Context, s logical.Storage, nonce string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		ttl = defaultNonceTTL
+	}
+	sum := sha256.Sum256([]byte(nonce))
+	key := usedNonceStoragePrefix + hex.EncodeToString(sum[:])
+
+	b.nonceLock.Lock()
+	defer b.nonceLock.Unlock()
+
+	now := time.Now()
+	entry, err := s.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if entry != nil {
+		var used usedNonceEntry
+		if err := entry.DecodeJSON(&used); err != nil {
+			return false, err
+		}
+		if now.Before(used.ExpiresAt) {
+			return false, nil
+		}
+	}
+
+	entry, err = logical.StorageEntryJSON(key, &usedNonceEntry{ExpiresAt: now.Add(ttl)})
+	if err != nil {
+		return false, err
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// tidyUsedNonces deletes the used nonces that have expired.
+func (b *keyfactorBackend) tidyUsedNonces(ctx context.Context, s logical.Storage) error {
+	b.nonceLock.Lock()
+	defer b.nonceLock.Unlock()
+
+	hashes, err := s.List(ctx, usedNonceStoragePrefix)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, hash := range hashes {
+		entry, err := s.Get(ctx, usedNonceStoragePrefix+hash)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+		var used usedNonceEntry
+		if err := entry.DecodeJSON(&used); err == nil && now.Before(used.ExpiresAt) {
+			continue
+		}
+		if err := s.Delete(ctx, usedNonceStoragePrefix+hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
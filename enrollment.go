@@ -0,0 +1,164 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Enrollment formats accepted by the enrollment_format configuration field.
+const (
+	enrollmentFormatJSON      = "json"
+	enrollmentFormatMultipart = "multipart_form"
+)
+
+// validateEnrollmentFormat checks that the format is one the backend can send.
+func validateEnrollmentFormat(format string) error {
+	switch format {
+	case enrollmentFormatJSON, enrollmentFormatMultipart:
+		return nil
+	default:
+		return fmt.Errorf("invalid enrollment_format %q; must be %q or %q", format, enrollmentFormatJSON, enrollmentFormatMultipart)
+	}
+}
+
+// enrollmentComment returns the comment recorded in the Keyfactor audit
+// history of an enrollment: the requested comment, else the configured
+// default_comment, else a comment naming the entity and mount.
+func enrollmentComment(req *logical.Request, config *keyfactorConfig, comment string) string {
+	if comment != "" {
+		return comment
+	}
+	if config.DefaultComment != "" {
+		return config.DefaultComment
+	}
+	return fmt.Sprintf("Issued via HashiCorp Vault by %s on %s", req.EntityID, req.MountPoint)
+}
+
+// defaultRequesterPrefix is the prefix of the enrollment Requester when
+// requester_prefix is not configured.
+const defaultRequesterPrefix = "vault"
+
+// enrollmentRequester returns the Requester sent with an enrollment so that
+// the request can be traced back to Vault, as
+// <requester_prefix>/<namespace>/<entity_id>.  The namespace is that of the
+// requesting entity, or "root" for requests without one.
+func (b *keyfactorBackend) enrollmentRequester(req *logical.Request, config *keyfactorConfig) string {
+	prefix := config.RequesterPrefix
+	if prefix == "" {
+		prefix = defaultRequesterPrefix
+	}
+	namespace := "root"
+	if req.EntityID != "" {
+		entity, err := b.System().EntityInfo(req.EntityID)
+		if err != nil {
+			b.Logger().Warn("unable to look up the namespace of the entity for the enrollment requester", "entity_id", req.EntityID, "error", err)
+		} else if entity != nil && entity.NamespaceID != "" {
+			namespace = entity.NamespaceID
+		}
+	}
+	return fmt.Sprintf("%s/%s/%s", prefix, namespace, req.EntityID)
+}
+
+// multipartEnrollmentBody builds an EJBCA-style multipart/form-data
+// enrollment body for the CSR and returns it with its content type.
+func multipartEnrollmentBody(config *keyfactorConfig, csr string) ([]byte, string, error) {
+	block, _ := pem.Decode([]byte(csr))
+	if block == nil {
+		return nil, "", errors.New("unable to decode the CSR")
+	}
+	parsed, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to parse the CSR: %w", err)
+	}
+
+	var altNames []string
+	for _, name := range parsed.DNSNames {
+		altNames = append(altNames, "dNSName="+name)
+	}
+	for _, ip := range parsed.IPAddresses {
+		altNames = append(altNames, "iPAddress="+ip.String())
+	}
+	for _, uri := range parsed.URIs {
+		altNames = append(altNames, "uniformResourceIdentifier="+uri.String())
+	}
+	for _, email := range parsed.EmailAddresses {
+		altNames = append(altNames, "rfc822Name="+email)
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"username", config.Username},
+		{"password", config.Password},
+		{"subjectDN", parsed.Subject.String()},
+		{"subjectAltName", strings.Join(altNames, ",")},
+		{"certificateRequest", csr},
+	}
+	for _, field := range fields {
+		if err := writer.WriteField(field.name, field.value); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), writer.FormDataContentType(), nil
+}
+
+// enrollmentResponse is the response of the Keyfactor CSR enrollment endpoint.
+type enrollmentResponse struct {
+	CertificateInformation *struct {
+		SerialNumber string   `json:"SerialNumber"`
+		KeyfactorID  float64  `json:"KeyfactorID"`
+		RequestID    float64  `json:"KeyfactorRequestId"`
+		Certificates []string `json:"Certificates"`
+	} `json:"CertificateInformation"`
+}
+
+// parseEnrollmentResponse returns the certificates, leaf first, the serial
+// number, the Keyfactor ID and the Keyfactor request ID from an enrollment
+// response, or an error
+// describing how the response differs from the expected format.
+func parseEnrollmentResponse(body []byte, format string) ([]string, string, float64, float64, error) {
+	var r enrollmentResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, "", 0, 0, fmt.Errorf("unexpected enrollment response for enrollment_format %q; expected JSON: %w", format, err)
+	}
+	info := r.CertificateInformation
+	if info == nil {
+		return nil, "", 0, 0, fmt.Errorf("unexpected enrollment response for enrollment_format %q; missing CertificateInformation", format)
+	}
+	if info.SerialNumber == "" || len(info.Certificates) == 0 {
+		return nil, "", 0, 0, fmt.Errorf("unexpected enrollment response for enrollment_format %q; missing serial number or certificates", format)
+	}
+
+	certs := make([]string, len(info.Certificates))
+	for i, cert := range info.Certificates {
+		start := strings.Index(cert, "-----BEGIN CERTIFICATE-----")
+		if start < 0 {
+			return nil, "", 0, 0, fmt.Errorf("unexpected enrollment response for enrollment_format %q; certificate %d is not PEM encoded", format, i)
+		}
+		certs[i] = cert[start:]
+	}
+	return certs, info.SerialNumber, info.KeyfactorID, info.RequestID, nil
+}
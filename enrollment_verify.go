@@ -0,0 +1,70 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// verifyEnrollment downloads the certificate enrolled with the given
+// Keyfactor ID and returns an error if it differs from the PEM certificate
+// returned by the enrollment.  This catches enrollment responses that were
+// truncated or re-encoded on the way, before the certificate is stored.
+func (b *keyfactorBackend) verifyEnrollment(ctx context.Context, s logical.Storage, client *keyfactorClient, config *keyfactorConfig, keyfactorId int, certPEM string) error {
+	downloaded, err := b.downloadCertificatePEM(ctx, s, client, config, keyfactorId)
+	if err != nil {
+		return fmt.Errorf("unable to download certificate %d from Keyfactor: %w", keyfactorId, err)
+	}
+	if normalizePEM(downloaded) != normalizePEM(certPEM) {
+		b.Logger().Error("the certificate downloaded from Keyfactor differs from the enrolled certificate", "keyfactor_id", keyfactorId)
+		return fmt.Errorf("the certificate downloaded from Keyfactor with ID %d differs from the certificate returned by the enrollment", keyfactorId)
+	}
+	return nil
+}
+
+// downloadCertificatePEM downloads a certificate without its chain from
+// Keyfactor in PEM format.
+func (b *keyfactorBackend) downloadCertificatePEM(ctx context.Context, s logical.Storage, client *keyfactorClient, config *keyfactorConfig, keyfactorId int) (string, error) {
+	bodyContent := fmt.Sprintf(`{"CertID": %d, "IncludeChain": false}`, keyfactorId)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", config.apiURL(config.fetchCertAPIPath()), strings.NewReader(bodyContent))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Add("x-keyfactor-requested-with", "APIClient")
+	httpReq.Header.Add("content-type", "application/json")
+	httpReq.Header.Add("x-certificateformat", "PEM")
+
+	_, body, err := b.sendKeyfactorRequest(ctx, s, client, httpReq)
+	if err != nil {
+		return "", err
+	}
+	var r KeyfactorCertDownloadResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return "", fmt.Errorf("unable to parse the download response: %w", err)
+	}
+	content, err := base64.StdEncoding.DecodeString(r.Content)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode the downloaded certificate: %w", err)
+	}
+	return string(content), nil
+}
+
+// normalizePEM returns a PEM string with its line endings and surrounding
+// whitespace normalized, so that certificates can be compared as text.
+func normalizePEM(s string) string {
+	return strings.TrimSpace(strings.ReplaceAll(s, "\r\n", "\n"))
+}
@@ -0,0 +1,66 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// validateEntityMetadataFields validates the `entity_metadata_fields` field.
+func validateEntityMetadataFields(fields map[string]string) error {
+	for attribute, field := range fields {
+		if attribute == "" || field == "" {
+			return fmt.Errorf("entity attribute %q and its Keyfactor metadata field %q must both be non-empty", attribute, field)
+		}
+	}
+	return nil
+}
+
+// withEntityMetadata adds the metadata attributes of the requesting entity
+// to the Keyfactor metadata JSON, renamed according to the configured
+// entity_metadata_fields.  Metadata passed explicitly with the request takes
+// precedence over entity attributes.
+func (b *keyfactorBackend) withEntityMetadata(req *logical.Request, config *keyfactorConfig, metaDataJson string) (string, error) {
+	if len(config.EntityMetadataFields) == 0 || req.EntityID == "" {
+		return metaDataJson, nil
+	}
+
+	entity, err := b.System().EntityInfo(req.EntityID)
+	if err != nil {
+		return "", fmt.Errorf("unable to look up entity %s: %w", req.EntityID, err)
+	}
+	if entity == nil || len(entity.Metadata) == 0 {
+		return metaDataJson, nil
+	}
+
+	metadata := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(metaDataJson), &metadata); err != nil {
+		return "", fmt.Errorf("unable to parse metadata: %w", err)
+	}
+	for attribute, field := range config.EntityMetadataFields {
+		value, ok := entity.Metadata[attribute]
+		if !ok {
+			continue
+		}
+		if _, set := metadata[field]; set {
+			continue
+		}
+		metadata[field] = value
+	}
+
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
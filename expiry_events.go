@@ -0,0 +1,170 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// certExpiringEventType is the event sent when a certificate is near expiry.
+	certExpiringEventType = "keyfactor/cert.expiring"
+
+	// expiryNotifiedStoragePrefix records the smallest threshold, in days,
+	// for which an expiry event was already sent for a certificate.
+	expiryNotifiedStoragePrefix = "expiryNotified/"
+
+	// expiryScanInterval is the minimum time between scans for expiring certificates.
+	expiryScanInterval = time.Hour
+)
+
+// expiryEventThresholds are the days remaining at which expiry events are sent.
+var expiryEventThresholds = []int{30, 7, 1}
+
+// periodicFunc is invoked by Vault on the active node to perform background
+// tasks.  The request is routed through HandleRequest, so its storage is
+// already scoped to the storage prefix.
+func (b *keyfactorBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	s := req.Storage
+
+	// the CRL, queued revocations and interrupted issuances are processed on
+	// their own schedules
+	crlErr := b.fetchAndPushCRL(ctx, s)
+	revokeErr := b.processRevokeRetryQueue(ctx, s)
+	walErr := b.processIssueWAL(ctx, s, issueWALMinAge)
+
+	b.expiryScanLock.Lock()
+	if time.Since(b.lastExpiryScan) < expiryScanInterval {
+		b.expiryScanLock.Unlock()
+		return errors.Join(crlErr, revokeErr, walErr)
+	}
+	b.lastExpiryScan = time.Now()
+	b.expiryScanLock.Unlock()
+
+	features, err := b.fetchFeatures(ctx, s)
+	if err != nil {
+		return err
+	}
+	var eventsErr, renewErr error
+	if features[featureExpiryEvents] {
+		eventsErr = b.sendExpiringCertEvents(ctx, s)
+	}
+	if features[featureAutoRenew] {
+		renewErr = b.autoRenewCerts(ctx, s)
+	}
+	countersErr := b.resetIssuedTodayCounters(ctx, s)
+	noncesErr := b.tidyUsedNonces(ctx, s)
+	acmeNoncesErr := b.tidyACMENonces(ctx, s)
+	return errors.Join(crlErr, revokeErr, walErr, eventsErr, renewErr, countersErr, noncesErr, acmeNoncesErr)
+}
+
+// sendExpiringCertEvents sends a certExpiringEventType event for every stored,
+// non-revoked certificate that has crossed one of the expiryEventThresholds
+// since the last event sent for it, and notifies the webhooks subscribed to
+// expiry events.
+func (b *keyfactorBackend) sendExpiringCertEvents(ctx context.Context, s logical.Storage) error {
+	serials, err := s.List(ctx, "certs/")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	eventsAvailable := true
+	for _, serial := range serials {
+		certEntry, err := s.Get(ctx, "certs/"+serial)
+		if err != nil {
+			return err
+		}
+		if certEntry == nil {
+			continue
+		}
+		cert, err := parseCertificatePEM(certEntry.Value)
+		if err != nil {
+			b.Logger().Debug("unable to parse stored certificate while checking expiry", "serial", serial, "error", err)
+			continue
+		}
+		if cert.NotAfter.Before(now) {
+			continue
+		}
+
+		daysRemaining := int(cert.NotAfter.Sub(now).Hours() / 24)
+		threshold := 0
+		for _, t := range expiryEventThresholds {
+			if daysRemaining <= t {
+				threshold = t
+			}
+		}
+		if threshold == 0 {
+			continue
+		}
+
+		revokedEntry, err := s.Get(ctx, "revoked/"+serial)
+		if err != nil {
+			return err
+		}
+		if revokedEntry != nil {
+			continue
+		}
+
+		notified, err := s.Get(ctx, expiryNotifiedStoragePrefix+serial)
+		if err != nil {
+			return err
+		}
+		if notified != nil {
+			var lastThreshold int
+			if err := notified.DecodeJSON(&lastThreshold); err == nil && lastThreshold <= threshold {
+				continue
+			}
+		}
+
+		role := ""
+		info, err := getCertInfo(ctx, s, serial)
+		if err != nil {
+			return err
+		}
+		if info != nil {
+			role = info.Role
+		}
+
+		if eventsAvailable {
+			err = logical.SendEvent(ctx, b, certExpiringEventType,
+				logical.EventMetadataDataPath, "certs/"+serial,
+				"serial", serial,
+				"common_name", cert.Subject.CommonName,
+				"expiry_rfc3339", cert.NotAfter.UTC().Format(time.RFC3339),
+				"days_remaining", strconv.Itoa(daysRemaining),
+				"role", role,
+			)
+			if errors.Is(err, framework.ErrNoEvents) {
+				b.Logger().Debug("events are not available, not sending certificate expiry events")
+				eventsAvailable = false
+			} else if err != nil {
+				b.Logger().Warn("unable to send certificate expiry event", "serial", serial, "error", err)
+				continue
+			}
+		}
+		b.notifyWebhooks(ctx, s, webhookEventExpiry, serial, cert.Subject.CommonName)
+
+		notifiedEntry, err := logical.StorageEntryJSON(expiryNotifiedStoragePrefix+serial, threshold)
+		if err != nil {
+			return err
+		}
+		if err := s.Put(ctx, notifiedEntry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -76,6 +76,26 @@ be larger than the role max TTL.`,
 		},
 	}
 
+	fields["timeout"] = &framework.FieldSchema{
+		Type: framework.TypeDurationSecond,
+		Description: `The maximum time to wait for Keyfactor to respond to
+this request. Cannot be larger than the timeout
+configured for the operation.`,
+	}
+
+	fields["custom_fields"] = &framework.FieldSchema{
+		Type: framework.TypeKVPairs,
+		Description: `A map of Keyfactor custom enrollment field names to values
+defined by the certificate template. The role must allow each
+field with allowed_custom_fields.`,
+	}
+
+	fields["comment"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `A comment recorded in the Keyfactor audit history of the
+certificate. Defaults to default_comment from the configuration.`,
+	}
+
 	fields["metadata"] = &framework.FieldSchema{
 		Type: framework.TypeString,
 		Description: `Metadata in JSON format to be passed along with the signing request and associated with the certificate in Command.  
@@ -179,7 +199,9 @@ CN and SANs. Defaults to true.`,
 		Type:    framework.TypeBool,
 		Default: true,
 		Description: `If set, IP Subject Alternative Names are allowed.
-Any valid IP is accepted.`,
+Any valid IP is accepted, except loopback, multicast
+and link-local addresses, which must be allowed by
+their own flags.`,
 		DisplayAttrs: &framework.DisplayAttributes{
 			Name:  "Allow IP Subject Alternative Names",
 			Value: true,
@@ -400,6 +422,17 @@ for "generate_lease".`,
 		},
 	}
 
+	fields["derive_cn_from_san"] = &framework.FieldSchema{
+		Type:    framework.TypeBool,
+		Default: false,
+		Description: `If set to true, a certificate requested without a
+'common_name' takes the first of its 'dns_sans' as its common name, so that
+the two always match without clients repeating the value.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Derive Common Name From SAN",
+		},
+	}
+
 	fields["policy_identifiers"] = &framework.FieldSchema{
 		Type:        framework.TypeCommaStringSlice,
 		Description: `A comma-separated string or list of policy oids.`,
@@ -422,5 +455,321 @@ for "generate_lease".`,
 		},
 	}
 
+	fields["prohibited_common_names"] = &framework.FieldSchema{
+		Type: framework.TypeCommaStringSlice,
+		Description: `A comma-separated string or list of common names
+that may never be issued by this role. Names are
+compared case-insensitively.`,
+	}
+
+	fields["allow_wildcard_certificates"] = &framework.FieldSchema{
+		Type:    framework.TypeBool,
+		Default: false,
+		Description: `If set, clients can request wildcard certificates
+such as "*.example.com" when "example.com" is one of
+the allowed domains. The wildcard may only replace
+the leftmost label. Defaults to false.`,
+	}
+
+	fields["extra_extensions"] = &framework.FieldSchema{
+		Type: framework.TypeSlice,
+		Description: `A list of custom X.509 extensions to include in CSRs
+generated for this role. Each entry is an object with
+"oid" (dotted OID string), "value_hex" (hex encoded DER
+extension value) and "critical" (bool). Keyfactor and the
+issuing CA must be configured to preserve custom
+extensions from the CSR, otherwise they are dropped.`,
+	}
+
+	fields["ocsp_server_url"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `The OCSP responder URL for certificates issued by this
+role, overriding the backend configuration. This value is
+advisory only; the AIA extension of issued certificates is
+controlled by the Keyfactor template.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "OCSP Server URL",
+		},
+	}
+
+	fields["email_address"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `An email address to include in the subject of CSRs
+generated for this role as an emailAddress attribute.
+This is distinct from email SANs. It can be overridden
+by the email_address field of the issue request.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Subject Email Address",
+		},
+	}
+
+	fields["dns_sans_validation_regex"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `A regular expression every requested DNS SAN must
+match in full, in addition to the allowed domains check.
+If empty, DNS SANs are only checked against the allowed
+domains.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "DNS SANs Validation Regex",
+		},
+	}
+
+	fields["delegate_to_role"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `The name of a parent role to issue certificates
+through. Names are still validated against this role's
+allowed domains, which must be covered by the parent
+role's, including subdomains if this role allows them.
+The certificate profile (extensions, email address, key
+size, signature algorithm, key usage, DN order and OCSP
+server) and the allowed_cas and allowed_custom_fields
+restrictions come from the parent role, while quotas and
+stored certificate limits are those of this role.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Delegate To Role",
+		},
+	}
+
+	fields["auto_renew"] = &framework.FieldSchema{
+		Type: framework.TypeBool,
+		Description: `If set, certificates issued by this role are renewed
+in Keyfactor in the background when they are within
+renew_before of expiry.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Auto Renew",
+		},
+	}
+
+	fields["renew_before"] = &framework.FieldSchema{
+		Type: framework.TypeDurationSecond,
+		Description: `How long before expiry certificates of this role are
+renewed when auto_renew is set. Defaults to 30 days.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Renew Before",
+		},
+	}
+
+	fields["revoke_on_renew"] = &framework.FieldSchema{
+		Type:    framework.TypeBool,
+		Default: true,
+		Description: `If set, a certificate renewed by auto_renew is revoked
+with the superseded reason once the renewed certificate is stored.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Revoke On Renew",
+		},
+	}
+
+	fields["allow_loopback_ip_sans"] = &framework.FieldSchema{
+		Type: framework.TypeBool,
+		Description: `If set, loopback addresses are allowed as IP
+Subject Alternative Names.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Allow Loopback IP SANs",
+		},
+	}
+
+	fields["allow_multicast_ip_sans"] = &framework.FieldSchema{
+		Type: framework.TypeBool,
+		Description: `If set, multicast addresses are allowed as IP
+Subject Alternative Names.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Allow Multicast IP SANs",
+		},
+	}
+
+	fields["allow_link_local_ip_sans"] = &framework.FieldSchema{
+		Type: framework.TypeBool,
+		Description: `If set, link-local addresses are allowed as IP
+Subject Alternative Names.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Allow Link-Local IP SANs",
+		},
+	}
+
+	fields["quota_max_issuances"] = &framework.FieldSchema{
+		Type: framework.TypeInt,
+		Description: `The maximum number of certificates the role may issue
+or sign, including through ACME and sign-spiffe, within quota_period. 0 means
+unlimited.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Quota Max Issuances",
+		},
+	}
+
+	fields["quota_period"] = &framework.FieldSchema{
+		Type: framework.TypeDurationSecond,
+		Description: `The period quota_max_issuances applies to. The count
+is reset when the period has elapsed. Defaults to 24 hours.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Quota Period",
+		},
+	}
+
+	fields["max_san_count"] = &framework.FieldSchema{
+		Type: framework.TypeInt,
+		Description: `The maximum number of subject alternative names,
+counting DNS, IP, URI and email SANs, a certificate may request or a signed
+CSR may contain. 0 means unlimited.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Max SAN Count",
+		},
+	}
+
+	fields["max_dns_sans"] = &framework.FieldSchema{
+		Type: framework.TypeInt,
+		Description: `The maximum number of DNS SANs a certificate may request,
+checked separately from max_san_count. 0 means unlimited.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Max DNS SANs",
+		},
+	}
+
+	fields["max_ip_sans"] = &framework.FieldSchema{
+		Type: framework.TypeInt,
+		Description: `The maximum number of IP SANs a certificate may request,
+checked separately from max_san_count. 0 means unlimited.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Max IP SANs",
+		},
+	}
+
+	fields["max_uri_sans"] = &framework.FieldSchema{
+		Type: framework.TypeInt,
+		Description: `The maximum number of URI SANs a certificate may request,
+checked separately from max_san_count. 0 means unlimited.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Max URI SANs",
+		},
+	}
+
+	fields["max_email_sans"] = &framework.FieldSchema{
+		Type: framework.TypeInt,
+		Description: `The maximum number of email SANs a certificate may request,
+checked separately from max_san_count. 0 means unlimited.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Max Email SANs",
+		},
+	}
+
+	fields["min_rsa_key_bits"] = &framework.FieldSchema{
+		Type: framework.TypeInt,
+		Description: `The minimum size in bits of RSA keys in certificates
+issued by the role, e.g. 3072. 0 means no minimum beyond the 2048 bits
+always required.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Minimum RSA Key Bits",
+		},
+	}
+
+	fields["min_ec_key_curve"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `The weakest elliptic curve allowed for EC keys in
+certificates issued by the role: "P-256", "P-384" or "P-521". Empty means
+any curve.`,
+		AllowedValues: []interface{}{"", "P-256", "P-384", "P-521"},
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Minimum EC Key Curve",
+		},
+	}
+
+	fields["allowed_signature_algorithms"] = &framework.FieldSchema{
+		Type: framework.TypeCommaStringSlice,
+		Description: `The signature algorithms CSRs for the role may be signed
+with, e.g. "SHA256WithRSA,ECDSAWithSHA384". Keys generated by the issue path
+sign their CSR with the first RSA algorithm listed. Empty allows any.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Allowed Signature Algorithms",
+		},
+	}
+
+	fields["allowed_cas"] = &framework.FieldSchema{
+		Type: framework.TypeCommaStringSlice,
+		Description: `The CAs the role may enroll with, by logical name or
+host\\logical name. If empty, any CA may be used.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Allowed CAs",
+		},
+	}
+
+	fields["allowed_custom_fields"] = &framework.FieldSchema{
+		Type: framework.TypeCommaStringSlice,
+		Description: `The Keyfactor custom enrollment fields requests may set
+with custom_fields. "*" allows any field. If empty, no custom fields
+may be set.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Allowed Custom Fields",
+		},
+	}
+
+	fields["dn_order"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `The order of the relative distinguished names in the
+subject of generated CSRs: "rfc4514" encodes C, ST, L, O, OU and CN
+from outermost to innermost, "legacy" encodes them in reverse.`,
+		Default:       dnOrderRFC4514,
+		AllowedValues: []interface{}{dnOrderRFC4514, dnOrderLegacy},
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "DN Order",
+		},
+	}
+
+	fields["subject_serial_number"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `A serialNumber attribute to include in the subject of CSRs
+generated for this role, for certificate profiles that require
+one. This is distinct from the X.509 serial number of the
+certificate. At most 64 characters. It can be overridden by
+the subject_serial_number field of the issue request.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Subject Serial Number",
+		},
+	}
+
+	fields["spiffe_trust_domain"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `The SPIFFE trust domain, such as "example.org", of the
+SVIDs this role may sign with sign-spiffe. If empty, the
+role cannot sign SVIDs.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "SPIFFE Trust Domain",
+		},
+	}
+
+	fields["key_usage_purpose"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `The purpose of the keys of this role: "server" requests the
+serverAuth extended key usage in generated CSRs, "client"
+requests clientAuth and "both" requests both. CSRs signed
+with the role that request extended key usages must request
+the serverAuth and clientAuth usages of the purpose and no
+others.`,
+		Default:       keyUsagePurposeServer,
+		AllowedValues: []interface{}{keyUsagePurposeServer, keyUsagePurposeClient, keyUsagePurposeBoth},
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Key Usage Purpose",
+		},
+	}
+
+	fields["max_stored_certs"] = &framework.FieldSchema{
+		Type: framework.TypeInt,
+		Description: `The maximum number of certificates issued with this role
+that are stored at once. Issuing another fails unless
+purge_oldest_on_limit is set. 0 means unlimited.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Max Stored Certificates",
+		},
+	}
+
+	fields["purge_oldest_on_limit"] = &framework.FieldSchema{
+		Type: framework.TypeBool,
+		Description: `If set, reaching max_stored_certs does not fail the
+issuance; once the new certificate is issued, the oldest
+certificate of this role is revoked and removed from the
+role's index.`,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Purge Oldest On Limit",
+		},
+	}
+
 	return fields
 }
@@ -0,0 +1,286 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// instanceSeparator separates an instance name from the CA or template
+	// value it qualifies, e.g. "prod/WebServer".
+	instanceSeparator = "/"
+
+	// instanceStoragePrefix is where the instance that issued a certificate
+	// is recorded, keyed by serial number.
+	instanceStoragePrefix = "kfInstance/"
+)
+
+// enrollmentTarget is the Keyfactor instance, CA and template an enrollment
+// request has been resolved to.
+type enrollmentTarget struct {
+	Instance string
+	Config   *keyfactorConfig
+	CA       string
+	Template string
+}
+
+// instanceFields are the configuration fields that may be set per instance
+// in `keyfactor_instances`: the connection, credentials and enrollment
+// defaults of the instance.  Every other setting, such as the API paths,
+// enrollment format, timeouts, retries, error map and circuit breaker, is
+// taken from the top-level configuration.
+var instanceFields = []string{
+	"url",
+	"api_path",
+	"username",
+	"password",
+	"domain",
+	"client_id",
+	"client_secret",
+	"token_url",
+	"access_token",
+	"skip_verify",
+	"scopes",
+	"audience",
+	"ca",
+	"template",
+	"command_cert_path",
+	"issuer_name",
+	"issuer_names",
+}
+
+// parseInstances converts the raw `keyfactor_instances` field into instance
+// configurations keyed by instance name, rejecting fields that are not in
+// instanceFields.
+func parseInstances(raw map[string]interface{}) (map[string]*keyfactorConfig, error) {
+	instances := make(map[string]*keyfactorConfig, len(raw))
+	for name, value := range raw {
+		if name == "" || strings.Contains(name, instanceSeparator) {
+			return nil, fmt.Errorf("invalid instance name %q; names must be non-empty and must not contain %q", name, instanceSeparator)
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse configuration for instance %q: %w", name, err)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(encoded, &fields); err != nil {
+			return nil, fmt.Errorf("unable to parse configuration for instance %q: %w", name, err)
+		}
+		for field := range fields {
+			if !slices.Contains(instanceFields, field) {
+				return nil, fmt.Errorf("%s may not be set for instance %q; it is taken from the top-level configuration", field, name)
+			}
+		}
+		instance := &keyfactorConfig{}
+		if err := json.Unmarshal(encoded, instance); err != nil {
+			return nil, fmt.Errorf("unable to parse configuration for instance %q: %w", name, err)
+		}
+		if instance.KeyfactorUrl == "" {
+			return nil, fmt.Errorf("the url for instance %q was not defined", name)
+		}
+		if instance.CommandAPIPath == "" {
+			instance.CommandAPIPath = "KeyfactorAPI"
+		}
+		instances[name] = instance
+	}
+	return instances, nil
+}
+
+// instancesResponseData returns the configured instances for display,
+// obscuring credentials unless showSensitive is set.
+func (c *keyfactorConfig) instancesResponseData(showSensitive bool) map[string]interface{} {
+	result := make(map[string]interface{}, len(c.Instances))
+	for name, instance := range c.Instances {
+		password := instance.Password
+		clientSecret := instance.ClientSecret
+		accessToken := instance.AccessToken
+		if !showSensitive {
+			password = redactSensitive(password)
+			clientSecret = redactSensitive(clientSecret)
+			accessToken = redactSensitive(accessToken)
+		}
+		result[name] = map[string]interface{}{
+			"url":               instance.KeyfactorUrl,
+			"api_path":          instance.CommandAPIPath,
+			"username":          instance.Username,
+			"password":          password,
+			"client_id":         instance.ClientId,
+			"client_secret":     clientSecret,
+			"token_url":         instance.TokenUrl,
+			"access_token":      accessToken,
+			"ca":                instance.CertAuthority,
+			"template":          instance.CertTemplate,
+			"command_cert_path": instance.CommandCertPath,
+			"skip_verify":       instance.SkipTLSVerify,
+			"domain":            instance.Domain,
+			"issuer_name":       instance.IssuerName,
+			"issuer_names":      instance.IssuerNames,
+		}
+	}
+	return result
+}
+
+// instanceConfig returns the configuration for the named instance: the
+// top-level configuration with the instanceFields of the instance.  An empty
+// name refers to the top-level (default) configuration.
+func (c *keyfactorConfig) instanceConfig(name string) (*keyfactorConfig, error) {
+	if name == "" {
+		return c, nil
+	}
+	instance, ok := c.Instances[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown Keyfactor instance %q", name)
+	}
+
+	merged := *c
+	merged.Instances = nil
+	merged.KeyfactorUrl = instance.KeyfactorUrl
+	merged.CommandAPIPath = instance.CommandAPIPath
+	merged.Username = instance.Username
+	merged.Password = instance.Password
+	merged.Domain = instance.Domain
+	merged.ClientId = instance.ClientId
+	merged.ClientSecret = instance.ClientSecret
+	merged.TokenUrl = instance.TokenUrl
+	merged.AccessToken = instance.AccessToken
+	merged.SkipTLSVerify = instance.SkipTLSVerify
+	merged.Scopes = instance.Scopes
+	merged.Audience = instance.Audience
+	merged.CertAuthority = instance.CertAuthority
+	merged.CertTemplate = instance.CertTemplate
+	merged.CommandCertPath = instance.CommandCertPath
+	merged.IssuerName = instance.IssuerName
+	merged.IssuerNames = instance.IssuerNames
+	return &merged, nil
+}
+
+// splitInstance splits a value in `<instance>/<value>` notation.  Values that
+// are not prefixed with the name of a configured instance are returned
+// unchanged with an empty instance name.
+func (c *keyfactorConfig) splitInstance(value string) (string, string) {
+	parts := strings.SplitN(value, instanceSeparator, 2)
+	if len(parts) != 2 {
+		return "", value
+	}
+	if _, ok := c.Instances[parts[0]]; !ok {
+		return "", value
+	}
+	return parts[0], parts[1]
+}
+
+// resolveEnrollmentTarget determines which Keyfactor instance a request for
+// the given CA and template should be routed to, filling in the CA and
+// template defaults of that instance when they are not provided.
+func (c *keyfactorConfig) resolveEnrollmentTarget(caName string, templateName string) (*enrollmentTarget, error) {
+	caInstance, caName := c.splitInstance(caName)
+	templateInstance, templateName := c.splitInstance(templateName)
+
+	if caInstance != "" && templateInstance != "" && caInstance != templateInstance {
+		return nil, fmt.Errorf("ca instance %q does not match template instance %q", caInstance, templateInstance)
+	}
+
+	instance := caInstance
+	if instance == "" {
+		instance = templateInstance
+	}
+
+	config, err := c.instanceConfig(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	if caName == "" {
+		caName = config.CertAuthority
+	}
+	if templateName == "" {
+		templateName = config.CertTemplate
+	}
+
+	return &enrollmentTarget{
+		Instance: instance,
+		Config:   config,
+		CA:       caName,
+		Template: templateName,
+	}, nil
+}
+
+// issuerName returns the name identifying the issuer of certificates
+// enrolled with the target: the issuer_names entry of its CA, else the
+// issuer_name of its instance.
+func (t *enrollmentTarget) issuerName() string {
+	if name, ok := t.Config.IssuerNames[t.CA]; ok {
+		return name
+	}
+	return t.Config.IssuerName
+}
+
+// getInstanceClient returns the client for the named Keyfactor instance,
+// creating it on first use.  An empty name returns the default client.
+func (b *keyfactorBackend) getInstanceClient(ctx context.Context, s logical.Storage, instance string) (*keyfactorClient, error) {
+	if instance == "" {
+		return b.getClient(ctx, s)
+	}
+
+	config, err := b.fetchConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("configuration is empty")
+	}
+
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
+	if client, ok := b.instanceClients[instance]; ok {
+		return client, nil
+	}
+
+	instanceConfig, err := config.instanceConfig(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newClient(instanceConfig, b)
+	if err != nil {
+		return nil, err
+	}
+	if b.instanceClients == nil {
+		b.instanceClients = make(map[string]*keyfactorClient)
+	}
+	b.instanceClients[instance] = client
+	return client, nil
+}
+
+// getCertInstance returns the name of the Keyfactor instance that issued the
+// certificate with the given serial, or an empty string for the default
+// instance.
+func getCertInstance(ctx context.Context, s logical.Storage, serial string) (string, error) {
+	entry, err := s.Get(ctx, instanceStoragePrefix+normalizeSerial(serial))
+	if err != nil {
+		return "", err
+	}
+	if entry == nil {
+		return "", nil
+	}
+	var instance string
+	if err := entry.DecodeJSON(&instance); err != nil {
+		return "", err
+	}
+	return instance, nil
+}
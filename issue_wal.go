@@ -0,0 +1,197 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// issueWALPrefix holds an entry for every issuance sent to Keyfactor that
+	// has not yet been stored, keyed by Vault request ID.
+	issueWALPrefix = "wal/issue/"
+
+	// issueWALMinAge is how old an entry must be before the periodic function
+	// recovers it, so that issuances still in flight are left alone.
+	issueWALMinAge = 10 * time.Minute
+
+	// issueWALClockSkew allows for the clocks of Vault and Keyfactor to differ
+	// when matching certificates issued after an entry was written.
+	issueWALClockSkew = 5 * time.Minute
+)
+
+// issueWAL records an issuance before it is sent to Keyfactor, so that the
+// certificate can be found and stored if Vault stops before storing it.
+type issueWAL struct {
+	CommonName string    `json:"common_name"`
+	DNSSANs    []string  `json:"dns_sans"`
+	IPSANs     []string  `json:"ip_sans"`
+	Role       string    `json:"role"`
+	CA         string    `json:"ca"`
+	Template   string    `json:"template"`
+	EntityID   string    `json:"entity_id"`
+	PublicKey  []byte    `json:"public_key_sha256"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// putIssueWAL writes the write-ahead log entry of an issuance for the CSR.
+func putIssueWAL(ctx context.Context, s logical.Storage, requestId string, csr string, wal *issueWAL) error {
+	block, _ := pem.Decode([]byte(csr))
+	if block == nil {
+		return errors.New("unable to decode the CSR")
+	}
+	parsed, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("unable to parse the CSR: %w", err)
+	}
+	sum := sha256.Sum256(parsed.RawSubjectPublicKeyInfo)
+	wal.PublicKey = sum[:]
+	wal.CreatedAt = time.Now()
+
+	entry, err := logical.StorageEntryJSON(issueWALPrefix+requestId, wal)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// processIssueWAL completes the issuances recorded in the write-ahead log at
+// least minAge ago: the certificate is looked up in Keyfactor by common name
+// and public key and stored if it was issued.  Entries are deleted once the
+// certificate is stored or Keyfactor is found not to have issued it.
+func (b *keyfactorBackend) processIssueWAL(ctx context.Context, s logical.Storage, minAge time.Duration) error {
+	requestIds, err := s.List(ctx, issueWALPrefix)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, requestId := range requestIds {
+		entry, err := s.Get(ctx, issueWALPrefix+requestId)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+		var wal issueWAL
+		if err := entry.DecodeJSON(&wal); err != nil {
+			return err
+		}
+		if time.Since(wal.CreatedAt) < minAge {
+			continue
+		}
+
+		serial, err := b.recoverIssuance(ctx, s, &wal)
+		if err != nil {
+			b.Logger().Warn("unable to recover issuance from the write-ahead log", "request_id", requestId, "error", err)
+			errs = append(errs, err)
+			continue
+		}
+		if serial != "" {
+			b.Logger().Warn("stored certificate recovered from the write-ahead log", "request_id", requestId, "serial", serial, "common_name", wal.CommonName)
+		} else {
+			b.Logger().Info("issuance in the write-ahead log was not completed by Keyfactor", "request_id", requestId, "common_name", wal.CommonName)
+		}
+		if err := s.Delete(ctx, issueWALPrefix+requestId); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// recoverIssuance finds the certificate of a write-ahead log entry in
+// Keyfactor and stores it if it is not stored already.  It returns the serial
+// of the certificate, or "" if Keyfactor did not issue it.
+func (b *keyfactorBackend) recoverIssuance(ctx context.Context, s logical.Storage, wal *issueWAL) (string, error) {
+	if wal.CommonName == "" {
+		// certificates without a common name cannot be searched for
+		return "", nil
+	}
+	if !validQueryValue(wal.CommonName) {
+		// nor can common names that would change the query
+		b.Logger().Warn("unable to search Keyfactor for a common name containing quotation marks or backslashes", "common_name", wal.CommonName)
+		return "", nil
+	}
+	config, err := b.fetchConfig(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	if config == nil {
+		return "", errors.New("unable to load configuration")
+	}
+	target, err := config.resolveEnrollmentTarget(wal.CA, wal.Template)
+	if err != nil {
+		return "", err
+	}
+	client, err := b.getInstanceClient(ctx, s, target.Instance)
+	if err != nil {
+		return "", fmt.Errorf("error getting client: %w", err)
+	}
+
+	var results KeyfactorCertResponse
+	query := url.QueryEscape(fmt.Sprintf(`IssuedCN -eq "%s"`, wal.CommonName))
+	if err := b.getKeyfactorJSON(ctx, s, client, target.Config, "/Certificates?pq.queryString="+query, &results); err != nil {
+		return "", err
+	}
+
+	for _, result := range results {
+		if result.ImportDate.Before(wal.CreatedAt.Add(-issueWALClockSkew)) {
+			continue
+		}
+		der, err := base64.StdEncoding.DecodeString(result.ContentBytes)
+		if err != nil {
+			continue
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		if !bytes.Equal(sum[:], wal.PublicKey) {
+			continue
+		}
+
+		serial := normalizeSerial(result.SerialNumber)
+		existing, err := s.Get(ctx, "certs/"+serial)
+		if err != nil {
+			return "", err
+		}
+		if existing != nil {
+			return serial, nil
+		}
+		certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+		err = b.storeIssuedCert(ctx, s, serial, certPEM, result.ID, 0, &certInfo{
+			Role:       wal.Role,
+			CA:         target.CA,
+			Template:   target.Template,
+			Instance:   target.Instance,
+			IssuedAt:   cert.NotBefore,
+			EntityID:   wal.EntityID,
+			IssuerName: target.issuerName(),
+		})
+		if err != nil {
+			return "", err
+		}
+		return serial, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,128 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// Key purposes of a role, configured with the `key_usage_purpose` role field.
+const (
+	keyUsagePurposeServer = "server"
+	keyUsagePurposeClient = "client"
+	keyUsagePurposeBoth   = "both"
+)
+
+var (
+	// oidExtensionExtKeyUsage is the OID of the extended key usage extension.
+	oidExtensionExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+	oidExtKeyUsageAny        = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+	oidExtKeyUsageServerAuth = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}
+	oidExtKeyUsageClientAuth = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2}
+)
+
+// validateKeyUsagePurpose checks the key_usage_purpose of a role.
+func validateKeyUsagePurpose(purpose string) error {
+	switch purpose {
+	case "", keyUsagePurposeServer, keyUsagePurposeClient, keyUsagePurposeBoth:
+		return nil
+	default:
+		return fmt.Errorf("invalid key_usage_purpose %q; must be %q, %q or %q", purpose, keyUsagePurposeServer, keyUsagePurposeClient, keyUsagePurposeBoth)
+	}
+}
+
+// keyUsagePurpose returns the key purpose of the role, falling back to server
+// for roles stored before the field was introduced.
+func (r *roleEntry) keyUsagePurpose() string {
+	if r.KeyUsagePurpose == "" {
+		return keyUsagePurposeServer
+	}
+	return r.KeyUsagePurpose
+}
+
+// keyUsagePurposeOIDs returns the extended key usages required by a key
+// purpose.
+func keyUsagePurposeOIDs(purpose string) []asn1.ObjectIdentifier {
+	switch purpose {
+	case keyUsagePurposeClient:
+		return []asn1.ObjectIdentifier{oidExtKeyUsageClientAuth}
+	case keyUsagePurposeBoth:
+		return []asn1.ObjectIdentifier{oidExtKeyUsageServerAuth, oidExtKeyUsageClientAuth}
+	default:
+		return []asn1.ObjectIdentifier{oidExtKeyUsageServerAuth}
+	}
+}
+
+// extKeyUsageExtension returns the extended key usage extension requesting
+// the usages of a key purpose.
+func extKeyUsageExtension(purpose string) (pkix.Extension, error) {
+	value, err := asn1.Marshal(keyUsagePurposeOIDs(purpose))
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtensionExtKeyUsage, Value: value}, nil
+}
+
+// checkKeyUsagePurpose returns an error if the CSR requests extended key
+// usages that do not include those of the role's key purpose, or that include
+// serverAuth or clientAuth when the purpose does not.  CSRs without the
+// extension are accepted and the usages are left to the Keyfactor template.
+func checkKeyUsagePurpose(role *roleEntry, csr *x509.CertificateRequest) error {
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(oidExtensionExtKeyUsage) {
+			continue
+		}
+		var usages []asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(ext.Value, &usages); err != nil {
+			return fmt.Errorf("unable to parse the extended key usages of the csr: %w", err)
+		}
+		allowed := keyUsagePurposeOIDs(role.keyUsagePurpose())
+		for _, required := range allowed {
+			if !containsOID(usages, required) {
+				return fmt.Errorf("the csr does not request the %s extended key usage required by the %q key_usage_purpose of the role", extKeyUsageName(required), role.keyUsagePurpose())
+			}
+		}
+		for _, usage := range usages {
+			if (usage.Equal(oidExtKeyUsageServerAuth) || usage.Equal(oidExtKeyUsageClientAuth) || usage.Equal(oidExtKeyUsageAny)) && !containsOID(allowed, usage) {
+				return fmt.Errorf("the csr requests the %s extended key usage, which the %q key_usage_purpose of the role does not allow", extKeyUsageName(usage), role.keyUsagePurpose())
+			}
+		}
+	}
+	return nil
+}
+
+// extKeyUsageName returns the name of an extended key usage checked against
+// key purposes.
+func extKeyUsageName(oid asn1.ObjectIdentifier) string {
+	switch {
+	case oid.Equal(oidExtKeyUsageServerAuth):
+		return "serverAuth"
+	case oid.Equal(oidExtKeyUsageClientAuth):
+		return "clientAuth"
+	case oid.Equal(oidExtKeyUsageAny):
+		return "anyExtendedKeyUsage"
+	default:
+		return oid.String()
+	}
+}
+
+// containsOID reports whether oid is in oids.
+func containsOID(oids []asn1.ObjectIdentifier, oid asn1.ObjectIdentifier) bool {
+	for _, o := range oids {
+		if o.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
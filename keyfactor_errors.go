@@ -0,0 +1,174 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/errutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Actions that can be taken for an error returned by Keyfactor, configured
+// with the `keyfactor_error_map` configuration field.
+const (
+	errorActionUserError     = "user_error"
+	errorActionInternalError = "internal_error"
+	errorActionRetry         = "retry"
+)
+
+const (
+	// maxKeyfactorRetries is the number of times a request is retried when
+	// the error map classifies the error as retryable.
+	maxKeyfactorRetries = 3
+
+	// defaultEnrollmentMaxRetries and defaultRevokeMaxRetries are the
+	// defaults of enrollment_max_retries and revoke_max_retries.
+	defaultEnrollmentMaxRetries = 3
+	defaultRevokeMaxRetries     = 3
+
+	keyfactorRetryBackoff = 500 * time.Millisecond
+)
+
+// enrollmentMaxRetries returns the number of times an enrollment is retried.
+func (c *keyfactorConfig) enrollmentMaxRetries() int {
+	if c == nil {
+		return defaultEnrollmentMaxRetries
+	}
+	return c.EnrollmentMaxRetries
+}
+
+// revokeMaxRetries returns the number of times a revocation is retried.
+func (c *keyfactorConfig) revokeMaxRetries() int {
+	if c == nil {
+		return defaultRevokeMaxRetries
+	}
+	return c.RevokeMaxRetries
+}
+
+// keyfactorErrorBody is the error body returned by the Keyfactor Command API.
+type keyfactorErrorBody struct {
+	ErrorCode string `json:"ErrorCode"`
+	Message   string `json:"Message"`
+}
+
+// parseErrorMap validates the raw `keyfactor_error_map` field.
+func parseErrorMap(raw map[string]interface{}) (map[string]string, error) {
+	errorMap := make(map[string]string, len(raw))
+	for code, value := range raw {
+		action, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("action for error code %q must be a string", code)
+		}
+		switch action {
+		case errorActionUserError, errorActionInternalError, errorActionRetry:
+		default:
+			return nil, fmt.Errorf("invalid action %q for error code %q; must be one of %s, %s or %s",
+				action, code, errorActionUserError, errorActionInternalError, errorActionRetry)
+		}
+		errorMap[code] = action
+	}
+	return errorMap, nil
+}
+
+// errorAction classifies an error response from Keyfactor.  The Keyfactor
+// error code is looked up in the error map first, then the HTTP status code.
+// Unmapped 4xx responses are user errors and everything else is internal.
+func (c *keyfactorConfig) errorAction(statusCode int, errorCode string) string {
+	if c != nil {
+		if action, ok := c.ErrorMap[errorCode]; ok && errorCode != "" {
+			return action
+		}
+		if action, ok := c.ErrorMap[strconv.Itoa(statusCode)]; ok {
+			return action
+		}
+	}
+	if statusCode >= 400 && statusCode < 500 {
+		return errorActionUserError
+	}
+	return errorActionInternalError
+}
+
+// sendKeyfactorRequest sends a request to Keyfactor and reads the response
+// body.  Unsuccessful responses are classified with the configured error map
+// and returned as an errutil.UserError or errutil.InternalError, after
+// retrying the request if the error is mapped to the retry action.
+func (b *keyfactorBackend) sendKeyfactorRequest(ctx context.Context, s logical.Storage, client *keyfactorClient, httpReq *http.Request) (*http.Response, []byte, error) {
+	return b.sendKeyfactorRequestWithRetries(ctx, s, client, httpReq, maxKeyfactorRetries)
+}
+
+// sendKeyfactorRequestWithRetries is sendKeyfactorRequest with the number of
+// retries of retryable errors given by the caller.
+func (b *keyfactorBackend) sendKeyfactorRequestWithRetries(ctx context.Context, s logical.Storage, client *keyfactorClient, httpReq *http.Request, maxRetries int) (*http.Response, []byte, error) {
+	config, err := b.fetchConfig(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		attemptReq := httpReq
+		if attempt > 0 {
+			attemptReq = httpReq.Clone(httpReq.Context())
+			if httpReq.GetBody != nil {
+				if attemptReq.Body, err = httpReq.GetBody(); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+
+		res, err := b.sendRequest(ctx, s, client, attemptReq)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := readKeyfactorResponse(attemptReq.Context(), config, res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if res.StatusCode >= 200 && res.StatusCode < 300 {
+			return res, body, nil
+		}
+
+		var errBody keyfactorErrorBody
+		_ = json.Unmarshal(body, &errBody)
+		action := config.errorAction(res.StatusCode, errBody.ErrorCode)
+		b.Logger().Debug("Keyfactor returned an error", "status", res.StatusCode, "error_code", errBody.ErrorCode, "action", action)
+
+		if action == errorActionRetry && attempt < maxRetries {
+			b.Logger().Warn("retrying request to Keyfactor", "attempt", attempt+1, "status", res.StatusCode, "error_code", errBody.ErrorCode)
+			select {
+			case <-time.After(keyfactorRetryBackoff * time.Duration(attempt+1)):
+			case <-httpReq.Context().Done():
+				return nil, nil, httpReq.Context().Err()
+			}
+			continue
+		}
+
+		msg := fmt.Sprintf("Keyfactor returned status code %d and error: %s", res.StatusCode, string(body))
+		if action == errorActionUserError {
+			return res, body, errutil.UserError{Err: msg}
+		}
+		return res, body, errutil.InternalError{Err: msg}
+	}
+}
+
+// keyfactorErrorResponse converts an error from a Keyfactor request into the
+// response for a path, returning user errors as error responses.
+func keyfactorErrorResponse(prefix string, err error) (*logical.Response, error) {
+	if _, ok := err.(errutil.UserError); ok {
+		return logical.ErrorResponse(fmt.Sprintf("%s: %s", prefix, err)), nil
+	}
+	return nil, fmt.Errorf("%s: %w", prefix, err)
+}
@@ -0,0 +1,110 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Formats of the Keyfactor certificate IDs stored under kfId/, configured
+// with the `keyfactor_id_format` configuration field.
+const (
+	keyfactorIdFormatInt = "int"
+	keyfactorIdFormatHex = "hex"
+)
+
+// validateKeyfactorIdFormat checks that the format is one the backend can store.
+func validateKeyfactorIdFormat(format string) error {
+	switch format {
+	case keyfactorIdFormatInt, keyfactorIdFormatHex:
+		return nil
+	default:
+		return fmt.Errorf("invalid keyfactor_id_format %q; must be %q or %q", format, keyfactorIdFormatInt, keyfactorIdFormatHex)
+	}
+}
+
+// keyfactorIdFormat returns the configured Keyfactor ID format, falling back
+// to int when it is unset.
+func (c *keyfactorConfig) keyfactorIdFormat() string {
+	if c == nil || c.KeyfactorIDFormat == "" {
+		return keyfactorIdFormatInt
+	}
+	return c.KeyfactorIDFormat
+}
+
+// keyfactorIdWithRequest is the kfId/ entry of a certificate whose Keyfactor
+// enrollment request ID is known.  ID is stored as a bare kfId/ entry would be.
+type keyfactorIdWithRequest struct {
+	ID        interface{} `json:"id"`
+	RequestID int         `json:"request_id"`
+}
+
+// keyfactorIdStorageEntry returns the kfId/ entry for the certificate with the
+// given serial, holding the Keyfactor ID as a JSON integer or, in the hex
+// format, as a JSON string of hex digits.  A non-zero Keyfactor request ID is
+// stored alongside it in a keyfactorIdWithRequest object.
+func keyfactorIdStorageEntry(serial string, kfId int, requestId int, format string) (*logical.StorageEntry, error) {
+	var id interface{} = kfId
+	if format == keyfactorIdFormatHex {
+		id = strconv.FormatInt(int64(kfId), 16)
+	}
+	if requestId != 0 {
+		return logical.StorageEntryJSON("kfId/"+serial, keyfactorIdWithRequest{ID: id, RequestID: requestId})
+	}
+	return logical.StorageEntryJSON("kfId/"+serial, id)
+}
+
+// decodeKeyfactorId returns the Keyfactor ID held by a kfId/ entry in either
+// format, so that entries remain readable when keyfactor_id_format changes.
+func decodeKeyfactorId(entry *logical.StorageEntry) (int, error) {
+	kfId, _, err := decodeKeyfactorIdEntry(entry)
+	return kfId, err
+}
+
+// decodeKeyfactorIdEntry returns the Keyfactor ID and, if it was recorded,
+// the Keyfactor request ID held by a kfId/ entry.
+func decodeKeyfactorIdEntry(entry *logical.StorageEntry) (int, int, error) {
+	var raw json.RawMessage
+	if err := entry.DecodeJSON(&raw); err != nil {
+		return 0, 0, err
+	}
+	var withRequest struct {
+		ID        json.RawMessage `json:"id"`
+		RequestID int             `json:"request_id"`
+	}
+	if err := json.Unmarshal(raw, &withRequest); err == nil && withRequest.ID != nil {
+		kfId, err := decodeKeyfactorIdValue(withRequest.ID)
+		return kfId, withRequest.RequestID, err
+	}
+	kfId, err := decodeKeyfactorIdValue(raw)
+	return kfId, 0, err
+}
+
+// decodeKeyfactorIdValue returns a Keyfactor ID stored as a JSON integer or
+// as a JSON string of hex digits.
+func decodeKeyfactorIdValue(raw json.RawMessage) (int, error) {
+	var hexId string
+	if err := json.Unmarshal(raw, &hexId); err == nil {
+		kfId, err := strconv.ParseInt(hexId, 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid hex Keyfactor ID %q: %w", hexId, err)
+		}
+		return int(kfId), nil
+	}
+	var kfId int
+	if err := json.Unmarshal(raw, &kfId); err != nil {
+		return 0, err
+	}
+	return kfId, nil
+}
@@ -0,0 +1,124 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/helper/errutil"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// metadataSchemaURL is the name the configured metadata schema is compiled under.
+const metadataSchemaURL = "mem:///metadata_schema.json"
+
+// compileMetadataSchema compiles a draft-07 JSON schema for certificate metadata.
+func compileMetadataSchema(schema string) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft7
+	// the schema must be self-contained; never read files or URLs it references
+	compiler.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external schema references are not supported: %s", s)
+	}
+	if err := compiler.AddResource(metadataSchemaURL, strings.NewReader(schema)); err != nil {
+		return nil, fmt.Errorf("invalid metadata_schema: %w", err)
+	}
+	compiled, err := compiler.Compile(metadataSchemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata_schema: %w", err)
+	}
+	return compiled, nil
+}
+
+const (
+	// defaultMaxMetadataBytes is the largest metadata JSON accepted unless
+	// max_metadata_bytes is set.
+	defaultMaxMetadataBytes = 4096
+
+	// defaultMaxMetadataKeys is the number of top-level metadata fields
+	// accepted unless max_metadata_keys is set.
+	defaultMaxMetadataKeys = 50
+)
+
+// metadataLimits returns the configured metadata size and key limits,
+// falling back to the defaults when they are unset.
+func (c *keyfactorConfig) metadataLimits() (int, int) {
+	maxBytes, maxKeys := defaultMaxMetadataBytes, defaultMaxMetadataKeys
+	if c != nil && c.MaxMetadataBytes > 0 {
+		maxBytes = c.MaxMetadataBytes
+	}
+	if c != nil && c.MaxMetadataKeys > 0 {
+		maxKeys = c.MaxMetadataKeys
+	}
+	return maxBytes, maxKeys
+}
+
+// checkMetadataLimits checks the metadata JSON of a request against the
+// configured max_metadata_bytes and max_metadata_keys.
+func checkMetadataLimits(config *keyfactorConfig, metaDataJson string) error {
+	maxBytes, maxKeys := config.metadataLimits()
+	if len(metaDataJson) > maxBytes {
+		return errutil.UserError{Err: fmt.Sprintf("metadata is %d bytes, more than the %d bytes allowed by max_metadata_bytes", len(metaDataJson), maxBytes)}
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(metaDataJson), &fields); err != nil {
+		// metadata that is not an object is rejected by Keyfactor
+		return nil
+	}
+	if len(fields) > maxKeys {
+		return errutil.UserError{Err: fmt.Sprintf("metadata has %d fields, more than the %d allowed by max_metadata_keys", len(fields), maxKeys)}
+	}
+	return nil
+}
+
+// validateMetadata validates the metadata JSON of a request against the
+// configured metadata_schema, if any.  Violations are returned as a user
+// error listing the location and message of each.
+func validateMetadata(config *keyfactorConfig, metaDataJson string) error {
+	if config.MetadataSchema == "" {
+		return nil
+	}
+	compiled, err := compileMetadataSchema(config.MetadataSchema)
+	if err != nil {
+		return err
+	}
+
+	var metadata interface{}
+	decoder := json.NewDecoder(strings.NewReader(metaDataJson))
+	decoder.UseNumber()
+	if err := decoder.Decode(&metadata); err != nil {
+		return errutil.UserError{Err: fmt.Sprintf("unable to parse metadata: %s", err)}
+	}
+	err = compiled.Validate(metadata)
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return err
+	}
+
+	var violations []string
+	for _, violation := range validationErr.BasicOutput().Errors {
+		if violation.Error == "" || strings.HasPrefix(violation.Error, "doesn't validate with") {
+			continue
+		}
+		location := violation.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		violations = append(violations, fmt.Sprintf("%s: %s", location, violation.Error))
+	}
+	if len(violations) == 0 {
+		violations = append(violations, validationErr.Error())
+	}
+	return errutil.UserError{Err: "metadata does not match metadata_schema: " + strings.Join(violations, "; ")}
+}
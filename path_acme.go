@@ -0,0 +1,777 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// acmeReply is the response of an ACME endpoint.  Body is encoded as JSON
+// unless Raw is set.
+type acmeReply struct {
+	Status      int
+	Body        interface{}
+	Raw         []byte
+	ContentType string
+	Location    string
+	Up          string
+}
+
+// acmeHandler handles an ACME request once the backend has been found to be
+// configured for ACME.
+type acmeHandler func(ctx context.Context, req *logical.Request, data *framework.FieldData, config *keyfactorConfig) (*acmeReply, error)
+
+// acmeJWSFields adds the fields of a flattened JWS request body to fields.
+func acmeJWSFields(fields map[string]*framework.FieldSchema) map[string]*framework.FieldSchema {
+	fields["protected"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "The base64url-encoded protected header of the JWS.",
+	}
+	fields["payload"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "The base64url-encoded payload of the JWS.",
+	}
+	fields["signature"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "The base64url-encoded signature of the JWS.",
+	}
+	return fields
+}
+
+func pathACME(b *keyfactorBackend) []*framework.Path {
+	idField := func(name string, description string) map[string]*framework.FieldSchema {
+		return acmeJWSFields(map[string]*framework.FieldSchema{
+			name: {
+				Type:        framework.TypeString,
+				Description: description,
+			},
+		})
+	}
+	return []*framework.Path{
+		{
+			Pattern: "acme/directory",
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.acmeOperation(b.pathACMEDirectory),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		{
+			Pattern: "acme/new-nonce",
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   b.acmeOperation(b.pathACMENewNonce),
+				logical.HeaderOperation: b.acmeOperation(b.pathACMENewNonce),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		{
+			Pattern: "acme/new-account",
+			Fields:  acmeJWSFields(map[string]*framework.FieldSchema{}),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeOperation(b.pathACMENewAccount),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		{
+			Pattern: "acme/account/(?P<account_id>[A-Za-z0-9_-]+)",
+			Fields:  idField("account_id", "The ID of the ACME account."),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeOperation(b.pathACMEAccount),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		{
+			Pattern: "acme/new-order",
+			Fields:  acmeJWSFields(map[string]*framework.FieldSchema{}),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeOperation(b.pathACMENewOrder),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		{
+			Pattern: "acme/order/(?P<order_id>[0-9a-f-]+)",
+			Fields:  idField("order_id", "The ID of the ACME order."),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeOperation(b.pathACMEOrder),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		{
+			Pattern: "acme/order/(?P<order_id>[0-9a-f-]+)/finalize",
+			Fields:  idField("order_id", "The ID of the ACME order."),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeOperation(b.pathACMEFinalize),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		{
+			Pattern: "acme/order/(?P<order_id>[0-9a-f-]+)/cert",
+			Fields:  idField("order_id", "The ID of the ACME order."),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeOperation(b.pathACMECertificate),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		{
+			Pattern: "acme/authorization/(?P<authz_id>[0-9a-f-]+)",
+			Fields:  idField("authz_id", "The ID of the ACME authorization."),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeOperation(b.pathACMEAuthorization),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		{
+			Pattern: "acme/challenge/(?P<authz_id>[0-9a-f-]+)/http-01",
+			Fields:  idField("authz_id", "The ID of the ACME authorization of the challenge."),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.acmeOperation(b.pathACMEChallenge),
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+	}
+}
+
+// acmeOperation wraps an ACME handler, checking that ACME is enabled and
+// configured and rendering its reply or error as an ACME response with a
+// fresh Replay-Nonce.
+func (b *keyfactorBackend) acmeOperation(handler acmeHandler) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.fetchConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		enabled, err := b.featureEnabled(ctx, req.Storage, featureACME)
+		if err != nil {
+			return nil, err
+		}
+
+		var reply *acmeReply
+		switch {
+		case !enabled:
+			err = acmeProblem(http.StatusForbidden, "unauthorized", "ACME is not enabled on this mount")
+		case config == nil || config.ACMEBaseURL == "" || config.ACMERole == "":
+			err = acmeProblem(http.StatusForbidden, "unauthorized", "acme_base_url and acme_role must be configured to use ACME")
+		default:
+			reply, err = handler(ctx, req, data, config)
+		}
+
+		var problem *acmeError
+		if errors.As(err, &problem) {
+			body, _ := json.Marshal(problem)
+			reply = &acmeReply{Status: problem.Status, Raw: body, ContentType: "application/problem+json"}
+		} else if err != nil {
+			b.Logger().Error("ACME request failed", "path", req.Path, "error", err)
+			body, _ := json.Marshal(acmeProblem(http.StatusInternalServerError, "serverInternal", "the request could not be processed"))
+			reply = &acmeReply{Status: http.StatusInternalServerError, Raw: body, ContentType: "application/problem+json"}
+		}
+
+		if reply.Raw == nil && reply.Body != nil {
+			if reply.Raw, err = json.Marshal(reply.Body); err != nil {
+				return nil, err
+			}
+			reply.ContentType = "application/json"
+		}
+		nonce, err := b.newACMENonce(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				logical.HTTPStatusCode: reply.Status,
+				logical.HTTPRawBody:    reply.Raw,
+			},
+			Headers: map[string][]string{
+				"Replay-Nonce":  {nonce},
+				"Cache-Control": {"no-store"},
+			},
+		}
+		if reply.ContentType != "" {
+			resp.Data[logical.HTTPContentType] = reply.ContentType
+		}
+		if config != nil && config.ACMEBaseURL != "" {
+			resp.Headers["Link"] = []string{fmt.Sprintf(`<%s>;rel="index"`, config.acmeURL("acme/directory"))}
+			if reply.Up != "" {
+				resp.Headers["Link"] = append(resp.Headers["Link"], fmt.Sprintf(`<%s>;rel="up"`, reply.Up))
+			}
+		}
+		if reply.Location != "" {
+			resp.Headers["Location"] = []string{reply.Location}
+		}
+		return resp, nil
+	}
+}
+
+// verify verifies the JWS of an ACME POST request.
+func (b *keyfactorBackend) acmeVerify(ctx context.Context, req *logical.Request, data *framework.FieldData, config *keyfactorConfig, allowJWK bool) (*acmeRequest, error) {
+	return b.verifyACMERequest(ctx, req, config, data.Get("protected").(string), data.Get("payload").(string), data.Get("signature").(string), allowJWK)
+}
+
+// acmeDecodePayload decodes the JSON payload of a verified request into out.
+func acmeDecodePayload(verified *acmeRequest, out interface{}) error {
+	if err := json.Unmarshal(verified.Payload, out); err != nil {
+		return acmeProblem(http.StatusBadRequest, "malformed", "unable to parse the payload: %s", err)
+	}
+	return nil
+}
+
+// acmePostAsGet checks that a verified request is a POST-as-GET, with an
+// empty payload.
+func acmePostAsGet(verified *acmeRequest) error {
+	if len(verified.Payload) != 0 {
+		return acmeProblem(http.StatusBadRequest, "malformed", "the payload of a POST-as-GET request must be empty")
+	}
+	return nil
+}
+
+func (b *keyfactorBackend) pathACMEDirectory(ctx context.Context, req *logical.Request, data *framework.FieldData, config *keyfactorConfig) (*acmeReply, error) {
+	return &acmeReply{
+		Status: http.StatusOK,
+		Body: map[string]interface{}{
+			"newNonce":   config.acmeURL("acme/new-nonce"),
+			"newAccount": config.acmeURL("acme/new-account"),
+			"newOrder":   config.acmeURL("acme/new-order"),
+			"meta": map[string]interface{}{
+				"externalAccountRequired": false,
+			},
+		},
+	}, nil
+}
+
+func (b *keyfactorBackend) pathACMENewNonce(ctx context.Context, req *logical.Request, data *framework.FieldData, config *keyfactorConfig) (*acmeReply, error) {
+	if req.Operation == logical.HeaderOperation {
+		return &acmeReply{Status: http.StatusOK}, nil
+	}
+	return &acmeReply{Status: http.StatusNoContent}, nil
+}
+
+func (b *keyfactorBackend) pathACMENewAccount(ctx context.Context, req *logical.Request, data *framework.FieldData, config *keyfactorConfig) (*acmeReply, error) {
+	verified, err := b.acmeVerify(ctx, req, data, config, true)
+	if err != nil {
+		return nil, err
+	}
+	var payload struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := acmeDecodePayload(verified, &payload); err != nil {
+		return nil, err
+	}
+
+	location := config.acmeURL("acme/account/" + verified.AccountID)
+	account, err := getACMEAccount(ctx, req.Storage, verified.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if account != nil {
+		return &acmeReply{Status: http.StatusOK, Body: acmeAccountBody(account), Location: location}, nil
+	}
+	if payload.OnlyReturnExisting {
+		return nil, acmeProblem(http.StatusBadRequest, "accountDoesNotExist", "no account exists for the key")
+	}
+
+	jwk, err := verified.JWK.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	account = &acmeAccount{
+		Status:    acmeStatusValid,
+		Contact:   payload.Contact,
+		JWK:       jwk,
+		CreatedAt: time.Now(),
+	}
+	if err := putACMEObject(ctx, req.Storage, acmeAccountPrefix+verified.AccountID, account); err != nil {
+		return nil, err
+	}
+	b.Logger().Info("registered ACME account", "account_id", verified.AccountID)
+	return &acmeReply{Status: http.StatusCreated, Body: acmeAccountBody(account), Location: location}, nil
+}
+
+func (b *keyfactorBackend) pathACMEAccount(ctx context.Context, req *logical.Request, data *framework.FieldData, config *keyfactorConfig) (*acmeReply, error) {
+	verified, err := b.acmeVerify(ctx, req, data, config, false)
+	if err != nil {
+		return nil, err
+	}
+	if verified.AccountID != data.Get("account_id").(string) {
+		return nil, acmeProblem(http.StatusUnauthorized, "unauthorized", "the request is not signed by the key of the account")
+	}
+
+	account := verified.Account
+	if len(verified.Payload) != 0 {
+		var payload struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if err := acmeDecodePayload(verified, &payload); err != nil {
+			return nil, err
+		}
+		switch payload.Status {
+		case "":
+		case "deactivated":
+			account.Status = payload.Status
+		default:
+			return nil, acmeProblem(http.StatusBadRequest, "malformed", "the account status can only be changed to deactivated")
+		}
+		if payload.Contact != nil {
+			account.Contact = payload.Contact
+		}
+		if err := putACMEObject(ctx, req.Storage, acmeAccountPrefix+verified.AccountID, account); err != nil {
+			return nil, err
+		}
+	}
+	return &acmeReply{Status: http.StatusOK, Body: acmeAccountBody(account)}, nil
+}
+
+func (b *keyfactorBackend) pathACMENewOrder(ctx context.Context, req *logical.Request, data *framework.FieldData, config *keyfactorConfig) (*acmeReply, error) {
+	verified, err := b.acmeVerify(ctx, req, data, config, false)
+	if err != nil {
+		return nil, err
+	}
+	var payload struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+		NotBefore   string           `json:"notBefore"`
+		NotAfter    string           `json:"notAfter"`
+	}
+	if err := acmeDecodePayload(verified, &payload); err != nil {
+		return nil, err
+	}
+	if payload.NotBefore != "" || payload.NotAfter != "" {
+		return nil, acmeProblem(http.StatusBadRequest, "malformed", "notBefore and notAfter are not supported; the validity is set by the Keyfactor template")
+	}
+	if len(payload.Identifiers) == 0 {
+		return nil, acmeProblem(http.StatusBadRequest, "malformed", "the order must contain at least one identifier")
+	}
+
+	role, err := b.getRole(ctx, req.Storage, config.ACMERole)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, fmt.Errorf("acme_role %q does not exist", config.ACMERole)
+	}
+
+	now := time.Now()
+	order := &acmeOrder{
+		AccountID: verified.AccountID,
+		Status:    acmeStatusPending,
+		Expires:   now.Add(acmeOrderLifetime),
+	}
+	var authorizations []*acmeAuthorization
+	for _, identifier := range payload.Identifiers {
+		identifier.Value = strings.ToLower(identifier.Value)
+		if err := checkACMEIdentifier(role, identifier); err != nil {
+			return nil, err
+		}
+		if slices.Contains(order.Identifiers, identifier) {
+			continue
+		}
+		token, err := acmeRandom(32)
+		if err != nil {
+			return nil, err
+		}
+		order.Identifiers = append(order.Identifiers, identifier)
+		authorizations = append(authorizations, &acmeAuthorization{
+			AccountID:       verified.AccountID,
+			Identifier:      identifier,
+			Status:          acmeStatusPending,
+			Expires:         order.Expires,
+			Token:           token,
+			ChallengeStatus: acmeStatusPending,
+		})
+	}
+
+	for _, authz := range authorizations {
+		authzId, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, err
+		}
+		if err := putACMEObject(ctx, req.Storage, acmeAuthorizationPrefix+authzId, authz); err != nil {
+			return nil, err
+		}
+		order.AuthorizationIDs = append(order.AuthorizationIDs, authzId)
+	}
+	orderId, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	if err := putACMEObject(ctx, req.Storage, acmeOrderPrefix+orderId, order); err != nil {
+		return nil, err
+	}
+	return &acmeReply{Status: http.StatusCreated, Body: acmeOrderBody(config, orderId, order), Location: config.acmeURL("acme/order/" + orderId)}, nil
+}
+
+// checkACMEIdentifier returns a rejectedIdentifier problem if the identifier
+// cannot be issued with the ACME role.  Only DNS names are supported, and
+// wildcards are rejected as they cannot be validated with http-01.
+func checkACMEIdentifier(role *roleEntry, identifier acmeIdentifier) error {
+	if identifier.Type != acmeIdentifierDNS {
+		return acmeProblem(http.StatusBadRequest, "unsupportedIdentifier", "identifier type %q is not supported", identifier.Type)
+	}
+	if identifier.Value == "" || strings.Contains(identifier.Value, "*") {
+		return acmeProblem(http.StatusBadRequest, "rejectedIdentifier", "identifier %q cannot be validated with http-01", identifier.Value)
+	}
+	if !role.coversDomain(identifier.Value) {
+		return acmeProblem(http.StatusBadRequest, "rejectedIdentifier", "identifier %q is not allowed by the ACME role", identifier.Value)
+	}
+	if role.DNSSANsValidationRegex != "" {
+		dnsSANsRegex, err := compileDNSSANsRegex(role.DNSSANsValidationRegex)
+		if err != nil {
+			return fmt.Errorf("invalid dns_sans_validation_regex on role: %w", err)
+		}
+		if !dnsSANsRegex.MatchString(identifier.Value) {
+			return acmeProblem(http.StatusBadRequest, "rejectedIdentifier", "identifier %q does not match the pattern required by the ACME role", identifier.Value)
+		}
+	}
+	return nil
+}
+
+// refreshACMEOrder updates the status of a pending order from its
+// authorizations: it becomes ready when all are valid, and invalid when one
+// is invalid or the order has expired.
+func refreshACMEOrder(ctx context.Context, s logical.Storage, orderId string, order *acmeOrder) error {
+	if order.Status != acmeStatusPending {
+		return nil
+	}
+	status := acmeStatusReady
+	if time.Now().After(order.Expires) {
+		status = acmeStatusInvalid
+	}
+	for _, authzId := range order.AuthorizationIDs {
+		if status == acmeStatusInvalid {
+			break
+		}
+		authz, err := getACMEAuthorization(ctx, s, order.AccountID, authzId)
+		if err != nil {
+			return err
+		}
+		switch authz.Status {
+		case acmeStatusInvalid:
+			status = acmeStatusInvalid
+		case acmeStatusPending:
+			status = acmeStatusPending
+		}
+	}
+	if status == order.Status {
+		return nil
+	}
+	order.Status = status
+	return putACMEObject(ctx, s, acmeOrderPrefix+orderId, order)
+}
+
+func (b *keyfactorBackend) pathACMEOrder(ctx context.Context, req *logical.Request, data *framework.FieldData, config *keyfactorConfig) (*acmeReply, error) {
+	verified, err := b.acmeVerify(ctx, req, data, config, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := acmePostAsGet(verified); err != nil {
+		return nil, err
+	}
+	orderId := data.Get("order_id").(string)
+	order, err := getACMEOrder(ctx, req.Storage, verified.AccountID, orderId)
+	if err != nil {
+		return nil, err
+	}
+	if err := refreshACMEOrder(ctx, req.Storage, orderId, order); err != nil {
+		return nil, err
+	}
+	return &acmeReply{Status: http.StatusOK, Body: acmeOrderBody(config, orderId, order)}, nil
+}
+
+func (b *keyfactorBackend) pathACMEFinalize(ctx context.Context, req *logical.Request, data *framework.FieldData, config *keyfactorConfig) (*acmeReply, error) {
+	verified, err := b.acmeVerify(ctx, req, data, config, false)
+	if err != nil {
+		return nil, err
+	}
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if err := acmeDecodePayload(verified, &payload); err != nil {
+		return nil, err
+	}
+	der, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		return nil, acmeProblem(http.StatusBadRequest, "badCSR", "the csr is not base64url encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, acmeProblem(http.StatusBadRequest, "badCSR", "unable to parse the csr: %s", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, acmeProblem(http.StatusBadRequest, "badCSR", "invalid csr signature: %s", err)
+	}
+
+	role, err := b.getRole(ctx, req.Storage, config.ACMERole)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, fmt.Errorf("acme_role %q does not exist", config.ACMERole)
+	}
+	for _, check := range []error{
+		checkMinKeyStrength(role, csr.PublicKey),
+		checkSignatureAlgorithm(role, csr.SignatureAlgorithm),
+		checkKeyUsagePurpose(role, csr),
+	} {
+		if check != nil {
+			return nil, acmeProblem(http.StatusBadRequest, "badCSR", "%s", check)
+		}
+	}
+
+	orderId := data.Get("order_id").(string)
+	order, err := b.claimACMEOrder(ctx, req.Storage, verified.AccountID, orderId, csr)
+	if err != nil {
+		return nil, err
+	}
+
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	certs, serial, _, err := b.submitCSR(ctx, req, config.ACMERole, csrPEM, "", "", "{}", nil, "", false)
+	if err != nil {
+		b.Logger().Error("ACME order could not be issued", "order_id", orderId, "error", err)
+		order.Status = acmeStatusInvalid
+		order.Error = acmeProblem(http.StatusInternalServerError, "serverInternal", "the certificate could not be issued")
+		if err := putACMEObject(ctx, req.Storage, acmeOrderPrefix+orderId, order); err != nil {
+			return nil, err
+		}
+		return nil, order.Error
+	}
+
+	order.Status = acmeStatusValid
+	order.Serial = serial
+	order.Certificate = encodeCertsPEM(parseCertsPEM(certs))
+	if err := putACMEObject(ctx, req.Storage, acmeOrderPrefix+orderId, order); err != nil {
+		return nil, err
+	}
+	b.Logger().Info("issued certificate for ACME order", "order_id", orderId, "account_id", verified.AccountID, "serial", serial)
+	return &acmeReply{Status: http.StatusOK, Body: acmeOrderBody(config, orderId, order), Location: config.acmeURL("acme/order/" + orderId)}, nil
+}
+
+// claimACMEOrder checks that the order is ready and that the CSR requests
+// exactly its identifiers, and marks it as processing so that it is only
+// issued once.
+func (b *keyfactorBackend) claimACMEOrder(ctx context.Context, s logical.Storage, accountId string, orderId string, csr *x509.CertificateRequest) (*acmeOrder, error) {
+	b.acmeLock.Lock()
+	defer b.acmeLock.Unlock()
+
+	order, err := getACMEOrder(ctx, s, accountId, orderId)
+	if err != nil {
+		return nil, err
+	}
+	if err := refreshACMEOrder(ctx, s, orderId, order); err != nil {
+		return nil, err
+	}
+	if order.Status != acmeStatusReady {
+		return nil, acmeProblem(http.StatusForbidden, "orderNotReady", "the order is %s", order.Status)
+	}
+
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return nil, acmeProblem(http.StatusBadRequest, "badCSR", "the csr may only contain DNS names")
+	}
+	requested := make(map[string]bool)
+	for _, name := range csr.DNSNames {
+		requested[strings.ToLower(name)] = true
+	}
+	if cn := strings.ToLower(csr.Subject.CommonName); cn != "" {
+		requested[cn] = true
+	}
+	if len(requested) != len(order.Identifiers) {
+		return nil, acmeProblem(http.StatusBadRequest, "badCSR", "the csr must request exactly the identifiers of the order")
+	}
+	for _, identifier := range order.Identifiers {
+		if !requested[identifier.Value] {
+			return nil, acmeProblem(http.StatusBadRequest, "badCSR", "the csr does not request the identifier %q of the order", identifier.Value)
+		}
+	}
+
+	order.Status = acmeStatusProcessing
+	if err := putACMEObject(ctx, s, acmeOrderPrefix+orderId, order); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func (b *keyfactorBackend) pathACMECertificate(ctx context.Context, req *logical.Request, data *framework.FieldData, config *keyfactorConfig) (*acmeReply, error) {
+	verified, err := b.acmeVerify(ctx, req, data, config, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := acmePostAsGet(verified); err != nil {
+		return nil, err
+	}
+	order, err := getACMEOrder(ctx, req.Storage, verified.AccountID, data.Get("order_id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != acmeStatusValid {
+		return nil, acmeProblem(http.StatusNotFound, "malformed", "the order has no certificate")
+	}
+	return &acmeReply{Status: http.StatusOK, Raw: []byte(order.Certificate), ContentType: "application/pem-certificate-chain"}, nil
+}
+
+func (b *keyfactorBackend) pathACMEAuthorization(ctx context.Context, req *logical.Request, data *framework.FieldData, config *keyfactorConfig) (*acmeReply, error) {
+	verified, err := b.acmeVerify(ctx, req, data, config, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := acmePostAsGet(verified); err != nil {
+		return nil, err
+	}
+	authzId := data.Get("authz_id").(string)
+	authz, err := getACMEAuthorization(ctx, req.Storage, verified.AccountID, authzId)
+	if err != nil {
+		return nil, err
+	}
+	if authz.Status == acmeStatusPending && time.Now().After(authz.Expires) {
+		authz.Status = acmeStatusInvalid
+	}
+	return &acmeReply{Status: http.StatusOK, Body: acmeAuthorizationBody(config, authzId, authz)}, nil
+}
+
+func (b *keyfactorBackend) pathACMEChallenge(ctx context.Context, req *logical.Request, data *framework.FieldData, config *keyfactorConfig) (*acmeReply, error) {
+	verified, err := b.acmeVerify(ctx, req, data, config, false)
+	if err != nil {
+		return nil, err
+	}
+	authzId := data.Get("authz_id").(string)
+	authz, err := getACMEAuthorization(ctx, req.Storage, verified.AccountID, authzId)
+	if err != nil {
+		return nil, err
+	}
+
+	// an empty payload polls the challenge, an empty object responds to it
+	if len(verified.Payload) != 0 && authz.ChallengeStatus == acmeStatusPending {
+		if time.Now().After(authz.Expires) {
+			return nil, acmeProblem(http.StatusForbidden, "unauthorized", "the authorization has expired")
+		}
+		// the account ID is the thumbprint of the account key
+		if err := validateHTTP01(ctx, authz.Identifier.Value, authz.Token, verified.AccountID); err != nil {
+			b.Logger().Info("ACME http-01 challenge failed", "identifier", authz.Identifier.Value, "error", err)
+			authz.ChallengeStatus = acmeStatusInvalid
+			authz.Status = acmeStatusInvalid
+			authz.Error = acmeProblem(http.StatusForbidden, "incorrectResponse", "%s", err)
+		} else {
+			authz.ChallengeStatus = acmeStatusValid
+			authz.Status = acmeStatusValid
+			authz.Validated = time.Now()
+		}
+		if err := putACMEObject(ctx, req.Storage, acmeAuthorizationPrefix+authzId, authz); err != nil {
+			return nil, err
+		}
+	}
+	return &acmeReply{
+		Status: http.StatusOK,
+		Body:   acmeChallengeBody(config, authzId, authz),
+		Up:     config.acmeURL("acme/authorization/" + authzId),
+	}, nil
+}
+
+// acmeAccountBody returns the ACME representation of an account.
+func acmeAccountBody(account *acmeAccount) map[string]interface{} {
+	body := map[string]interface{}{
+		"status": account.Status,
+	}
+	if len(account.Contact) > 0 {
+		body["contact"] = account.Contact
+	}
+	return body
+}
+
+// acmeOrderBody returns the ACME representation of an order.
+func acmeOrderBody(config *keyfactorConfig, orderId string, order *acmeOrder) map[string]interface{} {
+	authorizations := make([]string, len(order.AuthorizationIDs))
+	for i, authzId := range order.AuthorizationIDs {
+		authorizations[i] = config.acmeURL("acme/authorization/" + authzId)
+	}
+	body := map[string]interface{}{
+		"status":         order.Status,
+		"expires":        order.Expires.UTC().Format(time.RFC3339),
+		"identifiers":    order.Identifiers,
+		"authorizations": authorizations,
+		"finalize":       config.acmeURL("acme/order/" + orderId + "/finalize"),
+	}
+	if order.Status == acmeStatusValid {
+		body["certificate"] = config.acmeURL("acme/order/" + orderId + "/cert")
+	}
+	if order.Error != nil {
+		body["error"] = order.Error
+	}
+	return body
+}
+
+// acmeAuthorizationBody returns the ACME representation of an authorization.
+func acmeAuthorizationBody(config *keyfactorConfig, authzId string, authz *acmeAuthorization) map[string]interface{} {
+	return map[string]interface{}{
+		"status":     authz.Status,
+		"expires":    authz.Expires.UTC().Format(time.RFC3339),
+		"identifier": authz.Identifier,
+		"challenges": []interface{}{acmeChallengeBody(config, authzId, authz)},
+	}
+}
+
+// acmeChallengeBody returns the ACME representation of the http-01
+// challenge of an authorization.
+func acmeChallengeBody(config *keyfactorConfig, authzId string, authz *acmeAuthorization) map[string]interface{} {
+	body := map[string]interface{}{
+		"type":   acmeChallengeHTTP01,
+		"url":    config.acmeURL("acme/challenge/" + authzId + "/http-01"),
+		"status": authz.ChallengeStatus,
+		"token":  authz.Token,
+	}
+	if !authz.Validated.IsZero() {
+		body["validated"] = authz.Validated.UTC().Format(time.RFC3339)
+	}
+	if authz.Error != nil {
+		body["error"] = authz.Error
+	}
+	return body
+}
+
+const pathACMEHelpSyn = `
+Issue certificates with the ACME protocol.
+`
+
+const pathACMEHelpDesc = `
+These paths implement a subset of the ACME protocol (RFC 8555) so that ACME clients such as
+certbot, acme.sh and Caddy can obtain certificates from Keyfactor through this mount. The
+directory is at acme/directory. Accounts are registered without external account binding,
+orders may only contain DNS identifiers allowed by the configured acme_role, and identifiers
+are validated with the http-01 challenge. Wildcard names, pre-authorization, key change and
+revocation through ACME are not supported.
+
+ACME is disabled until the "acme" feature is enabled with config/features, and requires
+acme_base_url, the externally reachable URL of this mount such as
+https://vault.example.com/v1/keyfactor, and acme_role in the configuration. Finalized orders
+are enrolled with the default CA and template like a sign request for acme_role. The paths are
+unauthenticated, and the mount must be tuned with
+allowed_response_headers="Replay-Nonce,Location,Link" for clients to receive the ACME headers.
+`
@@ -11,6 +11,8 @@ package kfbackend
 
 import (
 	"context"
+	"encoding/hex"
+	"fmt"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -38,6 +40,26 @@ func pathCA(b *keyfactorBackend) []*framework.Path {
 			HelpSynopsis:    pathFetchChainHelp,
 			HelpDescription: pathFetchChainHelpDesc,
 		},
+		{ // fetch cached ca chain bundle
+			Pattern: `ca/chain(/der)?`,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathFetchCaChainBundle,
+			},
+
+			HelpSynopsis:    pathFetchCAChainBundleHelp,
+			HelpDescription: pathFetchCAChainBundleHelpDesc,
+		},
+		{ // fetch the subject key identifier of the ca
+			Pattern: `ca/ski`,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathFetchCaSKI,
+			},
+
+			HelpSynopsis:    pathFetchCASKIHelp,
+			HelpDescription: pathFetchCASKIHelpDesc,
+		},
 	}
 }
 
@@ -70,6 +92,60 @@ func (b *keyfactorBackend) pathFetchCa(ctx context.Context, req *logical.Request
 	return fetchCaChainInfo(ctx, req, b)
 }
 
+// pathFetchCaChainBundle returns the intermediate and root chain of the
+// configured CA, from the storage cache when it has not expired.
+func (b *keyfactorBackend) pathFetchCaChainBundle(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	chain, err := b.getCAChain(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return logical.ErrorResponse("no CA chain is available; check that a CA is configured"), nil
+	}
+
+	if req.Path == "ca/chain/der" {
+		var der []byte
+		for _, cert := range chain {
+			der = append(der, cert.Raw...)
+		}
+		return &logical.Response{
+			Data: map[string]interface{}{
+				logical.HTTPContentType: "application/pkix-cert",
+				logical.HTTPRawBody:     der,
+				logical.HTTPStatusCode:  200,
+			},
+		}, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"ca_chain": encodeCertsPEM(chain),
+		},
+	}, nil
+}
+
+// pathFetchCaSKI returns the Subject Key Identifier of the configured CA.
+func (b *keyfactorBackend) pathFetchCaSKI(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	chain, err := b.getCAChain(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return logical.ErrorResponse("no CA certificate is available; check that a CA is configured"), nil
+	}
+	ca := chain[0]
+	if len(ca.SubjectKeyId) == 0 {
+		return logical.ErrorResponse(fmt.Sprintf("the CA certificate %s has no subject key identifier", ca.Subject)), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"subject_key_id": hex.EncodeToString(ca.SubjectKeyId),
+			"subject":        ca.Subject.String(),
+		},
+	}, nil
+}
+
 const pathFetchCAHelp = `
 Fetch a Certificate Authority.
 `
@@ -86,3 +162,23 @@ const pathFetchChainHelpDesc = `
 This allows the Certificate Authority chain to be fetched.
 The "ca_chain" command fetches the certificate authority trust chain in PEM encoding.
 `
+
+const pathFetchCAChainBundleHelp = `
+Fetch the cached intermediate and root chain of the configured CA.
+`
+
+const pathFetchCAChainBundleHelpDesc = `
+This returns the full intermediate and root chain of the configured CA as a PEM bundle in the "ca_chain" field.
+The chain is fetched from Keyfactor and cached for "ca_chain_cache_ttl". Add "/der" to get the
+DER-encoded certificates of the chain, concatenated, with a content type of application/pkix-cert.
+`
+
+const pathFetchCASKIHelp = `
+Fetch the Subject Key Identifier of the configured CA.
+`
+
+const pathFetchCASKIHelpDesc = `
+This returns the Subject Key Identifier of the configured CA certificate as a hex string in the
+"subject_key_id" field, along with the CA's subject. The CA certificate is fetched from Keyfactor
+and cached with the CA chain for "ca_chain_cache_ttl".
+`
@@ -0,0 +1,164 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathCache(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `cache/status`,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathCacheStatusRead,
+			},
+
+			HelpSynopsis:    pathCacheStatusHelpSyn,
+			HelpDescription: pathCacheStatusHelpDesc,
+		},
+		{
+			Pattern: `cache/flush`,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathCacheFlushWrite,
+			},
+
+			HelpSynopsis:    pathCacheFlushHelpSyn,
+			HelpDescription: pathCacheFlushHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathCacheStatusRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	now := time.Now()
+
+	var chainCache caChainCache
+	chainAge, err := cacheAge(ctx, req.Storage, caChainCacheKey, &chainCache, func() time.Time { return chainCache.FetchedAt }, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var listCache caListCache
+	caListAge, err := cacheAge(ctx, req.Storage, caListCacheKey, &listCache, func() time.Time { return listCache.FetchedAt }, now)
+	if err != nil {
+		return nil, err
+	}
+
+	templateKeys, err := req.Storage.List(ctx, templateCachePrefix)
+	if err != nil {
+		return nil, err
+	}
+	templateAges := map[string]interface{}{}
+	for _, ca := range templateKeys {
+		var cache templateCache
+		age, err := cacheAge(ctx, req.Storage, templateCachePrefix+ca, &cache, func() time.Time { return cache.FetchedAt }, now)
+		if err != nil {
+			return nil, err
+		}
+		templateAges[ca] = age
+	}
+
+	b.configLock.RLock()
+	configCached := b.cachedConfig != nil
+	clients := len(b.instanceClients)
+	if b.client != nil {
+		clients++
+	}
+	b.configLock.RUnlock()
+
+	state, failures, openedAt := b.breaker.status()
+	breaker := map[string]interface{}{
+		"state":    state.String(),
+		"failures": failures,
+	}
+	if state != circuitClosed {
+		breaker["opened_at"] = openedAt.UTC().Format(time.RFC3339)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"ca_chain_age_seconds":      chainAge,
+			"ca_list_age_seconds":       caListAge,
+			"template_list_age_seconds": templateAges,
+			"config_cached":             configCached,
+			"cached_clients":            clients,
+			"circuit_breaker":           breaker,
+		},
+	}, nil
+}
+
+// cacheAge decodes the cache entry stored under key into out and returns the
+// age in seconds of the time returned by fetchedAt, or -1 if there is no
+// valid entry.
+func cacheAge(ctx context.Context, s logical.Storage, key string, out interface{}, fetchedAt func() time.Time, now time.Time) (int64, error) {
+	entry, err := s.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if entry == nil {
+		return -1, nil
+	}
+	if err := entry.DecodeJSON(out); err != nil {
+		return -1, nil
+	}
+	return int64(now.Sub(fetchedAt()).Seconds()), nil
+}
+
+func (b *keyfactorBackend) pathCacheFlushWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	keys := []string{caChainCacheKey, caListCacheKey}
+	templateKeys, err := req.Storage.List(ctx, templateCachePrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, ca := range templateKeys {
+		keys = append(keys, templateCachePrefix+ca)
+	}
+	for _, key := range keys {
+		if err := req.Storage.Delete(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+
+	b.configLock.Lock()
+	b.cachedConfig = nil
+	b.client = nil
+	b.instanceClients = nil
+	b.configLock.Unlock()
+
+	b.Logger().Info("flushed caches", "entity_id", req.EntityID)
+
+	return nil, nil
+}
+
+const pathCacheStatusHelpSyn = `
+Return the state of the backend's caches.
+`
+
+const pathCacheStatusHelpDesc = `
+This returns the age in seconds of the cached CA chain, CA list and template lists (-1 if nothing
+is cached), whether the configuration and Keyfactor clients are cached in memory, and the state of
+the circuit breaker guarding requests to Keyfactor. Use it to troubleshoot stale configuration.
+`
+
+const pathCacheFlushHelpSyn = `
+Clear the backend's caches.
+`
+
+const pathCacheFlushHelpDesc = `
+This deletes the cached CA chain, CA list and template lists and drops the cached configuration and
+Keyfactor clients, so they are fetched again on the next request. The circuit breaker is not reset.
+This path requires a root token or a policy granting the sudo capability on it.
+`
@@ -0,0 +1,336 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// templateCachePrefix is the storage prefix of the cached templates, keyed by CA.
+const templateCachePrefix = "template_cache/"
+
+const defaultTemplateCacheTTL = time.Hour
+
+// keyfactorCSRContext is the subset of the Keyfactor CSR enrollment context
+// that lists the templates available on each CA.
+type keyfactorCSRContext struct {
+	Templates []struct {
+		Id   int    `json:"Id"`
+		Name string `json:"Name"`
+		CAs  []struct {
+			Id          int    `json:"Id"`
+			Name        string `json:"Name"`
+			Host        string `json:"Host"`
+			LogicalName string `json:"LogicalName"`
+		} `json:"CAs"`
+	} `json:"Templates"`
+}
+
+// keyfactorTemplate is the subset of a Keyfactor template describing the
+// policy it enforces on enrollments.
+type keyfactorTemplate struct {
+	Id                  int    `json:"Id"`
+	CommonName          string `json:"CommonName"`
+	TemplateName        string `json:"TemplateName"`
+	KeySize             string `json:"KeySize"`
+	KeyType             string `json:"KeyType"`
+	KeyUsage            int    `json:"KeyUsage"`
+	ValidityPeriod      string `json:"ValidityPeriod"`
+	ValidityPeriodUnits int    `json:"ValidityPeriodUnits"`
+	ExtendedKeyUsages   []struct {
+		Oid         string `json:"Oid"`
+		DisplayName string `json:"DisplayName"`
+	} `json:"ExtendedKeyUsages"`
+	TemplateRegexes []struct {
+		SubjectPart string `json:"SubjectPart"`
+		Regex       string `json:"Regex"`
+	} `json:"TemplateRegexes"`
+	TemplatePolicy struct {
+		RSAValidKeySizes []int    `json:"RSAValidKeySizes"`
+		ECCValidCurves   []string `json:"ECCValidCurves"`
+		AllowWildcards   bool     `json:"AllowWildcards"`
+	} `json:"TemplatePolicy"`
+}
+
+// templateCache is the templates of a CA cached in storage.
+type templateCache struct {
+	Templates map[string]*keyfactorTemplate `json:"templates"`
+	FetchedAt time.Time                     `json:"fetched_at"`
+}
+
+func pathCAs(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{ // list templates available on a CA
+			Pattern: `cas/` + framework.GenericNameRegex("ca_name") + `/templates/?$`,
+			Fields: map[string]*framework.FieldSchema{
+				"ca_name": {
+					Type:        framework.TypeString,
+					Description: "The logical name of the CA.",
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.pathListCATemplates,
+			},
+
+			HelpSynopsis:    pathListCATemplatesHelpSyn,
+			HelpDescription: pathListCATemplatesHelpDesc,
+		},
+		{ // list the roles that may enroll with a CA
+			Pattern: `cas/` + framework.GenericNameRegex("ca_name") + `/roles/?$`,
+			Fields: map[string]*framework.FieldSchema{
+				"ca_name": {
+					Type:        framework.TypeString,
+					Description: "The logical name of the CA.",
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.pathListCARoles,
+			},
+
+			HelpSynopsis:    pathListCARolesHelpSyn,
+			HelpDescription: pathListCARolesHelpDesc,
+		},
+		{ // read a template's policy
+			Pattern: `cas/` + framework.GenericNameRegex("ca_name") + `/templates/` + framework.GenericNameRegex("template_name"),
+			Fields: map[string]*framework.FieldSchema{
+				"ca_name": {
+					Type:        framework.TypeString,
+					Description: "The logical name of the CA.",
+				},
+				"template_name": {
+					Type:        framework.TypeString,
+					Description: "The short name of the template.",
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathReadCATemplate,
+			},
+
+			HelpSynopsis:    pathReadCATemplateHelpSyn,
+			HelpDescription: pathReadCATemplateHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathListCATemplates(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	templates, err := b.getCATemplates(ctx, req, data.Get("ca_name").(string))
+	if err != nil {
+		return keyfactorErrorResponse("error getting templates from Keyfactor", err)
+	}
+
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return logical.ListResponse(names), nil
+}
+
+func (b *keyfactorBackend) pathListCARoles(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	caName := data.Get("ca_name").(string)
+
+	names, err := req.Storage.List(ctx, "role/")
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed []string
+	for _, name := range names {
+		role, err := b.getRole(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && role.allowsCA(caName) {
+			allowed = append(allowed, name)
+		}
+	}
+	sort.Strings(allowed)
+
+	return logical.ListResponse(allowed), nil
+}
+
+func (b *keyfactorBackend) pathReadCATemplate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	caName := data.Get("ca_name").(string)
+	templateName := data.Get("template_name").(string)
+
+	templates, err := b.getCATemplates(ctx, req, caName)
+	if err != nil {
+		return keyfactorErrorResponse("error getting templates from Keyfactor", err)
+	}
+	template, ok := templates[templateName]
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("template %q is not available on CA %q", templateName, caName)), nil
+	}
+
+	extendedKeyUsages := []string{}
+	for _, eku := range template.ExtendedKeyUsages {
+		extendedKeyUsages = append(extendedKeyUsages, eku.Oid)
+	}
+	subjectRegexes := map[string]string{}
+	for _, regex := range template.TemplateRegexes {
+		subjectRegexes[regex.SubjectPart] = regex.Regex
+	}
+	validityPeriod := ""
+	if template.ValidityPeriod != "" {
+		validityPeriod = strconv.Itoa(template.ValidityPeriodUnits) + " " + template.ValidityPeriod
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"template_name":       template.TemplateName,
+			"display_name":        template.CommonName,
+			"key_type":            template.KeyType,
+			"key_size":            template.KeySize,
+			"rsa_key_sizes":       template.TemplatePolicy.RSAValidKeySizes,
+			"ecc_curves":          template.TemplatePolicy.ECCValidCurves,
+			"allow_wildcards":     template.TemplatePolicy.AllowWildcards,
+			"key_usage":           template.KeyUsage,
+			"extended_key_usages": extendedKeyUsages,
+			"subject_regexes":     subjectRegexes,
+			"validity_period":     validityPeriod,
+		},
+	}, nil
+}
+
+// getCATemplates returns the templates available on a CA, keyed by template
+// name.  The templates are cached in storage for the configured TTL.
+func (b *keyfactorBackend) getCATemplates(ctx context.Context, req *logical.Request, caName string) (map[string]*keyfactorTemplate, error) {
+	config, err := b.fetchConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("unable to load configuration")
+	}
+	ttl := config.TemplateCacheTTL
+	if ttl <= 0 {
+		ttl = defaultTemplateCacheTTL
+	}
+
+	cacheKey := templateCachePrefix + strings.ToLower(caName)
+	cacheEntry, err := req.Storage.Get(ctx, cacheKey)
+	if err != nil {
+		return nil, err
+	}
+	if cacheEntry != nil {
+		var cache templateCache
+		if err := cacheEntry.DecodeJSON(&cache); err == nil && time.Since(cache.FetchedAt) < ttl {
+			return cache.Templates, nil
+		}
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	var enrollmentContext keyfactorCSRContext
+	if err := b.getKeyfactorJSON(ctx, req.Storage, client, config, "/Enrollment/CSR/Context/My", &enrollmentContext); err != nil {
+		return nil, err
+	}
+
+	templates := map[string]*keyfactorTemplate{}
+	for _, t := range enrollmentContext.Templates {
+		onCA := false
+		for _, ca := range t.CAs {
+			if strings.EqualFold(caName, ca.LogicalName) || strings.EqualFold(caName, ca.Host+`\`+ca.LogicalName) || strings.EqualFold(caName, ca.Name) {
+				onCA = true
+				break
+			}
+		}
+		if !onCA {
+			continue
+		}
+
+		template := &keyfactorTemplate{}
+		if err := b.getKeyfactorJSON(ctx, req.Storage, client, config, "/Templates/"+strconv.Itoa(t.Id), template); err != nil {
+			return nil, err
+		}
+		templates[template.TemplateName] = template
+	}
+
+	cacheEntry, err = logical.StorageEntryJSON(cacheKey, &templateCache{
+		Templates: templates,
+		FetchedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, cacheEntry); err != nil {
+		b.Logger().Warn("unable to cache the CA templates", "ca", caName, "error", err)
+	}
+
+	return templates, nil
+}
+
+// getKeyfactorJSON sends a GET request for the given Keyfactor API path and
+// decodes the JSON response into out.
+func (b *keyfactorBackend) getKeyfactorJSON(ctx context.Context, s logical.Storage, client *keyfactorClient, config *keyfactorConfig, path string, out interface{}) error {
+	url := config.apiURL(path)
+	b.Logger().Debug("url: " + url)
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Add("x-keyfactor-requested-with", "APIClient")
+	httpReq.Header.Add("x-keyfactor-api-version", keyfactorAPIVersion)
+
+	_, body, err := b.sendKeyfactorRequest(ctx, s, client, httpReq)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("unable to parse response from %s: %w", path, err)
+	}
+	return nil
+}
+
+const pathListCATemplatesHelpSyn = `
+List the templates available on a CA.
+`
+
+const pathListCATemplatesHelpDesc = `
+This lists the Keyfactor templates that can be used to enroll certificates with the given CA.
+The results are cached for "template_cache_ttl".
+`
+
+const pathListCARolesHelpSyn = `
+List the roles that may enroll with a CA.
+`
+
+const pathListCARolesHelpDesc = `
+This lists the roles whose "allowed_cas" include the given CA, by logical name, along with the
+roles without "allowed_cas", which may enroll with any CA.
+`
+
+const pathReadCATemplateHelpSyn = `
+Read the policy of a template available on a CA.
+`
+
+const pathReadCATemplateHelpDesc = `
+This returns the policy Keyfactor enforces for a template: the allowed key types and sizes,
+the extended key usages, the regular expressions subject fields must match, and the validity period.
+The results are cached for "template_cache_ttl".
+`
@@ -0,0 +1,267 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/errutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Checks that can be used in compliance rules, configured with the
+// `compliance_rules` configuration field.
+const (
+	complianceCheckMinKeySize      = "min_key_size"
+	complianceCheckMustHaveSAN     = "must_have_san"
+	complianceCheckNoWildcard      = "no_wildcard"
+	complianceCheckMaxValidityDays = "max_validity_days"
+)
+
+// complianceRule is a check that stored certificates are audited against.
+// Value is a number for min_key_size and max_validity_days, and a bool that
+// defaults to true for must_have_san and no_wildcard.
+type complianceRule struct {
+	Name  string      `json:"name"`
+	Check string      `json:"check"`
+	Value interface{} `json:"value"`
+}
+
+// parseComplianceRules validates the raw `compliance_rules` field.  Entries
+// may be objects or JSON strings holding one.
+func parseComplianceRules(raw []interface{}) ([]complianceRule, error) {
+	rules := make([]complianceRule, 0, len(raw))
+	names := make(map[string]bool, len(raw))
+	for _, value := range raw {
+		var rule complianceRule
+		switch v := value.(type) {
+		case string:
+			if err := json.Unmarshal([]byte(v), &rule); err != nil {
+				return nil, fmt.Errorf("%q could not be parsed as a compliance rule object: %w", v, err)
+			}
+		default:
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(encoded, &rule); err != nil {
+				return nil, fmt.Errorf("could not parse compliance rule object: %w", err)
+			}
+		}
+		if rule.Name == "" {
+			return nil, fmt.Errorf("compliance rule with check %q must have a name", rule.Check)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate compliance rule name %q", rule.Name)
+		}
+		names[rule.Name] = true
+
+		switch rule.Check {
+		case complianceCheckMinKeySize, complianceCheckMaxValidityDays:
+			if n, ok := complianceRuleInt(rule.Value); !ok || n <= 0 {
+				return nil, fmt.Errorf("the value of compliance rule %q must be a positive integer", rule.Name)
+			}
+		case complianceCheckMustHaveSAN, complianceCheckNoWildcard:
+			if _, ok := complianceRuleBool(rule.Value); !ok {
+				return nil, fmt.Errorf("the value of compliance rule %q must be a boolean", rule.Name)
+			}
+		default:
+			return nil, fmt.Errorf("invalid check %q for compliance rule %q; must be one of %s, %s, %s or %s",
+				rule.Check, rule.Name, complianceCheckMinKeySize, complianceCheckMustHaveSAN, complianceCheckNoWildcard, complianceCheckMaxValidityDays)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// complianceRuleInt returns a rule value as an integer.  Values read back
+// from storage are float64.
+func complianceRuleInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case float64:
+		if v != float64(int(v)) {
+			return 0, false
+		}
+		return int(v), true
+	case int:
+		return v, true
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// complianceRuleBool returns a rule value as a bool, true when unset.
+func complianceRuleBool(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case nil:
+		return true, true
+	case bool:
+		return v, true
+	case string:
+		b, err := strconv.ParseBool(v)
+		return b, err == nil
+	default:
+		return false, false
+	}
+}
+
+// publicKeySize returns the size in bits of the certificate's public key.
+func publicKeySize(cert *x509.Certificate) int {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	default:
+		return 0
+	}
+}
+
+// checkCompliance evaluates a rule against a certificate, returning whether
+// it passed and a description of the result.
+func checkCompliance(rule complianceRule, cert *x509.Certificate) (bool, string) {
+	switch rule.Check {
+	case complianceCheckMinKeySize:
+		min, _ := complianceRuleInt(rule.Value)
+		size := publicKeySize(cert)
+		return size >= min, fmt.Sprintf("key size is %d bits; minimum is %d", size, min)
+	case complianceCheckMaxValidityDays:
+		max, _ := complianceRuleInt(rule.Value)
+		validity := cert.NotAfter.Sub(cert.NotBefore)
+		return validity <= time.Duration(max)*24*time.Hour, fmt.Sprintf("validity is %.1f days; maximum is %d", validity.Hours()/24, max)
+	case complianceCheckMustHaveSAN:
+		if required, _ := complianceRuleBool(rule.Value); !required {
+			return true, "not required"
+		}
+		count := len(cert.DNSNames) + len(cert.IPAddresses) + len(cert.EmailAddresses) + len(cert.URIs)
+		return count > 0, fmt.Sprintf("certificate has %d subject alternative names", count)
+	case complianceCheckNoWildcard:
+		if required, _ := complianceRuleBool(rule.Value); !required {
+			return true, "not required"
+		}
+		for _, name := range append([]string{cert.Subject.CommonName}, cert.DNSNames...) {
+			if strings.Contains(name, "*") {
+				return false, fmt.Sprintf("%q is a wildcard name", name)
+			}
+		}
+		return true, "certificate has no wildcard names"
+	default:
+		return false, fmt.Sprintf("unknown check %q", rule.Check)
+	}
+}
+
+func pathCertCompliance(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `certs/(?P<serial>[0-9A-Fa-f-:]+)/compliance`,
+			Fields: map[string]*framework.FieldSchema{
+				"serial": {
+					Type: framework.TypeString,
+					Description: `Certificate serial number, in colon- or
+		hyphen-separated octal`,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathCertCompliance,
+			},
+
+			HelpSynopsis:    pathCertComplianceHelpSyn,
+			HelpDescription: pathCertComplianceHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathCertCompliance(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	serial := normalizeSerial(data.Get("serial").(string))
+	if len(serial) == 0 {
+		return logical.ErrorResponse("The serial number must be provided"), nil
+	}
+
+	config, err := b.fetchConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("could not load configuration"), nil
+	}
+
+	certEntry, err := fetchCertBySerial(ctx, req, "certs/", serial)
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), nil
+		default:
+			return nil, err
+		}
+	}
+	if certEntry == nil {
+		return logical.ErrorResponse(fmt.Sprintf("certificate with serial %s not found", serial)), nil
+	}
+	cert, err := parseCertificatePEM(certEntry.Value)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse stored certificate %s: %w", serial, err)
+	}
+
+	compliant := true
+	results := make([]map[string]interface{}, 0, len(config.ComplianceRules))
+	for _, rule := range config.ComplianceRules {
+		passed, detail := checkCompliance(rule, cert)
+		compliant = compliant && passed
+		results = append(results, map[string]interface{}{
+			"name":   rule.Name,
+			"check":  rule.Check,
+			"passed": passed,
+			"detail": detail,
+		})
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"serial_number": serial,
+			"compliant":     compliant,
+			"rules":         results,
+		},
+	}
+	if len(config.ComplianceRules) == 0 {
+		resp.AddWarning("no compliance_rules are configured")
+	}
+	return resp, nil
+}
+
+const pathCertComplianceHelpSyn = `
+Check a stored certificate against the configured compliance rules.
+`
+
+const pathCertComplianceHelpDesc = `
+This evaluates a stored certificate against each of the compliance_rules in the configuration
+and returns the result of every rule in "rules", with "compliant" true only when all of them
+pass. The checks are:
+
+  min_key_size       The public key is at least "value" bits.
+  max_validity_days  The validity period is at most "value" days.
+  must_have_san      The certificate has a subject alternative name.
+  no_wildcard        Neither the common name nor the DNS names contain a wildcard.
+`
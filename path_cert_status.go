@@ -0,0 +1,205 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/errutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Keyfactor certificate states relevant to the live status.
+const (
+	keyfactorCertStateActive  = 1
+	keyfactorCertStateRevoked = 2
+)
+
+// revocationReasonCertificateHold is the RFC 5280 CRLReason of a suspended certificate.
+const revocationReasonCertificateHold = 6
+
+// keyfactorCertStatus is the subset of a Keyfactor certificate describing its status.
+type keyfactorCertStatus struct {
+	Id                int        `json:"Id"`
+	CertState         int        `json:"CertState"`
+	NotAfter          time.Time  `json:"NotAfter"`
+	RevocationEffDate *time.Time `json:"RevocationEffDate"`
+	RevocationReason  *int       `json:"RevocationReason"`
+}
+
+func pathCertStatus(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `certs/(?P<serial>[0-9A-Fa-f-:]+)/live-status`,
+			Fields: map[string]*framework.FieldSchema{
+				"serial": {
+					Type: framework.TypeString,
+					Description: `Certificate serial number, in colon- or
+		hyphen-separated octal`,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathCertLiveStatus,
+			},
+
+			HelpSynopsis:    pathCertLiveStatusHelpSyn,
+			HelpDescription: pathCertLiveStatusHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathCertLiveStatus(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	serial := normalizeSerial(data.Get("serial").(string))
+	if len(serial) == 0 {
+		return logical.ErrorResponse("The serial number must be provided"), nil
+	}
+
+	certEntry, err := fetchCertBySerial(ctx, req, "certs/", serial)
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), nil
+		default:
+			return nil, err
+		}
+	}
+	if certEntry == nil {
+		return logical.ErrorResponse(fmt.Sprintf("certificate with serial %s not found", serial)), nil
+	}
+
+	live, status, err := b.liveCertStatus(ctx, req, serial)
+	if err != nil {
+		return keyfactorErrorResponse("error getting certificate status from Keyfactor", err)
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"serial_number":   serial,
+			"status":          status,
+			"keyfactor_state": live.CertState,
+			"synchronized":    false,
+		},
+	}
+
+	if status != "revoked" && status != "suspended" {
+		return resp, nil
+	}
+
+	// holds are recorded apart from permanent revocations
+	prefix := "revoked/"
+	if status == "suspended" {
+		prefix = "suspended/"
+	}
+	revokedEntry, err := req.Storage.Get(ctx, prefix+serial)
+	if err != nil {
+		return nil, err
+	}
+	if revokedEntry != nil {
+		return resp, nil
+	}
+
+	// Keyfactor knows of a revocation that local storage does not
+	revokedAt := time.Now()
+	if live.RevocationEffDate != nil {
+		revokedAt = *live.RevocationEffDate
+	}
+	reason := revocationReasonUnspecified
+	if live.RevocationReason != nil {
+		reason = *live.RevocationReason
+	}
+	revEntry, err := logical.StorageEntryJSON(prefix+serial, &revocationInfo{
+		CertificateBytes:  certEntry.Value,
+		RevocationTime:    revokedAt.Unix(),
+		RevocationTimeUTC: revokedAt.UTC(),
+		RevocationReason:  reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, revEntry); err != nil {
+		return nil, fmt.Errorf("error saving revocation found in Keyfactor: %w", err)
+	}
+	b.Logger().Info("synchronized revocation from Keyfactor", "serial", serial, "status", status)
+	resp.Data["synchronized"] = true
+
+	return resp, nil
+}
+
+// liveCertStatus looks up a stored certificate in Keyfactor by its stored
+// Keyfactor ID, and returns it with its status: active, revoked, suspended,
+// expired or unknown.
+func (b *keyfactorBackend) liveCertStatus(ctx context.Context, req *logical.Request, serial string) (*keyfactorCertStatus, string, error) {
+	kfIdEntry, err := req.Storage.Get(ctx, "kfId/"+serial)
+	if err != nil {
+		return nil, "", err
+	}
+	if kfIdEntry == nil {
+		return nil, "", errutil.UserError{Err: fmt.Sprintf("no Keyfactor certificate ID is stored for serial %s", serial)}
+	}
+	keyfactorId, err := decodeKeyfactorId(kfIdEntry)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to parse stored certificate ID for serial %s: %w", serial, err)
+	}
+
+	config, err := b.fetchConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, "", err
+	}
+	if config == nil {
+		return nil, "", errutil.UserError{Err: "could not load configuration"}
+	}
+	instance, err := getCertInstance(ctx, req.Storage, serial)
+	if err != nil {
+		return nil, "", err
+	}
+	instanceConfig, err := config.instanceConfig(instance)
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := b.getInstanceClient(ctx, req.Storage, instance)
+	if err != nil {
+		return nil, "", fmt.Errorf("error getting client: %w", err)
+	}
+
+	live := &keyfactorCertStatus{}
+	if err := b.getKeyfactorJSON(ctx, req.Storage, client, instanceConfig, "/Certificates/"+strconv.Itoa(keyfactorId), live); err != nil {
+		return nil, "", err
+	}
+
+	status := "active"
+	switch {
+	case live.CertState == keyfactorCertStateRevoked && live.RevocationReason != nil && *live.RevocationReason == revocationReasonCertificateHold:
+		status = "suspended"
+	case live.CertState == keyfactorCertStateRevoked:
+		status = "revoked"
+	case !live.NotAfter.IsZero() && time.Now().After(live.NotAfter):
+		status = "expired"
+	case live.CertState != keyfactorCertStateActive:
+		status = "unknown"
+	}
+	return live, status, nil
+}
+
+const pathCertLiveStatusHelpSyn = `
+Query Keyfactor for the current status of a certificate.
+`
+
+const pathCertLiveStatusHelpDesc = `
+This looks up the certificate in Keyfactor by its stored Keyfactor ID and returns its live status:
+active, revoked, suspended, expired or unknown. If Keyfactor reports a revocation that is not
+recorded locally, for example because the certificate was revoked in the Keyfactor UI, the
+revocation is written to local storage and "synchronized" is set to true. Certificates on hold
+are recorded under "suspended/" rather than "revoked/".
+`
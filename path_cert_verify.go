@@ -0,0 +1,162 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/errutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathCertVerify(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `certs/(?P<serial>[0-9A-Fa-f-:]+)/verify`,
+			Fields: map[string]*framework.FieldSchema{
+				"serial": {
+					Type: framework.TypeString,
+					Description: `Certificate serial number, in colon- or
+		hyphen-separated octal`,
+				},
+				"check_revocation": {
+					Type:        framework.TypeBool,
+					Description: "Also check that the certificate has not been revoked or suspended.",
+					Default:     false,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathCertVerify,
+			},
+
+			HelpSynopsis:    pathCertVerifyHelpSyn,
+			HelpDescription: pathCertVerifyHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathCertVerify(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	serial := normalizeSerial(data.Get("serial").(string))
+	if len(serial) == 0 {
+		return logical.ErrorResponse("The serial number must be provided"), nil
+	}
+
+	certEntry, err := fetchCertBySerial(ctx, req, "certs/", serial)
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), nil
+		default:
+			return nil, err
+		}
+	}
+	if certEntry == nil {
+		return logical.ErrorResponse(fmt.Sprintf("certificate with serial %s not found", serial)), nil
+	}
+	cert, err := parseCertificatePEM(certEntry.Value)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse stored certificate %s: %w", serial, err)
+	}
+
+	chain, err := b.getCAChain(ctx, req)
+	if err != nil {
+		return keyfactorErrorResponse("error getting the CA chain", err)
+	}
+	if len(chain) == 0 {
+		return logical.ErrorResponse("no CA chain is available; check the ca configuration"), nil
+	}
+	pool := x509.NewCertPool()
+	for _, ca := range chain {
+		pool.AddCert(ca)
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return verifyFailureResponse(serial, verifyFailureReason(err), err.Error()), nil
+	}
+
+	if data.Get("check_revocation").(bool) {
+		for _, prefix := range []string{"revoked/", "suspended/"} {
+			revEntry, err := req.Storage.Get(ctx, prefix+serial)
+			if err != nil {
+				return nil, err
+			}
+			if revEntry != nil {
+				return verifyFailureResponse(serial, "revoked", fmt.Sprintf("certificate %s is %s", serial, prefix[:len(prefix)-1])), nil
+			}
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"serial_number": serial,
+			"valid":         true,
+		},
+	}, nil
+}
+
+// verifyFailureReason classifies a chain validation error.
+func verifyFailureReason(err error) string {
+	var invalid x509.CertificateInvalidError
+	var unknownAuthority x509.UnknownAuthorityError
+	switch {
+	case errors.As(err, &invalid):
+		switch invalid.Reason {
+		case x509.Expired:
+			return "expired"
+		case x509.IncompatibleUsage:
+			return "incompatible_usage"
+		case x509.CANotAuthorizedForThisName, x509.CANotAuthorizedForExtKeyUsage:
+			return "ca_not_authorized"
+		case x509.TooManyIntermediates:
+			return "path_length_exceeded"
+		default:
+			return "invalid_certificate"
+		}
+	case errors.As(err, &unknownAuthority):
+		return "unknown_authority"
+	default:
+		return "invalid_chain"
+	}
+}
+
+func verifyFailureResponse(serial string, reason string, detail string) *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"serial_number": serial,
+			"valid":         false,
+			"reason":        reason,
+			"error":         detail,
+		},
+	}
+}
+
+const pathCertVerifyHelpSyn = `
+Verify a stored certificate against the chain of the configured CA.
+`
+
+const pathCertVerifyHelpDesc = `
+This verifies the signature chain and validity period of a stored certificate against the
+chain of the configured CA, as returned by ca/chain. "valid" is true when the certificate
+chains to the CA. Otherwise "valid" is false, "reason" is one of expired, incompatible_usage,
+ca_not_authorized, path_length_exceeded, invalid_certificate, unknown_authority,
+invalid_chain or revoked, and "error" describes the failure.
+
+With "check_revocation" set, certificates revoked or suspended in local storage are reported
+as invalid with the reason "revoked". Keyfactor is not asked about the revocation status.
+`
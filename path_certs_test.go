@@ -0,0 +1,96 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// benchmarkCertCount is the number of stored certificates listed by
+// BenchmarkCertListDetails.
+const benchmarkCertCount = 10000
+
+func BenchmarkCertListDetails(b *testing.B) {
+	ctx := context.Background()
+	backend, err := Factory(ctx, logical.TestBackendConfig())
+	if err != nil {
+		b.Fatalf("unable to create the backend: %v", err)
+	}
+	kb := backend.(*keyfactorBackend)
+
+	s := &logical.InmemStorage{}
+	serials := seedBenchmarkCerts(b, ctx, s, benchmarkCertCount)
+
+	for _, bc := range []struct {
+		name    string
+		workers int
+	}{
+		{"sequential", 1},
+		{"parallel", defaultListWorkers},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				details, err := kb.certListDetails(ctx, s, serials, bc.workers)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(details) != len(serials) {
+					b.Fatalf("expected %d details, got %d", len(serials), len(details))
+				}
+			}
+		})
+	}
+}
+
+// seedBenchmarkCerts stores count self-signed certificates under certs/ and
+// returns their serials.
+func seedBenchmarkCerts(b *testing.B, ctx context.Context, s logical.Storage, count int) []string {
+	b.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	serials := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 1)),
+			Subject:      pkix.Name{CommonName: fmt.Sprintf("host-%d.example.com", i)},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(24 * time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			b.Fatal(err)
+		}
+		serial := normalizeSerial(fmt.Sprintf("%x", template.SerialNumber))
+		err = s.Put(ctx, &logical.StorageEntry{
+			Key:   "certs/" + serial,
+			Value: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		serials = append(serials, serial)
+	}
+	return serials
+}
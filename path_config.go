@@ -11,6 +11,10 @@ package kfbackend
 
 import (
 	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -38,6 +42,61 @@ type keyfactorConfig struct {
 	CertTemplate    string   `json:"template"`
 	CertAuthority   string   `json:"ca"`
 	CommandCertPath string   `json:"command_cert_path"`
+
+	ProhibitedCommonNames []string `json:"prohibited_common_names"`
+	OCSPServerURL         string   `json:"ocsp_server_url"`
+
+	CircuitBreakerThreshold int               `json:"circuit_breaker_threshold"`
+	CircuitBreakerTimeout   time.Duration     `json:"circuit_breaker_timeout"`
+	ErrorMap                map[string]string `json:"keyfactor_error_map"`
+	IssueTimeout            time.Duration     `json:"issue_timeout"`
+	SignTimeout             time.Duration     `json:"sign_timeout"`
+	RevokeTimeout           time.Duration     `json:"revoke_timeout"`
+	CAChainCacheTTL         time.Duration     `json:"ca_chain_cache_ttl"`
+
+	// SchemaVersion is the version of this structure the configuration was
+	// written with; see currentConfigSchemaVersion.
+	SchemaVersion          int                      `json:"schema_version"`
+	ListWorkers            int                      `json:"list_workers"`
+	TemplateCacheTTL       time.Duration            `json:"template_cache_ttl"`
+	EnrollmentFormat       string                   `json:"enrollment_format"`
+	EntityMetadataFields   map[string]string        `json:"entity_metadata_fields"`
+	StoragePrefixOverride  string                   `json:"storage_prefix_override"`
+	AutoImportOnFetch      bool                     `json:"auto_import_on_fetch"`
+	MaxCertsStored         int                      `json:"max_certs_stored"`
+	MaxConcurrentIssuances int                      `json:"max_concurrent_issuances"`
+	MetadataSchema         string                   `json:"metadata_schema"`
+	TemplateTimeouts       map[string]time.Duration `json:"template_timeouts"`
+	KeyfactorBaseURL       string                   `json:"keyfactor_base_url"`
+	CRLPushURL             string                   `json:"crl_push_url"`
+	CRLPushInterval        time.Duration            `json:"crl_push_interval"`
+	CRLPushUsername        string                   `json:"crl_push_username"`
+	CRLPushPassword        string                   `json:"crl_push_password"`
+	OCSPSigningCertPEM     string                   `json:"ocsp_signing_cert_pem"`
+	OCSPSigningKeyPEM      string                   `json:"ocsp_signing_key_pem"`
+	SensitiveRequestFields []string                 `json:"sensitive_request_fields"`
+	MaxResponseBytes       int64                    `json:"keyfactor_max_response_bytes"`
+	ComplianceRules        []complianceRule         `json:"compliance_rules"`
+	DefaultComment         string                   `json:"default_comment"`
+	KeyfactorIDFormat      string                   `json:"keyfactor_id_format"`
+	MaxMetadataBytes       int                      `json:"max_metadata_bytes"`
+	MaxMetadataKeys        int                      `json:"max_metadata_keys"`
+	RequesterPrefix        string                   `json:"requester_prefix"`
+	ACMEBaseURL            string                   `json:"acme_base_url"`
+	ACMERole               string                   `json:"acme_role"`
+	IssuerName             string                   `json:"issuer_name"`
+	IssuerNames            map[string]string        `json:"issuer_names"`
+	EnrollmentMaxRetries   int                      `json:"enrollment_max_retries"`
+	RevokeMaxRetries       int                      `json:"revoke_max_retries"`
+	RevokeAPIPath          string                   `json:"revoke_api_path"`
+	EnrollAPIPath          string                   `json:"enroll_api_path"`
+	FetchCertAPIPath       string                   `json:"fetch_cert_api_path"`
+	CACertAPIPath          string                   `json:"ca_cert_api_path"`
+
+	// Instances holds additional Keyfactor environments that can be
+	// addressed with `<instance>/<value>` notation in the ca and template
+	// request parameters.
+	Instances map[string]*keyfactorConfig `json:"keyfactor_instances,omitempty"`
 }
 
 func (b *keyfactorBackend) fetchConfig(ctx context.Context, s logical.Storage) (*keyfactorConfig, error) {
@@ -58,6 +117,15 @@ func (b *keyfactorBackend) fetchConfig(ctx context.Context, s logical.Storage) (
 		return nil, err
 	}
 
+	// configurations written by earlier versions of the plugin are upgraded
+	// in memory; config/migrate persists the upgrade
+	if version := config.schemaVersion(); version < currentConfigSchemaVersion {
+		if err := config.migrate(); err != nil {
+			return nil, err
+		}
+		b.Logger().Debug("migrated configuration in memory", "from_version", version, "to_version", currentConfigSchemaVersion)
+	}
+
 	b.cachedConfig = config
 
 	return config, nil
@@ -165,6 +233,264 @@ func pathConfig(b *keyfactorBackend) []*framework.Path {
 					Description: "Path to CA certificate to use when connecting to the Keyfactor Command API in PEM format.",
 					Required:    false,
 				},
+				"prohibited_common_names": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Common names that may never be issued by any role, compared case-insensitively.",
+					Required:    false,
+				},
+				"ocsp_server_url": {
+					Type:        framework.TypeString,
+					Description: "The OCSP responder URL for issued certificates. Advisory only; the Keyfactor template controls the AIA extension of issued certificates.",
+					Required:    false,
+				},
+				"circuit_breaker_threshold": {
+					Type:        framework.TypeInt,
+					Description: "The number of consecutive failed requests to Keyfactor after which further requests are rejected until the circuit breaker timeout has elapsed.",
+					Required:    false,
+					Default:     defaultCircuitBreakerThreshold,
+				},
+				"circuit_breaker_timeout": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long requests to Keyfactor are rejected once the circuit breaker has opened, before a single probe request is allowed.",
+					Required:    false,
+					Default:     int(defaultCircuitBreakerTimeout.Seconds()),
+				},
+				"keyfactor_error_map": {
+					Type: framework.TypeMap,
+					Description: "A map of Keyfactor error codes or HTTP status codes to the action to take: `user_error`, `internal_error` or `retry`." +
+						" Unmapped 4xx responses are user errors and all other failures are internal errors.",
+					Required: false,
+				},
+				"issue_timeout": {
+					Type:        framework.TypeDurationSecond,
+					Description: "The maximum time to wait for Keyfactor when handling issue requests. Requests may ask for a shorter timeout.",
+					Required:    false,
+					Default:     int(defaultOperationTimeout.Seconds()),
+				},
+				"sign_timeout": {
+					Type:        framework.TypeDurationSecond,
+					Description: "The maximum time to wait for Keyfactor when handling sign requests. Requests may ask for a shorter timeout.",
+					Required:    false,
+					Default:     int(defaultOperationTimeout.Seconds()),
+				},
+				"revoke_timeout": {
+					Type:        framework.TypeDurationSecond,
+					Description: "The maximum time to wait for Keyfactor when handling revoke requests. Requests may ask for a shorter timeout.",
+					Required:    false,
+					Default:     int(defaultOperationTimeout.Seconds()),
+				},
+				"ca_chain_cache_ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long the CA chain fetched from Keyfactor is cached before it is fetched again.",
+					Required:    false,
+					Default:     int(defaultCAChainCacheTTL.Seconds()),
+				},
+				"list_workers": {
+					Type:        framework.TypeInt,
+					Description: "The number of certificates parsed in parallel when listing certificates with details.",
+					Required:    false,
+					Default:     defaultListWorkers,
+				},
+				"template_cache_ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long the templates available on a CA are cached before they are fetched again.",
+					Required:    false,
+					Default:     int(defaultTemplateCacheTTL.Seconds()),
+				},
+				"enrollment_format": {
+					Type:        framework.TypeString,
+					Description: "The format of enrollment requests sent to Keyfactor: `json` or `multipart_form` for EJBCA-style enrollment endpoints.",
+					Required:    false,
+					Default:     enrollmentFormatJSON,
+				},
+				"entity_metadata_fields": {
+					Type:        framework.TypeKVPairs,
+					Description: "A map of Vault entity metadata attribute names to the Keyfactor metadata fields they are sent as when the requesting entity issues a certificate.",
+					Required:    false,
+				},
+				"storage_prefix_override": {
+					Type:        framework.TypeString,
+					Description: "A prefix added to the storage keys of everything except this configuration, e.g. to move certificates between mounts with a copy job. Existing entries are not moved when it changes.",
+					Required:    false,
+				},
+				"auto_import_on_fetch": {
+					Type:        framework.TypeBool,
+					Description: "Deprecated: use the auto_import_on_fetch flag of config/features, which takes precedence once set. Set to true to import certificates that are not stored locally from Keyfactor when they are read.",
+					Required:    false,
+					Default:     false,
+				},
+				"max_certs_stored": {
+					Type:        framework.TypeInt,
+					Description: "The maximum number of certificates stored by the backend; enrollments beyond it are rejected. 0 means unlimited.",
+					Required:    false,
+				},
+				"max_concurrent_issuances": {
+					Type:        framework.TypeInt,
+					Description: "The maximum number of enrollments in progress at once on a node. 0 means unlimited.",
+					Required:    false,
+				},
+				"metadata_schema": {
+					Type:        framework.TypeString,
+					Description: "A draft-07 JSON schema that the metadata of certificate requests must match.",
+					Required:    false,
+				},
+				"template_timeouts": {
+					Type:        framework.TypeKVPairs,
+					Description: "A map of certificate template names to the maximum time to wait for Keyfactor when enrolling with that template, e.g. 120s. Templates that are not listed use issue_timeout or sign_timeout.",
+					Required:    false,
+				},
+				"keyfactor_base_url": {
+					Type:        framework.TypeString,
+					Description: "The base URL of the Command API, used verbatim instead of url and api_path when Keyfactor is behind a reverse proxy that changes the path, e.g. https://proxy.example.com/kf/api.",
+					Required:    false,
+				},
+				"crl_push_url": {
+					Type:        framework.TypeString,
+					Description: "An http or https URL the CRL is POSTed to after each fetch, e.g. a web server that serves CRLs to relying parties.",
+					Required:    false,
+				},
+				"crl_push_interval": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How often the periodic function fetches the CRL of the CA and stores it. 0 disables fetching.",
+					Required:    false,
+				},
+				"crl_push_username": {
+					Type:        framework.TypeString,
+					Description: "The username for Basic auth to crl_push_url.",
+					Required:    false,
+				},
+				"crl_push_password": {
+					Type:        framework.TypeString,
+					Description: "The password for Basic auth to crl_push_url.",
+					Required:    false,
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"ocsp_signing_cert_pem": {
+					Type:        framework.TypeString,
+					Description: "The PEM-encoded certificate the ocsp path signs responses with: the CA certificate or a delegated OCSP responder certificate issued by the CA.",
+					Required:    false,
+				},
+				"ocsp_signing_key_pem": {
+					Type:        framework.TypeString,
+					Description: "The PEM-encoded private key of ocsp_signing_cert_pem.",
+					Required:    false,
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"sensitive_request_fields": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Request fields whose values are redacted when requests are logged. csr, private_key and password are always redacted.",
+					Required:    false,
+				},
+				"keyfactor_max_response_bytes": {
+					Type:        framework.TypeInt,
+					Description: "The largest Keyfactor response body, in bytes, that is read. Defaults to 1048576 (1MB).",
+					Default:     defaultMaxResponseBytes,
+					Required:    false,
+				},
+				"compliance_rules": {
+					Type: framework.TypeSlice,
+					Description: `A list of rules that certs/<serial>/compliance checks certificates against. Each entry is an
+object with "name", "check" (one of min_key_size, must_have_san, no_wildcard or max_validity_days) and "value".`,
+					Required: false,
+				},
+				"default_comment": {
+					Type:        framework.TypeString,
+					Description: "The comment recorded in the Keyfactor audit history of certificates enrolled without a comment. Defaults to \"Issued via HashiCorp Vault by <entity_id> on <mount_path>\".",
+					Required:    false,
+				},
+				"keyfactor_id_format": {
+					Type:          framework.TypeString,
+					Description:   `The format Keyfactor certificate IDs are stored in: "int" or "hex". Existing IDs are converted by tidy with migrate_keyfactor_ids.`,
+					Default:       keyfactorIdFormatInt,
+					AllowedValues: []interface{}{keyfactorIdFormatInt, keyfactorIdFormatHex},
+					Required:      false,
+				},
+				"max_metadata_bytes": {
+					Type:        framework.TypeInt,
+					Description: "The largest metadata JSON, in bytes, accepted in enrollment requests. Defaults to 4096.",
+					Default:     defaultMaxMetadataBytes,
+					Required:    false,
+				},
+				"max_metadata_keys": {
+					Type:        framework.TypeInt,
+					Description: "The number of top-level metadata fields accepted in enrollment requests. Defaults to 50.",
+					Default:     defaultMaxMetadataKeys,
+					Required:    false,
+				},
+				"requester_prefix": {
+					Type:        framework.TypeString,
+					Description: "The prefix of the Requester sent with enrollments, formatted as <prefix>/<namespace>/<entity_id>.",
+					Default:     defaultRequesterPrefix,
+					Required:    false,
+				},
+				"acme_base_url": {
+					Type:        framework.TypeString,
+					Description: "The externally reachable URL of this mount, such as https://vault.example.com/v1/keyfactor, that the URLs of the ACME endpoints are built from.",
+					Required:    false,
+				},
+				"acme_role": {
+					Type:        framework.TypeString,
+					Description: "The role that ACME orders are validated against and issued with.",
+					Required:    false,
+				},
+				"issuer_name": {
+					Type:        framework.TypeString,
+					Description: "The name identifying the issuer of certificates enrolled with this instance, returned as issuer_name by the issue, sign and read paths. Instances in keyfactor_instances accept issuer_name and issuer_names too.",
+					Required:    false,
+				},
+				"issuer_names": {
+					Type:        framework.TypeKVPairs,
+					Description: "A map of CA names to the issuer_name of the certificates they issue, overriding issuer_name.",
+					Required:    false,
+				},
+				"enrollment_max_retries": {
+					Type:        framework.TypeInt,
+					Description: "The number of times an enrollment is retried when keyfactor_error_map classifies its error as retryable. 0 disables retries.",
+					Required:    false,
+					Default:     defaultEnrollmentMaxRetries,
+				},
+				"revoke_max_retries": {
+					Type:        framework.TypeInt,
+					Description: "The number of times a revocation is retried when keyfactor_error_map classifies its error as retryable. 0 disables retries.",
+					Required:    false,
+					Default:     defaultRevokeMaxRetries,
+				},
+				"revoke_api_path": {
+					Type:        framework.TypeString,
+					Description: "The path of the Keyfactor API endpoint used to revoke certificates, relative to the Command API. Defaults to " + kf_revoke_path + ".",
+					Required:    false,
+					Default:     kf_revoke_path,
+				},
+				"enroll_api_path": {
+					Type:        framework.TypeString,
+					Description: "The path of the Keyfactor API endpoint used to enroll CSRs, relative to the Command API. Defaults to " + kf_enroll_path + ".",
+					Required:    false,
+					Default:     kf_enroll_path,
+				},
+				"fetch_cert_api_path": {
+					Type:        framework.TypeString,
+					Description: "The path of the Keyfactor API endpoint used to download issued certificates, relative to the Command API. Defaults to " + kf_download_path + ".",
+					Required:    false,
+					Default:     kf_download_path,
+				},
+				"ca_cert_api_path": {
+					Type:        framework.TypeString,
+					Description: "The path of the Keyfactor API endpoint used to download the CA certificate and chain, relative to the Command API. Defaults to " + kf_download_path + ".",
+					Required:    false,
+					Default:     kf_download_path,
+				},
+				"keyfactor_instances": {
+					Type: framework.TypeMap,
+					Description: "A map of additional Keyfactor instances, keyed by instance name. Each value accepts url, api_path," +
+						" username, password, domain, client_id, client_secret, token_url, access_token, skip_verify, scopes, audience," +
+						" ca, template, command_cert_path, issuer_name and issuer_names; all other settings are taken from this path." +
+						" Requests select an instance by prefixing the ca or template with `<instance>/`.",
+					Required: false,
+				},
 				"show_hidden": {
 					Type:        framework.TypeBool,
 					Description: "Set this flag to show sensitive values in the output",
@@ -180,6 +506,15 @@ func pathConfig(b *keyfactorBackend) []*framework.Path {
 			HelpSynopsis:    pathConfigHelpSynopsis,
 			HelpDescription: pathConfigHelpDescription,
 		},
+		{
+			Pattern: `config/public`,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathConfigPublicRead,
+			},
+			HelpSynopsis:    pathConfigPublicHelpSynopsis,
+			HelpDescription: pathConfigPublicHelpDescription,
+		},
 	}
 }
 
@@ -209,27 +544,174 @@ func (b *keyfactorBackend) pathConfigRead(
 		password = "(hidden)"
 	}
 
+	crlPushPassword := config.CRLPushPassword
+	if crlPushPassword != "" && !showSensitiveData {
+		crlPushPassword = "(hidden)"
+	}
+
+	ocspSigningKeyPEM := config.OCSPSigningKeyPEM
+	if ocspSigningKeyPEM != "" && !showSensitiveData {
+		ocspSigningKeyPEM = "(hidden)"
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"url":                          config.KeyfactorUrl,
+			"api_path":                     config.CommandAPIPath,
+			"username":                     config.Username,
+			"password":                     password,
+			"client_id":                    config.ClientId,
+			"client_secret":                clientSecret,
+			"token_url":                    config.TokenUrl,
+			"scopes":                       config.Scopes,
+			"audience":                     config.Audience,
+			"access_token":                 config.AccessToken,
+			"ca":                           config.CertAuthority,
+			"template":                     config.CertTemplate,
+			"command_cert_path":            config.CommandCertPath,
+			"skip_verify":                  config.SkipTLSVerify,
+			"domain":                       config.Domain,
+			"prohibited_common_names":      config.ProhibitedCommonNames,
+			"ocsp_server_url":              config.OCSPServerURL,
+			"circuit_breaker_threshold":    config.CircuitBreakerThreshold,
+			"circuit_breaker_timeout":      int64(config.CircuitBreakerTimeout.Seconds()),
+			"keyfactor_error_map":          config.ErrorMap,
+			"issue_timeout":                int64(config.IssueTimeout.Seconds()),
+			"sign_timeout":                 int64(config.SignTimeout.Seconds()),
+			"revoke_timeout":               int64(config.RevokeTimeout.Seconds()),
+			"ca_chain_cache_ttl":           int64(config.CAChainCacheTTL.Seconds()),
+			"list_workers":                 config.ListWorkers,
+			"template_cache_ttl":           int64(config.TemplateCacheTTL.Seconds()),
+			"enrollment_format":            config.EnrollmentFormat,
+			"entity_metadata_fields":       config.EntityMetadataFields,
+			"storage_prefix_override":      config.StoragePrefixOverride,
+			"schema_version":               config.SchemaVersion,
+			"auto_import_on_fetch":         config.AutoImportOnFetch,
+			"max_certs_stored":             config.MaxCertsStored,
+			"max_concurrent_issuances":     config.MaxConcurrentIssuances,
+			"metadata_schema":              config.MetadataSchema,
+			"template_timeouts":            config.templateTimeoutsResponseData(),
+			"keyfactor_base_url":           config.KeyfactorBaseURL,
+			"crl_push_url":                 config.CRLPushURL,
+			"crl_push_interval":            int64(config.CRLPushInterval.Seconds()),
+			"crl_push_username":            config.CRLPushUsername,
+			"crl_push_password":            crlPushPassword,
+			"ocsp_signing_cert_pem":        config.OCSPSigningCertPEM,
+			"ocsp_signing_key_pem":         ocspSigningKeyPEM,
+			"sensitive_request_fields":     config.SensitiveRequestFields,
+			"keyfactor_max_response_bytes": config.MaxResponseBytes,
+			"compliance_rules":             config.ComplianceRules,
+			"default_comment":              config.DefaultComment,
+			"keyfactor_id_format":          config.keyfactorIdFormat(),
+			"max_metadata_bytes":           config.MaxMetadataBytes,
+			"max_metadata_keys":            config.MaxMetadataKeys,
+			"requester_prefix":             config.RequesterPrefix,
+			"acme_base_url":                config.ACMEBaseURL,
+			"acme_role":                    config.ACMERole,
+			"issuer_name":                  config.IssuerName,
+			"issuer_names":                 config.IssuerNames,
+			"enrollment_max_retries":       config.EnrollmentMaxRetries,
+			"revoke_max_retries":           config.RevokeMaxRetries,
+			"revoke_api_path":              config.RevokeAPIPath,
+			"enroll_api_path":              config.EnrollAPIPath,
+			"fetch_cert_api_path":          config.FetchCertAPIPath,
+			"ca_cert_api_path":             config.CACertAPIPath,
+			"keyfactor_instances":          config.instancesResponseData(showSensitiveData),
+		},
+	}, nil
+}
+
+// pathConfigPublicRead reads the configuration with all credentials redacted,
+// so that it can be exposed to operators who should not see them.
+func (b *keyfactorBackend) pathConfigPublicRead(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	config, err := b.fetchConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, nil
+	}
+
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"url":               config.KeyfactorUrl,
-			"api_path":          config.CommandAPIPath,
-			"username":          config.Username,
-			"password":          password,
-			"client_id":         config.ClientId,
-			"client_secret":     clientSecret,
-			"token_url":         config.TokenUrl,
-			"scopes":            config.Scopes,
-			"audience":          config.Audience,
-			"access_token":      config.AccessToken,
-			"ca":                config.CertAuthority,
-			"template":          config.CertTemplate,
-			"command_cert_path": config.CommandCertPath,
-			"skip_verify":       config.SkipTLSVerify,
-			"domain":            config.Domain,
+			"url":                          config.KeyfactorUrl,
+			"api_path":                     config.CommandAPIPath,
+			"username":                     config.Username,
+			"password":                     redactSensitive(config.Password),
+			"client_id":                    config.ClientId,
+			"client_secret":                redactSensitive(config.ClientSecret),
+			"token_url":                    config.TokenUrl,
+			"scopes":                       config.Scopes,
+			"audience":                     config.Audience,
+			"access_token":                 redactSensitive(config.AccessToken),
+			"ca":                           config.CertAuthority,
+			"template":                     config.CertTemplate,
+			"command_cert_path":            config.CommandCertPath,
+			"skip_verify":                  config.SkipTLSVerify,
+			"domain":                       config.Domain,
+			"prohibited_common_names":      config.ProhibitedCommonNames,
+			"ocsp_server_url":              config.OCSPServerURL,
+			"circuit_breaker_threshold":    config.CircuitBreakerThreshold,
+			"circuit_breaker_timeout":      int64(config.CircuitBreakerTimeout.Seconds()),
+			"keyfactor_error_map":          config.ErrorMap,
+			"issue_timeout":                int64(config.IssueTimeout.Seconds()),
+			"sign_timeout":                 int64(config.SignTimeout.Seconds()),
+			"revoke_timeout":               int64(config.RevokeTimeout.Seconds()),
+			"ca_chain_cache_ttl":           int64(config.CAChainCacheTTL.Seconds()),
+			"list_workers":                 config.ListWorkers,
+			"template_cache_ttl":           int64(config.TemplateCacheTTL.Seconds()),
+			"enrollment_format":            config.EnrollmentFormat,
+			"entity_metadata_fields":       config.EntityMetadataFields,
+			"storage_prefix_override":      config.StoragePrefixOverride,
+			"schema_version":               config.SchemaVersion,
+			"auto_import_on_fetch":         config.AutoImportOnFetch,
+			"max_certs_stored":             config.MaxCertsStored,
+			"max_concurrent_issuances":     config.MaxConcurrentIssuances,
+			"metadata_schema":              config.MetadataSchema,
+			"template_timeouts":            config.templateTimeoutsResponseData(),
+			"keyfactor_base_url":           config.KeyfactorBaseURL,
+			"crl_push_url":                 config.CRLPushURL,
+			"crl_push_interval":            int64(config.CRLPushInterval.Seconds()),
+			"crl_push_username":            config.CRLPushUsername,
+			"crl_push_password":            redactSensitive(config.CRLPushPassword),
+			"ocsp_signing_cert_pem":        config.OCSPSigningCertPEM,
+			"ocsp_signing_key_pem":         redactSensitive(config.OCSPSigningKeyPEM),
+			"sensitive_request_fields":     config.SensitiveRequestFields,
+			"keyfactor_max_response_bytes": config.MaxResponseBytes,
+			"compliance_rules":             config.ComplianceRules,
+			"default_comment":              config.DefaultComment,
+			"keyfactor_id_format":          config.keyfactorIdFormat(),
+			"max_metadata_bytes":           config.MaxMetadataBytes,
+			"max_metadata_keys":            config.MaxMetadataKeys,
+			"requester_prefix":             config.RequesterPrefix,
+			"acme_base_url":                config.ACMEBaseURL,
+			"acme_role":                    config.ACMERole,
+			"issuer_name":                  config.IssuerName,
+			"issuer_names":                 config.IssuerNames,
+			"enrollment_max_retries":       config.EnrollmentMaxRetries,
+			"revoke_max_retries":           config.RevokeMaxRetries,
+			"revoke_api_path":              config.RevokeAPIPath,
+			"enroll_api_path":              config.EnrollAPIPath,
+			"fetch_cert_api_path":          config.FetchCertAPIPath,
+			"ca_cert_api_path":             config.CACertAPIPath,
+			"keyfactor_instances":          config.instancesResponseData(false),
 		},
 	}, nil
 }
 
+// redactSensitive replaces a non-empty sensitive value with a placeholder
+// so callers can tell whether it has been set without seeing it.
+func redactSensitive(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "REDACTED"
+}
+
 // pathConfigUpdate updates the configuration for the backend
 func (b *keyfactorBackend) pathConfigUpdate(
 	ctx context.Context,
@@ -237,25 +719,66 @@ func (b *keyfactorBackend) pathConfigUpdate(
 	data *framework.FieldData,
 ) (*logical.Response, error) {
 	b.Logger().Debug("Calling pathConfigUpdate")
-	b.configLock.RLock()
-	defer b.configLock.RUnlock()
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
 
 	newConfig := &keyfactorConfig{
-		KeyfactorUrl:    data.Get("url").(string),
-		Username:        data.Get("username").(string),
-		Password:        data.Get("password").(string),
-		CertAuthority:   data.Get("ca").(string),
-		CertTemplate:    data.Get("template").(string),
-		CommandAPIPath:  data.Get("api_path").(string),
-		ClientId:        data.Get("client_id").(string),
-		ClientSecret:    data.Get("client_secret").(string),
-		TokenUrl:        data.Get("token_url").(string),
-		AccessToken:     data.Get("access_token").(string),
-		Scopes:          data.Get("scopes").([]string),
-		Audience:        data.Get("audience").([]string),
-		Domain:          data.Get("domain").(string),
-		CommandCertPath: data.Get("command_cert_path").(string),
-		SkipTLSVerify:   data.Get("skip_verify").(bool),
+		KeyfactorUrl:          data.Get("url").(string),
+		Username:              data.Get("username").(string),
+		Password:              data.Get("password").(string),
+		CertAuthority:         data.Get("ca").(string),
+		CertTemplate:          data.Get("template").(string),
+		CommandAPIPath:        data.Get("api_path").(string),
+		ClientId:              data.Get("client_id").(string),
+		ClientSecret:          data.Get("client_secret").(string),
+		TokenUrl:              data.Get("token_url").(string),
+		AccessToken:           data.Get("access_token").(string),
+		Scopes:                data.Get("scopes").([]string),
+		Audience:              data.Get("audience").([]string),
+		Domain:                data.Get("domain").(string),
+		CommandCertPath:       data.Get("command_cert_path").(string),
+		SkipTLSVerify:         data.Get("skip_verify").(bool),
+		ProhibitedCommonNames: data.Get("prohibited_common_names").([]string),
+		OCSPServerURL:         data.Get("ocsp_server_url").(string),
+
+		CircuitBreakerThreshold: data.Get("circuit_breaker_threshold").(int),
+		CircuitBreakerTimeout:   time.Duration(data.Get("circuit_breaker_timeout").(int)) * time.Second,
+		IssueTimeout:            time.Duration(data.Get("issue_timeout").(int)) * time.Second,
+		SignTimeout:             time.Duration(data.Get("sign_timeout").(int)) * time.Second,
+		RevokeTimeout:           time.Duration(data.Get("revoke_timeout").(int)) * time.Second,
+		CAChainCacheTTL:         time.Duration(data.Get("ca_chain_cache_ttl").(int)) * time.Second,
+		ListWorkers:             data.Get("list_workers").(int),
+		TemplateCacheTTL:        time.Duration(data.Get("template_cache_ttl").(int)) * time.Second,
+		EnrollmentFormat:        data.Get("enrollment_format").(string),
+		StoragePrefixOverride:   data.Get("storage_prefix_override").(string),
+		AutoImportOnFetch:       data.Get("auto_import_on_fetch").(bool),
+		MaxCertsStored:          data.Get("max_certs_stored").(int),
+		MaxConcurrentIssuances:  data.Get("max_concurrent_issuances").(int),
+		MetadataSchema:          data.Get("metadata_schema").(string),
+		KeyfactorBaseURL:        data.Get("keyfactor_base_url").(string),
+		CRLPushURL:              data.Get("crl_push_url").(string),
+		CRLPushInterval:         time.Duration(data.Get("crl_push_interval").(int)) * time.Second,
+		CRLPushUsername:         data.Get("crl_push_username").(string),
+		CRLPushPassword:         data.Get("crl_push_password").(string),
+		OCSPSigningCertPEM:      data.Get("ocsp_signing_cert_pem").(string),
+		OCSPSigningKeyPEM:       data.Get("ocsp_signing_key_pem").(string),
+		SensitiveRequestFields:  data.Get("sensitive_request_fields").([]string),
+		MaxResponseBytes:        int64(data.Get("keyfactor_max_response_bytes").(int)),
+		DefaultComment:          data.Get("default_comment").(string),
+		KeyfactorIDFormat:       data.Get("keyfactor_id_format").(string),
+		MaxMetadataBytes:        data.Get("max_metadata_bytes").(int),
+		MaxMetadataKeys:         data.Get("max_metadata_keys").(int),
+		RequesterPrefix:         data.Get("requester_prefix").(string),
+		ACMEBaseURL:             data.Get("acme_base_url").(string),
+		ACMERole:                data.Get("acme_role").(string),
+		IssuerName:              data.Get("issuer_name").(string),
+		IssuerNames:             data.Get("issuer_names").(map[string]string),
+		EnrollmentMaxRetries:    data.Get("enrollment_max_retries").(int),
+		RevokeMaxRetries:        data.Get("revoke_max_retries").(int),
+		RevokeAPIPath:           data.Get("revoke_api_path").(string),
+		EnrollAPIPath:           data.Get("enroll_api_path").(string),
+		FetchCertAPIPath:        data.Get("fetch_cert_api_path").(string),
+		CACertAPIPath:           data.Get("ca_cert_api_path").(string),
 	}
 
 	// Check if the config already exists, to determine if this is a create or
@@ -270,67 +793,324 @@ func (b *keyfactorBackend) pathConfigUpdate(
 		existingConfig = newConfig
 	}
 
+	// the update is made on a copy, so that the cached configuration is left
+	// unchanged if it is rejected or cannot be stored
+	cfg := *existingConfig
+
 	if username, ok := data.GetOk("username"); ok {
-		existingConfig.Username = username.(string)
+		cfg.Username = username.(string)
 	}
 
 	if url, ok := data.GetOk("url"); ok {
-		existingConfig.KeyfactorUrl = url.(string)
+		cfg.KeyfactorUrl = url.(string)
 	}
 
 	if password, ok := data.GetOk("password"); ok {
-		existingConfig.Password = password.(string)
+		cfg.Password = password.(string)
 	}
 
 	if ca, ok := data.GetOk("ca"); ok {
-		existingConfig.CertAuthority = ca.(string)
+		cfg.CertAuthority = ca.(string)
 	}
 
 	if template, ok := data.GetOk("template"); ok {
-		existingConfig.CertTemplate = template.(string)
+		cfg.CertTemplate = template.(string)
 	}
 
 	if apiPath, ok := data.GetOk("api_path"); ok {
-		existingConfig.CommandAPIPath = apiPath.(string)
+		cfg.CommandAPIPath = apiPath.(string)
 	}
 
 	if clientId, ok := data.GetOk("client_id"); ok {
-		existingConfig.ClientId = clientId.(string)
+		cfg.ClientId = clientId.(string)
 	}
 
 	if clientSecret, ok := data.GetOk("client_secret"); ok {
-		existingConfig.ClientSecret = clientSecret.(string)
+		cfg.ClientSecret = clientSecret.(string)
 	}
 
 	if tokenUrl, ok := data.GetOk("token_url"); ok {
-		existingConfig.TokenUrl = tokenUrl.(string)
+		cfg.TokenUrl = tokenUrl.(string)
 	}
 
 	if accessToken, ok := data.GetOk("access_token"); ok {
-		existingConfig.AccessToken = accessToken.(string)
+		cfg.AccessToken = accessToken.(string)
 	}
 
 	if scopes, ok := data.GetOk("scopes"); ok {
-		existingConfig.Scopes = scopes.([]string)
+		cfg.Scopes = scopes.([]string)
 	}
 
 	if audience, ok := data.GetOk("audience"); ok {
-		existingConfig.Audience = audience.([]string)
+		cfg.Audience = audience.([]string)
 	}
 
 	if domain, ok := data.GetOk("domain"); ok {
-		existingConfig.Domain = domain.(string)
+		cfg.Domain = domain.(string)
 	}
 
 	if skipVerify, ok := data.GetOk("skip_verify"); ok {
-		existingConfig.SkipTLSVerify = skipVerify.(bool)
+		cfg.SkipTLSVerify = skipVerify.(bool)
 	}
 
 	if caCertPath, ok := data.GetOk("command_cert_path"); ok {
-		existingConfig.CommandCertPath = caCertPath.(string)
+		cfg.CommandCertPath = caCertPath.(string)
+	}
+
+	if prohibited, ok := data.GetOk("prohibited_common_names"); ok {
+		cfg.ProhibitedCommonNames = prohibited.([]string)
+	}
+
+	if ocspServerURL, ok := data.GetOk("ocsp_server_url"); ok {
+		cfg.OCSPServerURL = ocspServerURL.(string)
+	}
+
+	if threshold, ok := data.GetOk("circuit_breaker_threshold"); ok {
+		cfg.CircuitBreakerThreshold = threshold.(int)
+	}
+
+	if timeout, ok := data.GetOk("circuit_breaker_timeout"); ok {
+		cfg.CircuitBreakerTimeout = time.Duration(timeout.(int)) * time.Second
+	}
+
+	if cfg.CircuitBreakerThreshold < 0 || cfg.CircuitBreakerTimeout < 0 {
+		return logical.ErrorResponse("circuit_breaker_threshold and circuit_breaker_timeout must not be negative"), nil
+	}
+
+	if errorMap, ok := data.GetOk("keyfactor_error_map"); ok {
+		parsed, err := parseErrorMap(errorMap.(map[string]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		cfg.ErrorMap = parsed
+	}
+
+	if issueTimeout, ok := data.GetOk("issue_timeout"); ok {
+		cfg.IssueTimeout = time.Duration(issueTimeout.(int)) * time.Second
+	}
+
+	if signTimeout, ok := data.GetOk("sign_timeout"); ok {
+		cfg.SignTimeout = time.Duration(signTimeout.(int)) * time.Second
+	}
+
+	if revokeTimeout, ok := data.GetOk("revoke_timeout"); ok {
+		cfg.RevokeTimeout = time.Duration(revokeTimeout.(int)) * time.Second
+	}
+
+	if caChainCacheTTL, ok := data.GetOk("ca_chain_cache_ttl"); ok {
+		cfg.CAChainCacheTTL = time.Duration(caChainCacheTTL.(int)) * time.Second
+	}
+
+	if listWorkers, ok := data.GetOk("list_workers"); ok {
+		cfg.ListWorkers = listWorkers.(int)
+	}
+
+	if templateCacheTTL, ok := data.GetOk("template_cache_ttl"); ok {
+		cfg.TemplateCacheTTL = time.Duration(templateCacheTTL.(int)) * time.Second
+	}
+
+	if enrollmentFormat, ok := data.GetOk("enrollment_format"); ok {
+		if err := validateEnrollmentFormat(enrollmentFormat.(string)); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		cfg.EnrollmentFormat = enrollmentFormat.(string)
+	}
+
+	if entityMetadataFields, ok := data.GetOk("entity_metadata_fields"); ok {
+		if err := validateEntityMetadataFields(entityMetadataFields.(map[string]string)); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		cfg.EntityMetadataFields = entityMetadataFields.(map[string]string)
+	}
+
+	if storagePrefixOverride, ok := data.GetOk("storage_prefix_override"); ok {
+		cfg.StoragePrefixOverride = storagePrefixOverride.(string)
+	}
+
+	if autoImportOnFetch, ok := data.GetOk("auto_import_on_fetch"); ok {
+		cfg.AutoImportOnFetch = autoImportOnFetch.(bool)
+	}
+
+	if maxCertsStored, ok := data.GetOk("max_certs_stored"); ok {
+		cfg.MaxCertsStored = maxCertsStored.(int)
+	}
+
+	if maxConcurrentIssuances, ok := data.GetOk("max_concurrent_issuances"); ok {
+		cfg.MaxConcurrentIssuances = maxConcurrentIssuances.(int)
+	}
+
+	if metadataSchema, ok := data.GetOk("metadata_schema"); ok {
+		if metadataSchema.(string) != "" {
+			if _, err := compileMetadataSchema(metadataSchema.(string)); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+		cfg.MetadataSchema = metadataSchema.(string)
+	}
+
+	if templateTimeouts, ok := data.GetOk("template_timeouts"); ok {
+		parsed, err := parseTemplateTimeouts(templateTimeouts.(map[string]string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		cfg.TemplateTimeouts = parsed
+	}
+
+	if keyfactorBaseURL, ok := data.GetOk("keyfactor_base_url"); ok {
+		if err := validateBaseURL(keyfactorBaseURL.(string)); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		cfg.KeyfactorBaseURL = keyfactorBaseURL.(string)
+	}
+
+	if crlPushURL, ok := data.GetOk("crl_push_url"); ok {
+		if crlPushURL.(string) != "" {
+			parsed, err := url.Parse(crlPushURL.(string))
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return logical.ErrorResponse("crl_push_url must be an http or https URL"), nil
+			}
+		}
+		cfg.CRLPushURL = crlPushURL.(string)
+	}
+
+	if crlPushInterval, ok := data.GetOk("crl_push_interval"); ok {
+		cfg.CRLPushInterval = time.Duration(crlPushInterval.(int)) * time.Second
+	}
+
+	if crlPushUsername, ok := data.GetOk("crl_push_username"); ok {
+		cfg.CRLPushUsername = crlPushUsername.(string)
+	}
+
+	if crlPushPassword, ok := data.GetOk("crl_push_password"); ok {
+		cfg.CRLPushPassword = crlPushPassword.(string)
+	}
+
+	if ocspSigningCertPEM, ok := data.GetOk("ocsp_signing_cert_pem"); ok {
+		cfg.OCSPSigningCertPEM = ocspSigningCertPEM.(string)
+	}
+
+	if ocspSigningKeyPEM, ok := data.GetOk("ocsp_signing_key_pem"); ok {
+		cfg.OCSPSigningKeyPEM = ocspSigningKeyPEM.(string)
+	}
+
+	if cfg.OCSPSigningCertPEM != "" || cfg.OCSPSigningKeyPEM != "" {
+		if _, _, err := parseOCSPSigner(cfg.OCSPSigningCertPEM, cfg.OCSPSigningKeyPEM); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if sensitiveRequestFields, ok := data.GetOk("sensitive_request_fields"); ok {
+		cfg.SensitiveRequestFields = sensitiveRequestFields.([]string)
+	}
+
+	if maxResponseBytes, ok := data.GetOk("keyfactor_max_response_bytes"); ok {
+		cfg.MaxResponseBytes = int64(maxResponseBytes.(int))
+	}
+	if cfg.MaxResponseBytes < 0 {
+		return logical.ErrorResponse("keyfactor_max_response_bytes must not be negative"), nil
+	}
+
+	if complianceRules, ok := data.GetOk("compliance_rules"); ok {
+		parsed, err := parseComplianceRules(complianceRules.([]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		cfg.ComplianceRules = parsed
+	}
+
+	if defaultComment, ok := data.GetOk("default_comment"); ok {
+		cfg.DefaultComment = defaultComment.(string)
+	}
+
+	if keyfactorIdFormat, ok := data.GetOk("keyfactor_id_format"); ok {
+		cfg.KeyfactorIDFormat = keyfactorIdFormat.(string)
+	}
+	if err := validateKeyfactorIdFormat(cfg.keyfactorIdFormat()); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if maxMetadataBytes, ok := data.GetOk("max_metadata_bytes"); ok {
+		cfg.MaxMetadataBytes = maxMetadataBytes.(int)
+	}
+
+	if maxMetadataKeys, ok := data.GetOk("max_metadata_keys"); ok {
+		cfg.MaxMetadataKeys = maxMetadataKeys.(int)
+	}
+	if cfg.MaxMetadataBytes < 0 || cfg.MaxMetadataKeys < 0 {
+		return logical.ErrorResponse("max_metadata_bytes and max_metadata_keys must not be negative"), nil
+	}
+
+	if requesterPrefix, ok := data.GetOk("requester_prefix"); ok {
+		cfg.RequesterPrefix = requesterPrefix.(string)
+	}
+
+	if acmeBaseUrl, ok := data.GetOk("acme_base_url"); ok {
+		cfg.ACMEBaseURL = acmeBaseUrl.(string)
+	}
+
+	if acmeRole, ok := data.GetOk("acme_role"); ok {
+		cfg.ACMERole = acmeRole.(string)
+	}
+
+	if issuerName, ok := data.GetOk("issuer_name"); ok {
+		cfg.IssuerName = issuerName.(string)
+	}
+
+	if issuerNames, ok := data.GetOk("issuer_names"); ok {
+		cfg.IssuerNames = issuerNames.(map[string]string)
+	}
+
+	if enrollmentRetries, ok := data.GetOk("enrollment_max_retries"); ok {
+		cfg.EnrollmentMaxRetries = enrollmentRetries.(int)
+	}
+
+	if revokeRetries, ok := data.GetOk("revoke_max_retries"); ok {
+		cfg.RevokeMaxRetries = revokeRetries.(int)
+	}
+
+	if cfg.EnrollmentMaxRetries < 0 || cfg.RevokeMaxRetries < 0 {
+		return logical.ErrorResponse("enrollment_max_retries and revoke_max_retries must not be negative"), nil
+	}
+
+	if revokeApiPath, ok := data.GetOk("revoke_api_path"); ok {
+		cfg.RevokeAPIPath = revokeApiPath.(string)
+	}
+
+	if enrollApiPath, ok := data.GetOk("enroll_api_path"); ok {
+		cfg.EnrollAPIPath = enrollApiPath.(string)
+	}
+
+	if fetchCertApiPath, ok := data.GetOk("fetch_cert_api_path"); ok {
+		cfg.FetchCertAPIPath = fetchCertApiPath.(string)
+	}
+
+	if caCertApiPath, ok := data.GetOk("ca_cert_api_path"); ok {
+		cfg.CACertAPIPath = caCertApiPath.(string)
+	}
+
+	for name, apiPath := range map[string]string{
+		"revoke_api_path":     cfg.RevokeAPIPath,
+		"enroll_api_path":     cfg.EnrollAPIPath,
+		"fetch_cert_api_path": cfg.FetchCertAPIPath,
+		"ca_cert_api_path":    cfg.CACertAPIPath,
+	} {
+		if apiPath != "" && !strings.HasPrefix(apiPath, "/") {
+			return logical.ErrorResponse(fmt.Sprintf("%s must start with /", name)), nil
+		}
+	}
+
+	if instances, ok := data.GetOk("keyfactor_instances"); ok {
+		parsed, err := parseInstances(instances.(map[string]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		cfg.Instances = parsed
 	}
 
-	entry, err := logical.StorageEntryJSON(configPath, existingConfig)
+	// the configuration is always written at the current schema version
+	cfg.SchemaVersion = currentConfigSchemaVersion
+
+	entry, err := logical.StorageEntryJSON(configPath, &cfg)
 	if err != nil {
 		b.Logger().Error("[ERROR] there was an error converting the values to JSON for storage: %s", err)
 		return nil, err
@@ -341,20 +1121,84 @@ func (b *keyfactorBackend) pathConfigUpdate(
 		return nil, err
 	}
 
-	// reset the client so the next invocation will pick up the new configuration
-	b.reset()
-	b.cachedConfig = existingConfig
+	// reset the client so the next invocation will pick up the new
+	// configuration; reset itself takes configLock, which is held here
+	b.client = nil
+	b.instanceClients = nil
+	b.breaker.reset()
+	b.featuresLock.Lock()
+	b.cachedFeatures = nil
+	b.featuresLock.Unlock()
+	b.cachedConfig = &cfg
 	return nil, nil
 }
 
+// apiURL returns the URL of a path of the Command API, which starts with a
+// slash.  The URL is built from url and api_path unless keyfactor_base_url
+// is set.
+func (c *keyfactorConfig) apiURL(path string) string {
+	if c.KeyfactorBaseURL != "" {
+		return strings.TrimRight(c.KeyfactorBaseURL, "/") + path
+	}
+	return c.KeyfactorUrl + "/" + c.CommandAPIPath + path
+}
+
+// revokeAPIPath returns the Keyfactor API path used to revoke certificates.
+func (c *keyfactorConfig) revokeAPIPath() string {
+	return apiPathOrDefault(c.RevokeAPIPath, kf_revoke_path)
+}
+
+// enrollAPIPath returns the Keyfactor API path used to enroll CSRs.
+func (c *keyfactorConfig) enrollAPIPath() string {
+	return apiPathOrDefault(c.EnrollAPIPath, kf_enroll_path)
+}
+
+// fetchCertAPIPath returns the Keyfactor API path used to download issued
+// certificates.
+func (c *keyfactorConfig) fetchCertAPIPath() string {
+	return apiPathOrDefault(c.FetchCertAPIPath, kf_download_path)
+}
+
+// caCertAPIPath returns the Keyfactor API path used to download the CA
+// certificate and chain.
+func (c *keyfactorConfig) caCertAPIPath() string {
+	return apiPathOrDefault(c.CACertAPIPath, kf_download_path)
+}
+
+// apiPathOrDefault returns the configured API path, or the standard path of
+// the Command API when it is not configured.
+func apiPathOrDefault(configured string, standard string) string {
+	if configured == "" {
+		return standard
+	}
+	return configured
+}
+
+// validateBaseURL validates the `keyfactor_base_url` field.
+func validateBaseURL(baseURL string) error {
+	if baseURL == "" {
+		return nil
+	}
+	parsed, err := url.Parse(baseURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("keyfactor_base_url must be an http or https URL")
+	}
+	return nil
+}
+
 // pathConfigDelete removes the configuration for the backend
 func (b *keyfactorBackend) pathConfigDelete(
 	ctx context.Context,
 	req *logical.Request,
 	data *framework.FieldData,
 ) (*logical.Response, error) {
-	err := req.Storage.Delete(ctx, configPath)
-	return nil, err
+	if err := req.Storage.Delete(ctx, configPath); err != nil {
+		return nil, err
+	}
+
+	// drop the cached configuration along with the stored one
+	b.reset()
+	return nil, nil
 }
 
 // pathConfigHelpSynopsis summarizes the help text for the configuration
@@ -378,4 +1222,75 @@ The Keyfactor Secrets Engine plugin requires the following values to be defined;
 	url - url of the Keyfactor platform with no trailing slashes (ie: https://keyfactor.lab)
 	ca (optional) - the certificate authority in the format <hostname\\\\logical name>.  If omitted, will need to be passed for each request
 	template (optional) - the certificate template to use when enrolling.  If omitted, will need to be passed for each request.
+	prohibited_common_names (optional) - common names that may never be issued, regardless of role.
+	ocsp_server_url (optional) - advisory OCSP responder URL for issued certificates.
+	circuit_breaker_threshold (optional) - consecutive failed Keyfactor requests before requests are rejected.  Defaults to 5.
+	circuit_breaker_timeout (optional) - how long requests are rejected before a probe request is allowed.  Defaults to 30s.
+	keyfactor_error_map (optional) - a map of Keyfactor error codes or HTTP status codes to user_error, internal_error or retry.
+	issue_timeout (optional) - the maximum time to wait for Keyfactor on issue requests.  Defaults to 60s.
+	sign_timeout (optional) - the maximum time to wait for Keyfactor on sign requests.  Defaults to 60s.
+	revoke_timeout (optional) - the maximum time to wait for Keyfactor on revoke requests.  Defaults to 60s.
+	ca_chain_cache_ttl (optional) - how long the CA chain fetched from Keyfactor is cached.  Defaults to 1h.
+	list_workers (optional) - the number of certificates parsed in parallel when listing certificates with details.  Defaults to 8.
+	template_cache_ttl (optional) - how long the templates available on a CA are cached.  Defaults to 1h.
+	enrollment_format (optional) - the format of enrollment requests: "json" (default) or "multipart_form", which posts the CSR as an EJBCA-style multipart form.
+	entity_metadata_fields (optional) - a map of Vault entity metadata attributes to Keyfactor metadata field names, filled in from the requesting entity at issuance.
+	storage_prefix_override (optional) - a prefix added to every storage key except the configuration.  Existing entries are not moved when it changes.
+	auto_import_on_fetch (optional) - if true, reads of certificates that are not stored locally fall back to Keyfactor by default.  Defaults to false.  Deprecated in favour of the auto_import_on_fetch flag of config/features, which takes precedence once set.
+	max_certs_stored (optional) - the maximum number of certificates stored by the backend; enrollments beyond it are rejected.  Defaults to 0 (unlimited).
+	max_concurrent_issuances (optional) - the maximum number of enrollments in progress at once on a node.  Defaults to 0 (unlimited).
+	metadata_schema (optional) - a draft-07 JSON schema that the metadata of certificate requests is validated against before enrollment.
+	template_timeouts (optional) - a map of certificate template names to the maximum time to wait for Keyfactor when enrolling with that template, e.g. 120s.  Overrides issue_timeout and sign_timeout for the template.
+	keyfactor_base_url (optional) - the base URL of the Command API, used verbatim instead of url and api_path for API requests, e.g. when Keyfactor is behind a reverse proxy that changes the path.
+	crl_push_url (optional) - an http or https URL the CRL fetched every crl_push_interval is POSTed to.
+	crl_push_interval (optional) - how often the CRL of the CA is fetched, stored and pushed to crl_push_url.  Defaults to 0 (disabled).
+	crl_push_username (optional) - the username for Basic auth to crl_push_url.
+	crl_push_password (optional) - the password for Basic auth to crl_push_url.
+	ocsp_signing_cert_pem (optional) - the PEM-encoded CA or delegated responder certificate the ocsp path signs responses with.
+	ocsp_signing_key_pem (optional) - the PEM-encoded private key of ocsp_signing_cert_pem.
+	sensitive_request_fields (optional) - request fields whose values are redacted in debug logs, in addition to csr, private_key and password.
+	keyfactor_max_response_bytes (optional) - the largest Keyfactor response body, in bytes, that is read.  Defaults to 1048576 (1MB).
+	compliance_rules (optional) - rules that certs/<serial>/compliance checks certificates against.  Each rule has a name, a check (min_key_size, must_have_san, no_wildcard or max_validity_days) and a value.
+	default_comment (optional) - the comment recorded in the Keyfactor audit history of certificates enrolled without one.
+	keyfactor_id_format (optional) - the format Keyfactor certificate IDs are stored in, int or hex.  Defaults to int.  Run tidy with migrate_keyfactor_ids to convert existing IDs.
+	max_metadata_bytes (optional) - the largest metadata JSON, in bytes, accepted in enrollment requests.  Defaults to 4096.
+	max_metadata_keys (optional) - the number of top-level metadata fields accepted in enrollment requests.  Defaults to 50.
+	requester_prefix (optional) - the prefix of the Requester sent with enrollments; defaults to "vault".
+	acme_base_url (optional) - the externally reachable URL of this mount, used to build the ACME endpoint URLs.
+	acme_role (optional) - the role that ACME orders are validated against and issued with.
+	issuer_name (optional) - the name identifying the issuer of certificates enrolled with this instance.
+	issuer_names (optional) - a map of CA names to the issuer name of their certificates, overriding issuer_name.
+	enrollment_max_retries (optional) - the number of times an enrollment is retried on retryable errors.  Defaults to 3; 0 disables retries.
+	revoke_max_retries (optional) - the number of times a revocation is retried on retryable errors.  Defaults to 3; 0 disables retries.
+	revoke_api_path (optional) - the Keyfactor API path used to revoke certificates.
+	enroll_api_path (optional) - the Keyfactor API path used to enroll CSRs.
+	fetch_cert_api_path (optional) - the Keyfactor API path used to download issued certificates.
+	ca_cert_api_path (optional) - the Keyfactor API path used to download the CA certificate and chain.
+	keyfactor_instances (optional) - a map of additional named Keyfactor instances.  Select one per request with ca=<instance>/<ca> or template=<instance>/<template>.  Each instance sets its own url, api_path, credentials, skip_verify, ca, template, command_cert_path, issuer_name and issuer_names; all other settings are shared with the top-level configuration.
+
+To also return the backend to the state of a new mount, write to config/reset, which requires sudo.
+`
+
+// pathConfigPublicHelpSynopsis summarizes the help text for the public configuration
+const pathConfigPublicHelpSynopsis = `Read the Keyfactor Secrets Engine configuration without credentials.`
+
+// pathConfigPublicHelpDescription describes the help text for the public configuration
+const pathConfigPublicHelpDescription = `
+Returns the same values as the "config" path, but the password, client_secret and
+access_token values are always replaced with "REDACTED" when they have been set.
+This allows operators to verify the URL, CA and template without being granted
+access to the credentials.
 `
+
+// defaultListWorkers is the number of certificates parsed in parallel when
+// listing certificates with details.
+const defaultListWorkers = 8
+
+// listWorkers returns the configured list worker count, falling back to the
+// default when it is unset.
+func (c *keyfactorConfig) listWorkers() int {
+	if c == nil || c.ListWorkers <= 0 {
+		return defaultListWorkers
+	}
+	return c.ListWorkers
+}
@@ -0,0 +1,250 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// featuresPath is the storage key of the feature flags.  Like the
+// configuration, it is not moved by storage_prefix_override.
+const featuresPath = "config/features"
+
+// Feature flags.
+const (
+	featureACME              = "acme"
+	featureAutoRenew         = "auto_renew"
+	featureAutoImportOnFetch = "auto_import_on_fetch"
+	featureCircuitBreaker    = "circuit_breaker"
+	featureExpiryEvents      = "expiry_events"
+)
+
+// featureFlag describes a feature that can be enabled or disabled at runtime.
+type featureFlag struct {
+	Description string
+	Default     bool
+}
+
+// featureFlags are the features that can be toggled with config/features.
+var featureFlags = map[string]featureFlag{
+	featureACME: {
+		Description: "Serve the ACME endpoints under acme/ for the configured acme_base_url and acme_role.",
+		Default:     false,
+	},
+	featureAutoRenew: {
+		Description: "Renew the expiring certificates of roles with auto_renew set from the periodic function.",
+		Default:     true,
+	},
+	featureAutoImportOnFetch: {
+		Description: "Import certificates that are not stored locally from Keyfactor when they are read, unless the read sets keyfactor_fallback.",
+		Default:     false,
+	},
+	featureCircuitBreaker: {
+		Description: "Stop sending requests to Keyfactor for circuit_breaker_timeout after circuit_breaker_threshold consecutive failures.",
+		Default:     true,
+	},
+	featureExpiryEvents: {
+		Description: "Send events for certificates that are approaching their expiry from the periodic function.",
+		Default:     true,
+	},
+}
+
+func pathConfigFeatures(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config/features",
+			Fields: map[string]*framework.FieldSchema{
+				"features": {
+					Type:        framework.TypeMap,
+					Description: "A map of feature flag names to true to enable or false to disable the feature. Flags that are not included are left unchanged.",
+					Required:    true,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   b.pathConfigFeaturesRead,
+				logical.UpdateOperation: b.pathConfigFeaturesWrite,
+			},
+			HelpSynopsis:    pathConfigFeaturesHelpSynopsis,
+			HelpDescription: pathConfigFeaturesHelpDescription,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathConfigFeaturesRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	features, err := b.fetchFeatures(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return featuresResponse(features), nil
+}
+
+func (b *keyfactorBackend) pathConfigFeaturesWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	updates := data.Get("features").(map[string]interface{})
+	if len(updates) == 0 {
+		return logical.ErrorResponse("the features to set must be provided"), nil
+	}
+
+	b.featuresLock.Lock()
+	defer b.featuresLock.Unlock()
+
+	stored, err := getStoredFeatures(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range updates {
+		if _, ok := featureFlags[name]; !ok {
+			return logical.ErrorResponse(fmt.Sprintf("unknown feature flag %q; available flags are %s", name, strings.Join(featureFlagNames(), ", "))), nil
+		}
+		enabled, err := parseutil.ParseBool(value)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid value for feature flag %q: %s", name, err)), nil
+		}
+		stored[name] = enabled
+	}
+
+	entry, err := logical.StorageEntryJSON(featuresPath, stored)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+	b.cachedFeatures = nil
+	b.Logger().Info("updated feature flags", "features", updates)
+
+	features, err := b.loadFeatures(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return featuresResponse(features), nil
+}
+
+// featureEnabled reports whether the named feature is enabled.  The checks
+// for every feature flag go through here.
+func (b *keyfactorBackend) featureEnabled(ctx context.Context, s logical.Storage, name string) (bool, error) {
+	features, err := b.fetchFeatures(ctx, s)
+	if err != nil {
+		return false, err
+	}
+	return features[name], nil
+}
+
+// fetchFeatures returns the state of every feature flag, reading them from
+// storage if they are not cached.
+func (b *keyfactorBackend) fetchFeatures(ctx context.Context, s logical.Storage) (map[string]bool, error) {
+	b.featuresLock.RLock()
+	features := b.cachedFeatures
+	b.featuresLock.RUnlock()
+	if features != nil {
+		return features, nil
+	}
+
+	b.featuresLock.Lock()
+	defer b.featuresLock.Unlock()
+	return b.loadFeatures(ctx, s)
+}
+
+// loadFeatures reads the feature flags from storage, falling back to the
+// defaults for flags that have not been set, and caches them.  The caller
+// must hold featuresLock for writing.
+func (b *keyfactorBackend) loadFeatures(ctx context.Context, s logical.Storage) (map[string]bool, error) {
+	stored, err := getStoredFeatures(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	features := make(map[string]bool, len(featureFlags))
+	for name, flag := range featureFlags {
+		features[name] = flag.Default
+	}
+	for name, enabled := range stored {
+		if _, ok := featureFlags[name]; ok {
+			features[name] = enabled
+		}
+	}
+
+	// configurations written before feature flags enabled imports with a
+	// config field
+	if _, set := stored[featureAutoImportOnFetch]; !set {
+		config, err := b.fetchConfig(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		features[featureAutoImportOnFetch] = config != nil && config.AutoImportOnFetch
+	}
+
+	b.cachedFeatures = features
+	return features, nil
+}
+
+// getStoredFeatures returns the feature flags that have been set explicitly.
+func getStoredFeatures(ctx context.Context, s logical.Storage) (map[string]bool, error) {
+	stored := map[string]bool{}
+	entry, err := s.Get(ctx, featuresPath)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		if err := entry.DecodeJSON(&stored); err != nil {
+			return nil, err
+		}
+	}
+	return stored, nil
+}
+
+// featureFlagNames returns the names of the feature flags in sorted order.
+func featureFlagNames() []string {
+	names := make([]string, 0, len(featureFlags))
+	for name := range featureFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func featuresResponse(features map[string]bool) *logical.Response {
+	flags := make(map[string]interface{}, len(featureFlags))
+	for name, flag := range featureFlags {
+		flags[name] = map[string]interface{}{
+			"enabled":     features[name],
+			"default":     flag.Default,
+			"description": flag.Description,
+		}
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"features": flags,
+		},
+	}
+}
+
+const pathConfigFeaturesHelpSynopsis = `Read or set the feature flags of the backend.`
+
+const pathConfigFeaturesHelpDescription = `
+Reading this path lists every feature flag with whether it is enabled, its default and a
+description. Writing "features" as a map of flag names to true or false enables or disables
+those features; flags that are not included are left unchanged and unknown flags are rejected.
+
+The available flags are:
+
+	auto_renew - renew the expiring certificates of roles with auto_renew set.  Defaults to true.
+	auto_import_on_fetch - import certificates that are not stored locally from Keyfactor when
+	  they are read.  Until it is set here, the auto_import_on_fetch configuration field is used.
+	circuit_breaker - stop sending requests to Keyfactor after repeated failures.  Defaults to true.
+	expiry_events - send events for certificates approaching their expiry.  Defaults to true.
+`
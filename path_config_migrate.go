@@ -0,0 +1,249 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// currentConfigSchemaVersion is the schema version of configurations written
+// by this version of the plugin.  Configurations without a schema version
+// were written before versioning was introduced and are version 1.
+const currentConfigSchemaVersion = 3
+
+// configMigrations upgrade a configuration from the version it is keyed by to
+// the next version.
+var configMigrations = map[int]func(*keyfactorConfig){
+	1: migrateConfigV1ToV2,
+	2: migrateConfigV2ToV3,
+}
+
+// migrateConfigV1ToV2 normalizes the URL and fills in defaults for the fields
+// added after version 1.
+func migrateConfigV1ToV2(config *keyfactorConfig) {
+	config.KeyfactorUrl = strings.TrimRight(config.KeyfactorUrl, "/")
+	if config.CommandAPIPath == "" {
+		config.CommandAPIPath = "KeyfactorAPI"
+	}
+	if config.CircuitBreakerThreshold == 0 {
+		config.CircuitBreakerThreshold = defaultCircuitBreakerThreshold
+	}
+	if config.CircuitBreakerTimeout == 0 {
+		config.CircuitBreakerTimeout = defaultCircuitBreakerTimeout
+	}
+	if config.IssueTimeout == 0 {
+		config.IssueTimeout = defaultOperationTimeout
+	}
+	if config.SignTimeout == 0 {
+		config.SignTimeout = defaultOperationTimeout
+	}
+	if config.RevokeTimeout == 0 {
+		config.RevokeTimeout = defaultOperationTimeout
+	}
+	if config.TemplateCacheTTL == 0 {
+		config.TemplateCacheTTL = defaultTemplateCacheTTL
+	}
+	if config.EnrollmentFormat == "" {
+		config.EnrollmentFormat = enrollmentFormatJSON
+	}
+	if config.ListWorkers == 0 {
+		config.ListWorkers = defaultListWorkers
+	}
+	if config.CAChainCacheTTL == 0 {
+		config.CAChainCacheTTL = defaultCAChainCacheTTL
+	}
+}
+
+// migrateConfigV2ToV3 fills in the retry defaults, as 0 retries disables
+// retrying from version 3.
+func migrateConfigV2ToV3(config *keyfactorConfig) {
+	if config.EnrollmentMaxRetries == 0 {
+		config.EnrollmentMaxRetries = defaultEnrollmentMaxRetries
+	}
+	if config.RevokeMaxRetries == 0 {
+		config.RevokeMaxRetries = defaultRevokeMaxRetries
+	}
+}
+
+// migrate upgrades the configuration in place to the current schema version.
+func (c *keyfactorConfig) migrate() error {
+	for version := c.schemaVersion(); version < currentConfigSchemaVersion; version++ {
+		migrate, ok := configMigrations[version]
+		if !ok {
+			return fmt.Errorf("no migration defined from configuration schema version %d", version)
+		}
+		migrate(c)
+	}
+	c.SchemaVersion = currentConfigSchemaVersion
+	return nil
+}
+
+// schemaVersion returns the schema version of the configuration.
+func (c *keyfactorConfig) schemaVersion() int {
+	if c.SchemaVersion == 0 {
+		return 1
+	}
+	return c.SchemaVersion
+}
+
+func pathConfigMigrate(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `config/migrate`,
+			Fields: map[string]*framework.FieldSchema{
+				"dry_run": {
+					Type:        framework.TypeBool,
+					Description: "Set to true to return the changes the migration would make without writing them.",
+					Required:    false,
+					Default:     false,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathConfigMigrateWrite,
+			},
+			HelpSynopsis:    pathConfigMigrateHelpSynopsis,
+			HelpDescription: pathConfigMigrateHelpDescription,
+		},
+	}
+}
+
+// pathConfigMigrateWrite upgrades the stored configuration to the current schema version.
+func (b *keyfactorBackend) pathConfigMigrateWrite(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	dryRun := data.Get("dry_run").(bool)
+
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
+	entry, err := req.Storage.Get(ctx, configPath)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("no configuration to migrate"), nil
+	}
+
+	config := &keyfactorConfig{}
+	if err := entry.DecodeJSON(config); err != nil {
+		return nil, err
+	}
+
+	fromVersion := config.schemaVersion()
+	if fromVersion >= currentConfigSchemaVersion {
+		return logical.ErrorResponse(fmt.Sprintf("configuration is already at the latest schema version %d", currentConfigSchemaVersion)), nil
+	}
+
+	migrated := &keyfactorConfig{}
+	if err := entry.DecodeJSON(migrated); err != nil {
+		return nil, err
+	}
+	if err := migrated.migrate(); err != nil {
+		return nil, err
+	}
+
+	changes, err := configDiff(config, migrated)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dryRun {
+		newEntry, err := logical.StorageEntryJSON(configPath, migrated)
+		if err != nil {
+			return nil, err
+		}
+		if err := req.Storage.Put(ctx, newEntry); err != nil {
+			return nil, err
+		}
+
+		// drop the cached configuration and clients so the migrated values are used
+		b.cachedConfig = nil
+		b.client = nil
+		b.instanceClients = nil
+		b.Logger().Info("migrated configuration", "from_version", fromVersion, "to_version", currentConfigSchemaVersion)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"from_version": fromVersion,
+			"to_version":   currentConfigSchemaVersion,
+			"dry_run":      dryRun,
+			"changes":      changes,
+		},
+	}, nil
+}
+
+// configDiff returns the fields that differ between two configurations as a
+// map of field name to the old and new values.  Credentials are redacted.
+func configDiff(before *keyfactorConfig, after *keyfactorConfig) (map[string]interface{}, error) {
+	beforeFields, err := configFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := configFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]interface{}{}
+	for name, newValue := range afterFields {
+		oldValue := beforeFields[name]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		switch name {
+		case "password", "client_secret", "access_token":
+			oldValue = redactSensitive(fmt.Sprint(oldValue))
+			newValue = redactSensitive(fmt.Sprint(newValue))
+		}
+		changes[name] = map[string]interface{}{
+			"old": oldValue,
+			"new": newValue,
+		}
+	}
+	return changes, nil
+}
+
+// configFields returns the stored JSON fields of a configuration.
+func configFields(config *keyfactorConfig) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// pathConfigMigrateHelpSynopsis summarizes the help text for the configuration migration
+const pathConfigMigrateHelpSynopsis = `Upgrade the stored configuration to the latest schema version.`
+
+// pathConfigMigrateHelpDescription describes the help text for the configuration migration
+const pathConfigMigrateHelpDescription = `
+Reads the stored configuration, renames fields and fills in defaults for fields added in later
+versions of the plugin, and writes the result back. The response lists every changed field with
+its old and new value. Set dry_run=true to see the changes without writing them.
+
+Configurations that are already at the latest schema version are not migrated. Older
+configurations are also migrated in memory whenever they are read, and written at the latest
+schema version on the next configuration update, so this path only persists the migration early.
+`
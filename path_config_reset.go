@@ -0,0 +1,70 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathConfigReset(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `config/reset`,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathConfigResetWrite,
+			},
+			HelpSynopsis:    pathConfigResetHelpSynopsis,
+			HelpDescription: pathConfigResetHelpDescription,
+		},
+	}
+}
+
+// pathConfigResetWrite removes the configuration for the backend and returns
+// its in-memory state to that of a new mount.
+func (b *keyfactorBackend) pathConfigResetWrite(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	if err := req.Storage.Delete(ctx, configPath); err != nil {
+		return nil, err
+	}
+
+	b.reset()
+	b.expiryScanLock.Lock()
+	b.lastExpiryScan = time.Time{}
+	b.expiryScanLock.Unlock()
+	b.crlPushLock.Lock()
+	b.lastCRLFetch = time.Time{}
+	b.crlPushLock.Unlock()
+
+	b.Logger().Warn("deleted the configuration and reset the backend", "entity_id", req.EntityID)
+	return nil, nil
+}
+
+const pathConfigResetHelpSynopsis = `Reset the backend to factory defaults.`
+
+const pathConfigResetHelpDescription = `
+This deletes the configuration and returns the backend to the state of a new mount: the cached
+configuration, clients and feature flags are discarded, the circuit breaker is closed and the
+periodic expiry scan and CRL fetch are rescheduled. Stored certificates, roles and feature flags
+are kept. The reset is logged at WARN level with the entity ID of the caller.
+
+This path requires sudo, e.g.
+
+  path "keyfactor/config/reset" {
+    capabilities = ["update", "sudo"]
+  }
+`
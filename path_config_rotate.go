@@ -0,0 +1,163 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"crypto/subtle"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathConfigRotateCredentials(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `config/rotate-credentials`,
+			Fields: map[string]*framework.FieldSchema{
+				"password": {
+					Type:        framework.TypeString,
+					Description: "The new password for Basic auth.",
+					Required:    false,
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"old_password": {
+					Type:        framework.TypeString,
+					Description: "The current password, required with password.",
+					Required:    false,
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"client_secret": {
+					Type:        framework.TypeString,
+					Description: "The new OAuth client secret.",
+					Required:    false,
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"old_client_secret": {
+					Type:        framework.TypeString,
+					Description: "The current OAuth client secret, required with client_secret.",
+					Required:    false,
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathConfigRotateCredentialsWrite,
+			},
+			HelpSynopsis:    pathConfigRotateCredentialsHelpSynopsis,
+			HelpDescription: pathConfigRotateCredentialsHelpDescription,
+		},
+	}
+}
+
+// pathConfigRotateCredentialsWrite replaces the password or client secret of
+// the configuration after checking the current one and that Keyfactor
+// accepts the new one.
+func (b *keyfactorBackend) pathConfigRotateCredentialsWrite(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	password := data.Get("password").(string)
+	clientSecret := data.Get("client_secret").(string)
+	if (password == "") == (clientSecret == "") {
+		return logical.ErrorResponse("exactly one of password or client_secret must be provided"), nil
+	}
+
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
+	entry, err := req.Storage.Get(ctx, configPath)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("no configuration to rotate the credentials of"), nil
+	}
+	config := &keyfactorConfig{}
+	if err := entry.DecodeJSON(config); err != nil {
+		return nil, err
+	}
+	if err := config.migrate(); err != nil {
+		return nil, err
+	}
+
+	credential := "password"
+	if password != "" {
+		if config.Username == "" || config.Password == "" {
+			return logical.ErrorResponse("the configuration does not use Basic auth"), nil
+		}
+		if !credentialMatches(data.Get("old_password").(string), config.Password) {
+			return logical.ErrorResponse("old_password does not match the current password"), nil
+		}
+		config.Password = password
+	} else {
+		credential = "client_secret"
+		if config.ClientId == "" || config.ClientSecret == "" {
+			return logical.ErrorResponse("the configuration does not use an OAuth client secret"), nil
+		}
+		if !credentialMatches(data.Get("old_client_secret").(string), config.ClientSecret) {
+			return logical.ErrorResponse("old_client_secret does not match the current client secret"), nil
+		}
+		config.ClientSecret = clientSecret
+	}
+
+	// check that Keyfactor accepts the new credential before storing it
+	client, err := newClient(config, b)
+	if err != nil {
+		return logical.ErrorResponse("unable to create a client with the new " + credential + ": " + err.Error()), nil
+	}
+	version := &keyfactorVersion{}
+	if err := b.getKeyfactorJSON(ctx, req.Storage, client, config, "/Status/Version", version); err != nil {
+		return keyfactorErrorResponse("Keyfactor did not accept the new "+credential, err)
+	}
+
+	newEntry, err := logical.StorageEntryJSON(configPath, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, newEntry); err != nil {
+		return nil, err
+	}
+
+	// drop the cached configuration and clients so the new credential is used
+	b.cachedConfig = nil
+	b.client = nil
+	b.instanceClients = nil
+	b.Logger().Warn("rotated Keyfactor credential", "credential", credential, "entity_id", req.EntityID)
+
+	return nil, nil
+}
+
+// credentialMatches compares a provided credential with the stored one in
+// constant time.
+func credentialMatches(provided string, stored string) bool {
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(stored)) == 1
+}
+
+const pathConfigRotateCredentialsHelpSynopsis = `Rotate the Keyfactor password or OAuth client secret.`
+
+const pathConfigRotateCredentialsHelpDescription = `
+This replaces a single credential of the configuration without rewriting the other settings.
+Provide either "password" with the current "old_password", or "client_secret" with the current
+"old_client_secret". The current credential must match the stored one, and the new credential
+is checked by requesting the Keyfactor version with it before it is stored. The cached client is
+then discarded so that subsequent requests authenticate with the new credential.
+
+Credentials of the additional instances in keyfactor_instances are not changed.
+`
@@ -0,0 +1,77 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathEntityCerts(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `certs/by-entity/` + framework.GenericNameRegex("entity_id") + `/?$`,
+			Fields: map[string]*framework.FieldSchema{
+				"entity_id": {
+					Type:        framework.TypeString,
+					Description: `The ID of the Vault entity.`,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.pathEntityCertsList,
+			},
+
+			HelpSynopsis:    pathEntityCertsHelpSyn,
+			HelpDescription: pathEntityCertsHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathEntityCertsList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entityId := data.Get("entity_id").(string)
+	if entityId == "" {
+		return logical.ErrorResponse("the entity_id must be provided"), nil
+	}
+
+	serials, err := req.Storage.List(ctx, entityCertsStoragePrefix+entityId+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := make(map[string]interface{}, len(serials))
+	for _, serial := range serials {
+		revokedEntry, err := req.Storage.Get(ctx, "revoked/"+serial)
+		if err != nil {
+			return nil, err
+		}
+		keyInfo[serial] = map[string]interface{}{
+			"revoked": revokedEntry != nil,
+		}
+	}
+
+	resp := logical.ListResponseWithInfo(serials, keyInfo)
+	resp.Data["count"] = len(serials)
+	return resp, nil
+}
+
+const pathEntityCertsHelpSyn = `
+List the certificates issued by a Vault entity.
+`
+
+const pathEntityCertsHelpDesc = `
+This lists the serial numbers of the certificates issued by requests of the given Vault entity,
+read from an index that is updated on every issuance, for auditing which user or service
+account requested each certificate. The entity of a certificate is also returned as
+"entity_id" when it is read. Certificates issued before the index was introduced, or by
+requests without an entity such as those made with the root token, are not listed.
+`
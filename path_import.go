@@ -0,0 +1,165 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// thumbprintStoragePrefix maps the SHA-1 thumbprints Keyfactor identifies
+// certificates by to their serial numbers.
+const thumbprintStoragePrefix = "thumbprints/"
+
+// importBatchSize is the number of rows imported between progress reports.
+const importBatchSize = 100
+
+func pathImport(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "import/bulk",
+			Fields: map[string]*framework.FieldSchema{
+				"csv": {
+					Type:        framework.TypeString,
+					Description: "A base64-encoded CSV file with the columns serial, keyfactor_id and pem_b64, the base64-encoded PEM certificate. A header row is skipped.",
+					Required:    true,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathImportBulkWrite,
+			},
+
+			HelpSynopsis:    pathImportBulkHelpSyn,
+			HelpDescription: pathImportBulkHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathImportBulkWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	csvBytes, err := base64.StdEncoding.DecodeString(data.Get("csv").(string))
+	if err != nil {
+		return logical.ErrorResponse("csv must be base64-encoded: %s", err), nil
+	}
+	reader := csv.NewReader(bytes.NewReader(csvBytes))
+	// rows with the wrong number of columns are reported individually
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return logical.ErrorResponse("unable to parse csv: %s", err), nil
+	}
+	if len(rows) > 0 && strings.EqualFold(strings.TrimSpace(rows[0][0]), "serial") {
+		rows = rows[1:]
+	}
+
+	resp := &logical.Response{}
+	imported, skipped, failed := 0, 0, 0
+	for i, row := range rows {
+		stored, err := b.importCSVRow(ctx, req.Storage, row)
+		switch {
+		case err != nil:
+			failed++
+			resp.AddWarning(fmt.Sprintf("row %d: %s", i+1, err))
+		case stored:
+			imported++
+		default:
+			skipped++
+		}
+
+		if (i+1)%importBatchSize == 0 {
+			b.Logger().Info("bulk import progress", "processed", i+1, "total", len(rows), "imported", imported, "skipped", skipped, "errors", failed)
+		}
+	}
+	b.Logger().Info("bulk import finished", "imported", imported, "skipped", skipped, "errors", failed)
+
+	resp.Data = map[string]interface{}{
+		"imported": imported,
+		"skipped":  skipped,
+		"errors":   failed,
+	}
+	return resp, nil
+}
+
+// importCSVRow stores the certificate of a serial,keyfactor_id,pem_b64 row
+// with its Keyfactor ID and thumbprint.  It returns false without storing
+// anything if the certificate is already stored.
+func (b *keyfactorBackend) importCSVRow(ctx context.Context, s logical.Storage, row []string) (bool, error) {
+	if len(row) != 3 {
+		return false, fmt.Errorf("expected 3 columns, found %d", len(row))
+	}
+	serial := normalizeSerial(strings.TrimSpace(row[0]))
+	kfId, err := strconv.Atoi(strings.TrimSpace(row[1]))
+	if err != nil {
+		return false, fmt.Errorf("invalid keyfactor_id %q", row[1])
+	}
+	certPEM, err := base64.StdEncoding.DecodeString(strings.TrimSpace(row[2]))
+	if err != nil {
+		return false, fmt.Errorf("pem_b64 is not base64-encoded: %w", err)
+	}
+	cert, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return false, err
+	}
+	want, ok := new(big.Int).SetString(strings.NewReplacer("-", "", ":", "").Replace(serial), 16)
+	if !ok || cert.SerialNumber.Cmp(want) != 0 {
+		return false, fmt.Errorf("serial %s does not match the certificate serial %x", serial, cert.SerialNumber)
+	}
+
+	existing, err := s.Get(ctx, "certs/"+serial)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		return false, nil
+	}
+
+	err = b.storeIssuedCert(ctx, s, serial, string(certPEM), kfId, 0, &certInfo{
+		IssuedAt: cert.NotBefore,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	thumbprint := sha1.Sum(cert.Raw)
+	entry, err := logical.StorageEntryJSON(thumbprintStoragePrefix+strings.ToUpper(hex.EncodeToString(thumbprint[:])), serial)
+	if err != nil {
+		return false, err
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+const pathImportBulkHelpSyn = `
+Register certificates already managed in Keyfactor with this backend.
+`
+
+const pathImportBulkHelpDesc = `
+This imports certificates issued outside of Vault so that they can be read, revoked and renewed
+through this backend. "csv" is a base64-encoded CSV file with one certificate per row in the
+columns serial, keyfactor_id and pem_b64, where keyfactor_id is the Keyfactor certificate ID and
+pem_b64 is the base64-encoded PEM certificate. The serial must match the certificate.
+
+Certificates that are already stored are skipped. The response holds the number of certificates
+"imported", "skipped" and with "errors", and a warning describing each row that failed. Progress
+is logged every 100 rows. This path requires sudo capability.
+`
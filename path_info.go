@@ -0,0 +1,156 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// keyfactorAPIVersion is the version of the Keyfactor API this backend sends
+// in the x-keyfactor-api-version header.
+const keyfactorAPIVersion = "1"
+
+// The range of Keyfactor versions this backend is known to work with.  The
+// maximum is exclusive.
+var (
+	minCompatibleKeyfactorVersion = [2]int{9, 6}
+	maxCompatibleKeyfactorVersion = [2]int{26, 0}
+)
+
+// versionCheckTimeout bounds the version lookup made during initialization.
+const versionCheckTimeout = 10 * time.Second
+
+// keyfactorVersion is the response of the Keyfactor version endpoint.
+type keyfactorVersion struct {
+	Version string `json:"Version"`
+	Edition string `json:"Edition"`
+}
+
+func pathInfo(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `info`,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathInfoRead,
+			},
+
+			HelpSynopsis:    pathInfoHelpSyn,
+			HelpDescription: pathInfoHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathInfoRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	version, err := b.getKeyfactorVersion(ctx, req.Storage)
+	if err != nil {
+		return keyfactorErrorResponse("error getting the Keyfactor version", err)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"keyfactor_version": version.Version,
+			"keyfactor_edition": version.Edition,
+			"api_version":       keyfactorAPIVersion,
+			"compatible":        isCompatibleKeyfactorVersion(version.Version),
+		},
+	}, nil
+}
+
+// getKeyfactorVersion asks Keyfactor for its version.
+func (b *keyfactorBackend) getKeyfactorVersion(ctx context.Context, s logical.Storage) (*keyfactorVersion, error) {
+	config, err := b.fetchConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, fmt.Errorf("configuration is empty")
+	}
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	version := &keyfactorVersion{}
+	if err := b.getKeyfactorJSON(ctx, s, client, config, "/Status/Version", version); err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// logKeyfactorVersion logs the version of the configured Keyfactor instance.
+// Nothing is logged when the backend has not been configured yet.
+func (b *keyfactorBackend) logKeyfactorVersion(ctx context.Context, s logical.Storage) {
+	config, err := b.fetchConfig(ctx, s)
+	if err != nil || config == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, versionCheckTimeout)
+	defer cancel()
+	version, err := b.getKeyfactorVersion(ctx, s)
+	if err != nil {
+		b.Logger().Warn("unable to get the Keyfactor version", "error", err)
+		return
+	}
+	b.Logger().Info("connected to Keyfactor", "version", version.Version, "edition", version.Edition, "compatible", isCompatibleKeyfactorVersion(version.Version))
+}
+
+// parseKeyfactorVersion returns the major and minor parts of a version string
+// such as "10.4.1.0".
+func parseKeyfactorVersion(version string) ([2]int, error) {
+	var parsed [2]int
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(version), "v"), ".")
+	for i := 0; i < len(parsed); i++ {
+		if i >= len(parts) {
+			break
+		}
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return parsed, fmt.Errorf("invalid Keyfactor version %q", version)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
+
+// isCompatibleKeyfactorVersion reports whether the version is within the
+// range this backend is known to work with.
+func isCompatibleKeyfactorVersion(version string) bool {
+	parsed, err := parseKeyfactorVersion(version)
+	if err != nil {
+		return false
+	}
+	return !versionLess(parsed, minCompatibleKeyfactorVersion) && versionLess(parsed, maxCompatibleKeyfactorVersion)
+}
+
+func versionLess(a [2]int, b [2]int) bool {
+	if a[0] != b[0] {
+		return a[0] < b[0]
+	}
+	return a[1] < b[1]
+}
+
+const pathInfoHelpSyn = `
+Return the version of the configured Keyfactor instance.
+`
+
+const pathInfoHelpDesc = `
+This returns the version and edition reported by Keyfactor, the Keyfactor API version used by this
+backend, and whether the Keyfactor version is in the range this backend is known to be compatible with
+(9.6 and later).
+`
@@ -0,0 +1,260 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspResponseValidity is how long relying parties may cache an OCSP response.
+const ocspResponseValidity = time.Hour
+
+const ocspResponseContentType = "application/ocsp-response"
+
+func pathOCSP(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "ocsp",
+			Fields: map[string]*framework.FieldSchema{
+				"request": {
+					Type:        framework.TypeString,
+					Description: "The base64-encoded DER OCSP request.",
+					Required:    true,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathOCSPWrite,
+			},
+
+			HelpSynopsis:    pathOCSPHelpSyn,
+			HelpDescription: pathOCSPHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathOCSPWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	der, err := base64.StdEncoding.DecodeString(data.Get("request").(string))
+	if err != nil {
+		return ocspRawResponse(ocsp.MalformedRequestErrorResponse), nil
+	}
+	ocspReq, err := ocsp.ParseRequest(der)
+	if err != nil {
+		return ocspRawResponse(ocsp.MalformedRequestErrorResponse), nil
+	}
+
+	config, err := b.fetchConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || config.OCSPSigningCertPEM == "" {
+		return ocspRawResponse(ocsp.UnauthorizedErrorResponse), nil
+	}
+	signingCert, signingKey, err := parseOCSPSigner(config.OCSPSigningCertPEM, config.OCSPSigningKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := b.ocspIssuer(ctx, req, signingCert)
+	if err != nil {
+		return nil, err
+	}
+	if issuer == nil {
+		b.Logger().Warn("unable to find the CA that issued the OCSP signing certificate")
+		return ocspRawResponse(ocsp.InternalErrorErrorResponse), nil
+	}
+	if !issuerMatchesRequest(issuer, ocspReq) {
+		return ocspRawResponse(ocsp.UnauthorizedErrorResponse), nil
+	}
+
+	now := time.Now()
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: ocspReq.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(ocspResponseValidity),
+	}
+	serial, certEntry, err := getCertBySerialNumber(ctx, req.Storage, ocspReq.SerialNumber.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if certEntry != nil {
+		if cert, err := parseCertificatePEM(certEntry.Value); err == nil && isIssuerOf(issuer, cert) {
+			template.Status = ocsp.Good
+			for _, prefix := range []string{"revoked/", "suspended/"} {
+				revEntry, err := req.Storage.Get(ctx, prefix+serial)
+				if err != nil {
+					return nil, err
+				}
+				if revEntry == nil {
+					continue
+				}
+				var revInfo revocationInfo
+				if err := revEntry.DecodeJSON(&revInfo); err != nil {
+					return nil, err
+				}
+				template.Status = ocsp.Revoked
+				template.RevokedAt = time.Unix(revInfo.RevocationTime, 0).UTC()
+				template.RevocationReason = revInfo.RevocationReason
+				break
+			}
+		}
+	}
+
+	response, err := ocsp.CreateResponse(issuer, signingCert, template, signingKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create OCSP response: %w", err)
+	}
+	return ocspRawResponse(response), nil
+}
+
+// ocspIssuer returns the CA the OCSP responses are for: the signing
+// certificate itself if it is a CA certificate, otherwise the CA in the
+// stored chain that issued it.
+func (b *keyfactorBackend) ocspIssuer(ctx context.Context, req *logical.Request, signingCert *x509.Certificate) (*x509.Certificate, error) {
+	if signingCert.IsCA {
+		return signingCert, nil
+	}
+	chain, err := b.getCAChain(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, ca := range chain {
+		if isIssuerOf(ca, signingCert) {
+			return ca, nil
+		}
+	}
+	return nil, nil
+}
+
+// issuerMatchesRequest reports whether the OCSP request asks about a
+// certificate issued by the CA.
+func issuerMatchesRequest(issuer *x509.Certificate, ocspReq *ocsp.Request) bool {
+	if !ocspReq.HashAlgorithm.Available() {
+		return false
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+
+	keyHash := ocspReq.HashAlgorithm.New()
+	keyHash.Write(spki.PublicKey.RightAlign())
+	nameHash := ocspReq.HashAlgorithm.New()
+	nameHash.Write(issuer.RawSubject)
+	return bytes.Equal(keyHash.Sum(nil), ocspReq.IssuerKeyHash) && bytes.Equal(nameHash.Sum(nil), ocspReq.IssuerNameHash)
+}
+
+// getCertBySerialNumber returns the storage key and entry of the stored
+// certificate with the serial number, which may be stored as plain hex, as
+// Keyfactor reports serials, or as hyphen-separated hex.
+func getCertBySerialNumber(ctx context.Context, s logical.Storage, serialNumber []byte) (string, *logical.StorageEntry, error) {
+	plain := hex.EncodeToString(serialNumber)
+	octets := make([]string, len(serialNumber))
+	for i := range serialNumber {
+		octets[i] = plain[2*i : 2*i+2]
+	}
+	for _, serial := range []string{plain, strings.Join(octets, "-")} {
+		entry, err := s.Get(ctx, "certs/"+serial)
+		if err != nil {
+			return "", nil, err
+		}
+		if entry != nil {
+			return serial, entry, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// parseOCSPSigner parses the OCSP signing certificate and its PKCS #1, SEC 1
+// or PKCS #8 private key, and checks that they belong together.
+func parseOCSPSigner(certPEM string, keyPEM string) (*x509.Certificate, crypto.Signer, error) {
+	if certPEM == "" || keyPEM == "" {
+		return nil, nil, errors.New("ocsp_signing_cert_pem and ocsp_signing_key_pem must be set together")
+	}
+	cert, err := parseCertificatePEM([]byte(certPEM))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ocsp_signing_cert_pem: %w", err)
+	}
+
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, nil, errors.New("ocsp_signing_key_pem must be PEM-encoded")
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, nil, fmt.Errorf("unsupported PEM block type %q in ocsp_signing_key_pem", block.Type)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ocsp_signing_key_pem: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("ocsp_signing_key_pem is not a signing key")
+	}
+	public, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(cert.PublicKey) {
+		return nil, nil, errors.New("ocsp_signing_key_pem does not match ocsp_signing_cert_pem")
+	}
+	return cert, signer, nil
+}
+
+// ocspRawResponse returns a DER OCSP response as the raw HTTP body.
+func ocspRawResponse(der []byte) *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: ocspResponseContentType,
+			logical.HTTPRawBody:     der,
+			logical.HTTPStatusCode:  http.StatusOK,
+		},
+	}
+}
+
+const pathOCSPHelpSyn = `
+Answer OCSP requests from the revocation state stored by this backend.
+`
+
+const pathOCSPHelpDesc = `
+This answers a base64-encoded DER OCSP "request" with a DER OCSP response, returned as the raw
+body with the content type application/ocsp-response. Certificates stored by this backend are
+reported as good, or as revoked when they are revoked or suspended; other serial numbers are
+reported as unknown. The state is read from local storage, so Keyfactor is not contacted.
+
+Responses are signed with ocsp_signing_cert_pem and ocsp_signing_key_pem from the configuration,
+which may be the CA certificate or a delegated OCSP responder certificate issued by the CA.
+Requests for certificates of other CAs receive an "unauthorized" response. The path does not
+require authentication.
+`
@@ -0,0 +1,202 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// profilePolicyPrefix is the storage prefix of the profile policies, keyed by
+// Keyfactor certificate profile (template) name.
+const profilePolicyPrefix = "profile-policy/"
+
+// profilePolicy lists the Vault policies and identity groups allowed to
+// enroll certificates with a Keyfactor certificate profile.
+type profilePolicy struct {
+	Policies []string `json:"policies"`
+	Groups   []string `json:"groups"`
+}
+
+func pathProfilePolicies(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "profile-policies/" + framework.GenericNameRegex("profile_name"),
+			Fields: map[string]*framework.FieldSchema{
+				"profile_name": {
+					Type:        framework.TypeString,
+					Description: "The name of the Keyfactor certificate profile (template).",
+				},
+				"policies": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Vault token policies, any of which allows the caller to use the profile.",
+				},
+				"groups": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: "Vault identity group names, membership of any of which allows the caller to use the profile.",
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   b.pathProfilePolicyRead,
+				logical.UpdateOperation: b.pathProfilePolicyWrite,
+				logical.DeleteOperation: b.pathProfilePolicyDelete,
+			},
+
+			HelpSynopsis:    pathProfilePolicyHelpSyn,
+			HelpDescription: pathProfilePolicyHelpDesc,
+		},
+		{
+			Pattern: "profile-policies/?$",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.pathProfilePolicyList,
+			},
+
+			HelpSynopsis:    pathProfilePolicyHelpSyn,
+			HelpDescription: pathProfilePolicyHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) getProfilePolicy(ctx context.Context, s logical.Storage, profileName string) (*profilePolicy, error) {
+	entry, err := s.Get(ctx, profilePolicyPrefix+profileName)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result profilePolicy
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (b *keyfactorBackend) pathProfilePolicyRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	policy, err := b.getProfilePolicy(ctx, req.Storage, data.Get("profile_name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"policies": policy.Policies,
+			"groups":   policy.Groups,
+		},
+	}, nil
+}
+
+func (b *keyfactorBackend) pathProfilePolicyWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	policy := &profilePolicy{
+		Policies: data.Get("policies").([]string),
+		Groups:   data.Get("groups").([]string),
+	}
+	if len(policy.Policies) == 0 && len(policy.Groups) == 0 {
+		return logical.ErrorResponse("at least one of policies or groups must be provided"), nil
+	}
+
+	entry, err := logical.StorageEntryJSON(profilePolicyPrefix+data.Get("profile_name").(string), policy)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *keyfactorBackend) pathProfilePolicyDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := req.Storage.Delete(ctx, profilePolicyPrefix+data.Get("profile_name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *keyfactorBackend) pathProfilePolicyList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, profilePolicyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// checkProfilePolicy verifies that the caller may enroll with the given
+// certificate profile.  Profiles without a policy are open to every caller.
+// Token policies are only available when Vault passes the token entry to
+// the plugin, so identity groups should be used with external plugins.
+func (b *keyfactorBackend) checkProfilePolicy(ctx context.Context, req *logical.Request, profileName string) error {
+	if profileName == "" {
+		return nil
+	}
+	policy, err := b.getProfilePolicy(ctx, req.Storage, profileName)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return nil
+	}
+
+	if te := req.TokenEntry(); te != nil {
+		for _, held := range te.Policies {
+			if held == "root" {
+				return nil
+			}
+			for _, required := range policy.Policies {
+				if held == required {
+					return nil
+				}
+			}
+		}
+	}
+
+	if req.EntityID != "" && len(policy.Groups) != 0 {
+		groups, err := b.System().GroupsForEntity(req.EntityID)
+		if err != nil {
+			return fmt.Errorf("unable to look up the groups of entity %s: %w", req.EntityID, err)
+		}
+		for _, group := range groups {
+			for _, required := range policy.Groups {
+				if group.Name == required {
+					return nil
+				}
+			}
+		}
+	}
+
+	b.Logger().Warn("caller is not permitted to use certificate profile", "profile", profileName, "entity_id", req.EntityID)
+	return fmt.Errorf("%w: the caller is not permitted to use certificate profile %q", logical.ErrPermissionDenied, profileName)
+}
+
+const pathProfilePolicyHelpSyn = `
+Manage the callers allowed to use a Keyfactor certificate profile.
+`
+
+const pathProfilePolicyHelpDesc = `
+This path restricts a Keyfactor certificate profile (template) to callers holding one of the given
+Vault policies or belonging to one of the given identity groups. When a certificate is enrolled with
+a profile that has a policy, callers without a matching policy or group are denied. Profiles without
+a policy can be used by any caller that can reach the issue or sign paths.
+
+Token policies are only visible to the plugin when Vault provides the token entry with the request;
+external plugins should use identity groups.
+`
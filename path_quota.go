@@ -0,0 +1,296 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/errutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// issuedTodayStoragePrefix holds the number of certificates issued by each
+// role on the current UTC day, keyed by role name.
+const issuedTodayStoragePrefix = "issued_today/"
+
+// roleQuotaStoragePrefix holds the issuance quota counter of each role,
+// keyed by role name.
+const roleQuotaStoragePrefix = "quotas/"
+
+// defaultQuotaPeriod is used for roles with quota_max_issuances set and no quota_period.
+const defaultQuotaPeriod = 24 * time.Hour
+
+// roleQuotaEntry counts the certificates issued by a role in the current
+// quota period.
+type roleQuotaEntry struct {
+	Count   int       `json:"count"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// issuedTodayEntry counts the certificates issued by a role on one day.
+type issuedTodayEntry struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+func pathQuota(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `quota`,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathQuotaRead,
+			},
+
+			HelpSynopsis:    pathQuotaHelpSyn,
+			HelpDescription: pathQuotaHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathQuotaRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.fetchConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("could not load configuration"), nil
+	}
+
+	serials, err := req.Storage.List(ctx, "certs/")
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := req.Storage.List(ctx, "role/")
+	if err != nil {
+		return nil, err
+	}
+	rateLimits := make(map[string]interface{}, len(roles))
+	issuedToday := make(map[string]interface{}, len(roles))
+	for _, name := range roles {
+		role, err := b.getRole(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && role.QuotaMaxIssuances > 0 {
+			used, resetAt, err := roleQuotaUsage(ctx, req.Storage, name, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			limit := map[string]interface{}{
+				"max_issuances": role.QuotaMaxIssuances,
+				"period":        int64(role.quotaPeriod().Seconds()),
+				"used":          used,
+			}
+			if !resetAt.IsZero() {
+				limit["reset_at"] = resetAt.Format(time.RFC3339)
+			}
+			rateLimits[name] = limit
+		}
+		count, err := issuedTodayCount(ctx, req.Storage, name, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		issuedToday[name] = count
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"max_certs_stored":         config.MaxCertsStored,
+			"current_certs_stored":     len(serials),
+			"max_concurrent_issuances": config.MaxConcurrentIssuances,
+			"active_issuances":         atomic.LoadInt64(&b.activeIssuances),
+			"rate_limit_per_role":      rateLimits,
+			"certs_issued_today":       issuedToday,
+		},
+	}, nil
+}
+
+// acquireIssuance reserves capacity for an enrollment, enforcing the
+// configured max_certs_stored and max_concurrent_issuances.  The returned
+// function releases the reservation.
+func (b *keyfactorBackend) acquireIssuance(ctx context.Context, s logical.Storage, config *keyfactorConfig) (func(), error) {
+	if config.MaxCertsStored > 0 {
+		serials, err := s.List(ctx, "certs/")
+		if err != nil {
+			return nil, err
+		}
+		if len(serials) >= config.MaxCertsStored {
+			return nil, errutil.UserError{Err: fmt.Sprintf("the maximum of %d stored certificates has been reached", config.MaxCertsStored)}
+		}
+	}
+
+	active := atomic.AddInt64(&b.activeIssuances, 1)
+	release := func() { atomic.AddInt64(&b.activeIssuances, -1) }
+	if config.MaxConcurrentIssuances > 0 && active > int64(config.MaxConcurrentIssuances) {
+		release()
+		return nil, errutil.UserError{Err: fmt.Sprintf("the maximum of %d concurrent issuances has been reached; retry later", config.MaxConcurrentIssuances)}
+	}
+	return release, nil
+}
+
+// consumeRoleQuota counts an issuance against the quota of the role, and
+// returns a user error if the quota for the current period is exhausted.
+// Plugin storage has no compare-and-swap, so the read-modify-write of the
+// counter is serialized by quotaLock.
+func (b *keyfactorBackend) consumeRoleQuota(ctx context.Context, s logical.Storage, roleName string, role *roleEntry) error {
+	if role.QuotaMaxIssuances <= 0 {
+		return nil
+	}
+	b.quotaLock.Lock()
+	defer b.quotaLock.Unlock()
+
+	now := time.Now()
+	used, resetAt, err := roleQuotaUsage(ctx, s, roleName, now)
+	if err != nil {
+		return err
+	}
+	if used >= role.QuotaMaxIssuances {
+		return errutil.UserError{Err: fmt.Sprintf("quota exceeded: role %s may issue %d certificates per %s; the quota resets at %s", roleName, role.QuotaMaxIssuances, role.quotaPeriod(), resetAt.Format(time.RFC3339))}
+	}
+	if resetAt.IsZero() {
+		resetAt = now.Add(role.quotaPeriod())
+	}
+	return putRoleQuota(ctx, s, roleName, &roleQuotaEntry{Count: used + 1, ResetAt: resetAt})
+}
+
+// refundRoleQuota returns an issuance counted by consumeRoleQuota when the
+// certificate could not be issued.
+func (b *keyfactorBackend) refundRoleQuota(ctx context.Context, s logical.Storage, roleName string, role *roleEntry) error {
+	if role.QuotaMaxIssuances <= 0 {
+		return nil
+	}
+	b.quotaLock.Lock()
+	defer b.quotaLock.Unlock()
+
+	used, resetAt, err := roleQuotaUsage(ctx, s, roleName, time.Now())
+	if err != nil || used == 0 {
+		return err
+	}
+	return putRoleQuota(ctx, s, roleName, &roleQuotaEntry{Count: used - 1, ResetAt: resetAt})
+}
+
+// roleQuotaUsage returns the issuances counted against the quota of the role
+// in the current period and when the period ends.  Both are zero if no period
+// is in progress.
+func roleQuotaUsage(ctx context.Context, s logical.Storage, roleName string, now time.Time) (int, time.Time, error) {
+	entry, err := s.Get(ctx, roleQuotaStoragePrefix+roleName)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if entry == nil {
+		return 0, time.Time{}, nil
+	}
+	var quota roleQuotaEntry
+	if err := entry.DecodeJSON(&quota); err != nil {
+		return 0, time.Time{}, err
+	}
+	if !now.Before(quota.ResetAt) {
+		return 0, time.Time{}, nil
+	}
+	return quota.Count, quota.ResetAt, nil
+}
+
+func putRoleQuota(ctx context.Context, s logical.Storage, roleName string, quota *roleQuotaEntry) error {
+	entry, err := logical.StorageEntryJSON(roleQuotaStoragePrefix+roleName, quota)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// countIssuance increments the number of certificates the role issued today.
+func (b *keyfactorBackend) countIssuance(ctx context.Context, s logical.Storage, roleName string) error {
+	if roleName == "" {
+		return nil
+	}
+	b.issuedTodayLock.Lock()
+	defer b.issuedTodayLock.Unlock()
+
+	now := time.Now()
+	count, err := issuedTodayCount(ctx, s, roleName, now)
+	if err != nil {
+		return err
+	}
+	entry, err := logical.StorageEntryJSON(issuedTodayStoragePrefix+roleName, &issuedTodayEntry{
+		Date:  now.UTC().Format(time.DateOnly),
+		Count: count + 1,
+	})
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// issuedTodayCount returns the number of certificates the role issued on the
+// UTC day of now.
+func issuedTodayCount(ctx context.Context, s logical.Storage, roleName string, now time.Time) (int, error) {
+	entry, err := s.Get(ctx, issuedTodayStoragePrefix+roleName)
+	if err != nil {
+		return 0, err
+	}
+	if entry == nil {
+		return 0, nil
+	}
+	var counter issuedTodayEntry
+	if err := entry.DecodeJSON(&counter); err != nil {
+		return 0, err
+	}
+	if counter.Date != now.UTC().Format(time.DateOnly) {
+		return 0, nil
+	}
+	return counter.Count, nil
+}
+
+// resetIssuedTodayCounters deletes the daily issuance counters of earlier days.
+func (b *keyfactorBackend) resetIssuedTodayCounters(ctx context.Context, s logical.Storage) error {
+	b.issuedTodayLock.Lock()
+	defer b.issuedTodayLock.Unlock()
+
+	roles, err := s.List(ctx, issuedTodayStoragePrefix)
+	if err != nil {
+		return err
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	for _, name := range roles {
+		entry, err := s.Get(ctx, issuedTodayStoragePrefix+name)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+		var counter issuedTodayEntry
+		if err := entry.DecodeJSON(&counter); err == nil && counter.Date == today {
+			continue
+		}
+		if err := s.Delete(ctx, issuedTodayStoragePrefix+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const pathQuotaHelpSyn = `
+Read the resource limits of the backend and their current usage.
+`
+
+const pathQuotaHelpDesc = `
+This returns the configured "max_certs_stored" and "max_concurrent_issuances" (0 means unlimited)
+with the number of certificates currently stored and the number of enrollments in progress on
+this node, the issuance quota of each role that has one with its usage in the current period,
+and the number of certificates each role issued on the current UTC day. The daily counters are
+reset by the periodic function.
+`
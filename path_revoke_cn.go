@@ -0,0 +1,327 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// revocationReasonCessationOfOperation is sent when the certificates of a
+// deleted role are revoked.
+const revocationReasonCessationOfOperation = 5
+
+// revocationReasons maps the accepted reason names to RFC 5280 CRLReason codes.
+var revocationReasons = map[string]int{
+	"unspecified":            revocationReasonUnspecified,
+	"key_compromise":         1,
+	"ca_compromise":          2,
+	"affiliation_changed":    3,
+	"superseded":             4,
+	"cessation_of_operation": revocationReasonCessationOfOperation,
+}
+
+// revocationReasonName returns the name of an RFC 5280 CRLReason code.
+func revocationReasonName(reason int) string {
+	if reason == revocationReasonCertificateHold {
+		return "certificate_hold"
+	}
+	for name, code := range revocationReasons {
+		if code == reason {
+			return name
+		}
+	}
+	return strconv.Itoa(reason)
+}
+
+// keyfactorRevokeRequest is the body of a Keyfactor revocation request.
+type keyfactorRevokeRequest struct {
+	CertificateIds []int  `json:"CertificateIds"`
+	Reason         int    `json:"Reason"`
+	Comment        string `json:"Comment"`
+	EffectiveDate  string `json:"EffectiveDate"`
+	CollectionId   int    `json:"CollectionId"`
+}
+
+// revokeCandidate is a stored certificate selected for revocation.
+type revokeCandidate struct {
+	serial      string
+	keyfactorId int
+	certBytes   []byte
+}
+
+func pathRevokeCN(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `revoke-cn`,
+			Fields: map[string]*framework.FieldSchema{
+				"common_name": {
+					Type:        framework.TypeString,
+					Description: `The common name of the certificates to revoke.`,
+					Required:    true,
+				},
+				"reason": {
+					Type:        framework.TypeString,
+					Description: `The revocation reason: unspecified, key_compromise, ca_compromise, affiliation_changed, superseded or cessation_of_operation.`,
+					Default:     "unspecified",
+				},
+				"timeout": {
+					Type:        framework.TypeDurationSecond,
+					Description: `The maximum time to wait for Keyfactor, capped at the configured revoke_timeout.`,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathRevokeCN,
+			},
+
+			HelpSynopsis:    pathRevokeCNHelpSyn,
+			HelpDescription: pathRevokeCNHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathRevokeCN(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	commonName := data.Get("common_name").(string)
+	if commonName == "" {
+		return logical.ErrorResponse("the common_name must be provided"), nil
+	}
+	reason, ok := revocationReasons[data.Get("reason").(string)]
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("unknown revocation reason %q", data.Get("reason").(string))), nil
+	}
+
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+
+	timeout, err := b.resolveOperationTimeout(ctx, req.Storage, operationRevoke, data)
+	if err != nil {
+		return nil, err
+	}
+	ctx = withOperationTimeout(ctx, timeout)
+
+	byInstance, errs, err := b.collectRevokeCandidates(ctx, req, func(serial string, cert *x509.Certificate) (bool, error) {
+		return strings.EqualFold(cert.Subject.CommonName, commonName), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := b.revokeCandidates(ctx, req, byInstance, reason, timeout, errs)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info("revoked certificates by common name", "common_name", commonName, "revoked", len(revoked), "errors", len(errs))
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"revoked_count":   len(revoked),
+			"revoked_serials": revoked,
+			"errors":          errs,
+		},
+	}, nil
+}
+
+// collectRevokeCandidates returns the stored, non-revoked certificates
+// selected by match, grouped by the Keyfactor instance that issued them.
+// Certificates that cannot be revoked are returned as errors keyed by serial.
+func (b *keyfactorBackend) collectRevokeCandidates(ctx context.Context, req *logical.Request, match func(serial string, cert *x509.Certificate) (bool, error)) (map[string][]revokeCandidate, map[string]interface{}, error) {
+	serials, err := req.Storage.List(ctx, "certs/")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	errs := map[string]interface{}{}
+	byInstance := map[string][]revokeCandidate{}
+	for _, serial := range serials {
+		certEntry, err := req.Storage.Get(ctx, "certs/"+serial)
+		if err != nil {
+			return nil, nil, err
+		}
+		if certEntry == nil {
+			continue
+		}
+		cert, err := parseCertificatePEM(certEntry.Value)
+		if err != nil {
+			continue
+		}
+		matched, err := match(serial, cert)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !matched {
+			continue
+		}
+
+		revokedEntry, err := req.Storage.Get(ctx, "revoked/"+serial)
+		if err != nil {
+			return nil, nil, err
+		}
+		if revokedEntry != nil {
+			continue
+		}
+
+		kfIdEntry, err := req.Storage.Get(ctx, "kfId/"+serial)
+		if err != nil {
+			return nil, nil, err
+		}
+		if kfIdEntry == nil {
+			errs[serial] = "no Keyfactor certificate ID is stored for the certificate"
+			continue
+		}
+		keyfactorId, err := decodeKeyfactorId(kfIdEntry)
+		if err != nil {
+			errs[serial] = fmt.Sprintf("unable to parse the stored Keyfactor certificate ID: %s", err)
+			continue
+		}
+
+		instance, err := getCertInstance(ctx, req.Storage, serial)
+		if err != nil {
+			return nil, nil, err
+		}
+		byInstance[instance] = append(byInstance[instance], revokeCandidate{
+			serial:      serial,
+			keyfactorId: keyfactorId,
+			certBytes:   certEntry.Value,
+		})
+	}
+	return byInstance, errs, nil
+}
+
+// revokeCandidates revokes the certificates with one Keyfactor request per
+// instance and records the revocations locally.  It returns the sorted serials
+// that were revoked, adding failures to errs keyed by serial.
+func (b *keyfactorBackend) revokeCandidates(ctx context.Context, req *logical.Request, byInstance map[string][]revokeCandidate, reason int, timeout time.Duration, errs map[string]interface{}) ([]string, error) {
+	revoked := []string{}
+	for instance, candidates := range byInstance {
+		if err := b.sendBatchRevocation(ctx, req, instance, candidates, reason); err != nil {
+			msg := err.Error()
+			if errors.Is(err, context.DeadlineExceeded) {
+				msg = fmt.Sprintf("keyfactor request timed out after %s", timeout)
+			}
+			for _, candidate := range candidates {
+				errs[candidate.serial] = msg
+			}
+			continue
+		}
+
+		now := time.Now()
+		for _, candidate := range candidates {
+			revEntry, err := logical.StorageEntryJSON("revoked/"+candidate.serial, &revocationInfo{
+				CertificateBytes:  candidate.certBytes,
+				RevocationTime:    now.Unix(),
+				RevocationTimeUTC: now.UTC(),
+				RevocationReason:  reason,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := req.Storage.Put(ctx, revEntry); err != nil {
+				errs[candidate.serial] = fmt.Sprintf("revoked in Keyfactor but unable to store the revocation locally: %s", err)
+				continue
+			}
+			revoked = append(revoked, candidate.serial)
+			if err := b.countDailyStat(ctx, req.Storage, statsEventRevoked); err != nil {
+				b.Logger().Warn("unable to count the revocation in the daily statistics", "error", err)
+			}
+			b.notifyWebhooksForCert(ctx, req.Storage, webhookEventRevoke, candidate.serial, candidate.certBytes)
+		}
+	}
+	sort.Strings(revoked)
+	return revoked, nil
+}
+
+// sendBatchRevocation revokes the certificates in Keyfactor with a single request.
+func (b *keyfactorBackend) sendBatchRevocation(ctx context.Context, req *logical.Request, instance string, candidates []revokeCandidate, reason int) error {
+	body := keyfactorRevokeRequest{
+		Reason:        reason,
+		Comment:       "via HashiCorp Vault",
+		EffectiveDate: time.Now().Format(time.RFC3339),
+	}
+	for _, candidate := range candidates {
+		body.CertificateIds = append(body.CertificateIds, candidate.keyfactorId)
+	}
+	config, err := b.fetchConfig(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return errors.New("unable to load configuration")
+	}
+	instanceConfig, err := config.instanceConfig(instance)
+	if err != nil {
+		return err
+	}
+	_, err = b.sendJSONToInstance(ctx, req, instance, http.MethodPost, instanceConfig.revokeAPIPath(), body)
+	return err
+}
+
+// sendJSONToInstance sends body as JSON with the given method to a path of
+// the Command API of a Keyfactor instance and returns the response body.
+func (b *keyfactorBackend) sendJSONToInstance(ctx context.Context, req *logical.Request, instance string, method string, path string, body interface{}) ([]byte, error) {
+	config, err := b.fetchConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	instanceConfig, err := config.instanceConfig(instance)
+	if err != nil {
+		return nil, err
+	}
+	client, err := b.getInstanceClient(ctx, req.Storage, instance)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	url := instanceConfig.apiURL(path)
+	b.Logger().Debug("Sending request to " + path + ".  payload =  " + string(payload))
+	reqCtx, cancel := keyfactorRequestContext(ctx)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(reqCtx, method, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Add("x-keyfactor-requested-with", "APIClient")
+	httpReq.Header.Add("content-type", "application/json")
+
+	_, respBody, err := b.sendKeyfactorRequest(ctx, req.Storage, client, httpReq)
+	return respBody, err
+}
+
+const pathRevokeCNHelpSyn = `
+Revoke every certificate with a given common name.
+`
+
+const pathRevokeCNHelpDesc = `
+This revokes all stored, non-revoked certificates whose common name matches "common_name"
+(case-insensitively), e.g. after the private key of a service has been compromised. The
+certificates are revoked in Keyfactor with a single request per Keyfactor instance. The
+response lists the revoked serial numbers and, keyed by serial number, any certificates
+that could not be revoked.
+`
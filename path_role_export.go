@@ -0,0 +1,184 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Formats of the bundles exported by roles/<name>/export-bundle.
+const (
+	exportFormatPKCS7     = "pkcs7"
+	exportFormatPEMBundle = "pem_bundle"
+)
+
+func pathRoleExport(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "roles/" + framework.GenericNameRegex("name") + "/export-bundle",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the role",
+				},
+				"format": {
+					Type:          framework.TypeString,
+					Description:   `The format of the bundle: "pkcs7" for a certs-only PKCS#7 SignedData or "pem_bundle" for concatenated PEM certificates.`,
+					Default:       exportFormatPKCS7,
+					AllowedValues: []interface{}{exportFormatPKCS7, exportFormatPEMBundle},
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathRoleExportBundle,
+			},
+
+			HelpSynopsis:    pathRoleExportBundleHelpSyn,
+			HelpDescription: pathRoleExportBundleHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathRoleExportBundle(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	format := data.Get("format").(string)
+	if format != exportFormatPKCS7 && format != exportFormatPEMBundle {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported format %q; use %s or %s", format, exportFormatPKCS7, exportFormatPEMBundle)), nil
+	}
+
+	role, err := b.getRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", name)), nil
+	}
+
+	serials, err := req.Storage.List(ctx, "certs/")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(serials)
+
+	var certs []*x509.Certificate
+	var pemBundle bytes.Buffer
+	for _, serial := range serials {
+		info, err := getCertInfo(ctx, req.Storage, serial)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil || info.Role != name {
+			continue
+		}
+		revokedEntry, err := req.Storage.Get(ctx, "revoked/"+serial)
+		if err != nil {
+			return nil, err
+		}
+		if revokedEntry != nil {
+			continue
+		}
+		certEntry, err := req.Storage.Get(ctx, "certs/"+serial)
+		if err != nil {
+			return nil, err
+		}
+		if certEntry == nil {
+			continue
+		}
+		cert, err := parseCertificatePEM(certEntry.Value)
+		if err != nil {
+			b.Logger().Debug("unable to parse stored certificate while exporting role bundle", "serial", serial, "error", err)
+			continue
+		}
+		certs = append(certs, cert)
+		pemBundle.WriteString(encodeCertsPEM([]*x509.Certificate{cert}))
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"count": len(certs),
+		},
+	}
+	if format == exportFormatPEMBundle {
+		resp.Data["pem_bundle"] = pemBundle.String()
+		return resp, nil
+	}
+
+	der, err := encodePKCS7CertsOnly(certs)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode the PKCS#7 bundle: %w", err)
+	}
+	resp.Data["pkcs7_b64"] = base64.StdEncoding.EncodeToString(der)
+	return resp, nil
+}
+
+// pkcs7CertsOnly is a degenerate SignedData structure of RFC 2315 that
+// carries certificates and no signers.
+type pkcs7CertsOnly struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      struct {
+		ContentType asn1.ObjectIdentifier
+	}
+	Certificates asn1.RawValue
+	SignerInfos  asn1.RawValue
+}
+
+// pkcs7Envelope is the outer ContentInfo of a PKCS#7 structure.
+type pkcs7Envelope struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+// encodePKCS7CertsOnly DER-encodes the certificates as a certs-only PKCS#7
+// SignedData, as consumed by Windows and OpenSSL certificate imports.
+func encodePKCS7CertsOnly(certs []*x509.Certificate) ([]byte, error) {
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+
+	signedData := pkcs7CertsOnly{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true},
+	}
+	signedData.ContentInfo.ContentType = oidPKCS7Data
+	content, err := asn1.Marshal(signedData)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pkcs7Envelope{
+		ContentType: oidPKCS7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+	})
+}
+
+const pathRoleExportBundleHelpSyn = `
+Export the certificates issued by a role as a bundle.
+`
+
+const pathRoleExportBundleHelpDesc = `
+This returns every stored certificate issued by the role that has not been revoked, for
+consumers that need to trust a set of certificates in advance. With "format" set to "pkcs7",
+the default, the certificates are returned in "pkcs7_b64" as a base64-encoded, DER-encoded
+certs-only PKCS#7 SignedData (a .p7b file). With "pem_bundle" they are returned in
+"pem_bundle" as concatenated PEM certificates. "count" is the number of certificates exported.
+`
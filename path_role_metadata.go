@@ -0,0 +1,197 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const kf_metadata_path = "/Certificates/Metadata"
+
+// metadataUpdateRate is the maximum number of Keyfactor requests per second
+// made while updating the metadata of the certificates of a role.
+const metadataUpdateRate = 10
+
+// keyfactorCertMetadata is the subset of a Keyfactor certificate holding its metadata.
+type keyfactorCertMetadata struct {
+	Id       int                    `json:"Id"`
+	Metadata map[string]interface{} `json:"Metadata"`
+}
+
+func pathRoleMetadata(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "roles/" + framework.GenericNameRegex("name") + "/update-metadata",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: `Name of the role.`,
+				},
+				"metadata": {
+					Type:        framework.TypeMap,
+					Description: `The Keyfactor metadata to set on the certificates of the role.`,
+					Required:    true,
+				},
+				"merge": {
+					Type:        framework.TypeBool,
+					Description: `If true, merge the metadata into the current metadata of each certificate, replacing colliding fields. If false, replace the metadata entirely.`,
+					Default:     true,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathRoleUpdateMetadata,
+			},
+
+			HelpSynopsis:    pathRoleUpdateMetadataHelpSyn,
+			HelpDescription: pathRoleUpdateMetadataHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathRoleUpdateMetadata(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	metadata := data.Get("metadata").(map[string]interface{})
+	merge := data.Get("merge").(bool)
+	if len(metadata) == 0 && merge {
+		return logical.ErrorResponse("the metadata must be provided"), nil
+	}
+
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+
+	role, err := b.getRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", name)), nil
+	}
+
+	// the certificates issued by the role that have not been revoked
+	byInstance, results, err := b.collectRevokeCandidates(ctx, req, func(serial string, cert *x509.Certificate) (bool, error) {
+		info, err := getCertInfo(ctx, req.Storage, serial)
+		if err != nil {
+			return false, err
+		}
+		return info != nil && info.Role == name, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(time.Second / metadataUpdateRate)
+	defer ticker.Stop()
+	wait := func() error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			return nil
+		}
+	}
+
+	updated := 0
+	for instance, candidates := range byInstance {
+		for _, candidate := range candidates {
+			err := b.updateCertMetadata(ctx, req, instance, candidate.keyfactorId, metadata, merge, wait)
+			if err != nil {
+				results[candidate.serial] = err.Error()
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				continue
+			}
+			results[candidate.serial] = "success"
+			updated++
+		}
+	}
+
+	b.Logger().Info("updated certificate metadata for role", "role", name, "merge", merge, "updated", updated, "failed", len(results)-updated)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"updated_count": updated,
+			"failed_count":  len(results) - updated,
+			"results":       results,
+		},
+	}, nil
+}
+
+// updateCertMetadata sets the metadata of a certificate in Keyfactor, merged
+// into its current metadata if merge is set.  wait is called before each
+// Keyfactor request.
+func (b *keyfactorBackend) updateCertMetadata(ctx context.Context, req *logical.Request, instance string, keyfactorId int, metadata map[string]interface{}, merge bool, wait func() error) error {
+	updatedMetadata := map[string]interface{}{}
+	if merge {
+		config, err := b.fetchConfig(ctx, req.Storage)
+		if err != nil {
+			return err
+		}
+		instanceConfig, err := config.instanceConfig(instance)
+		if err != nil {
+			return err
+		}
+		client, err := b.getInstanceClient(ctx, req.Storage, instance)
+		if err != nil {
+			return fmt.Errorf("error getting client: %w", err)
+		}
+
+		if err := wait(); err != nil {
+			return err
+		}
+		var current keyfactorCertMetadata
+		if err := b.getKeyfactorJSON(ctx, req.Storage, client, instanceConfig, "/Certificates/"+strconv.Itoa(keyfactorId), &current); err != nil {
+			return fmt.Errorf("error getting the current metadata: %w", err)
+		}
+		for k, v := range current.Metadata {
+			updatedMetadata[k] = v
+		}
+	}
+	for k, v := range metadata {
+		updatedMetadata[k] = v
+	}
+
+	if err := wait(); err != nil {
+		return err
+	}
+	body := keyfactorCertMetadata{
+		Id:       keyfactorId,
+		Metadata: updatedMetadata,
+	}
+	if _, err := b.sendJSONToInstance(ctx, req, instance, http.MethodPut, kf_metadata_path, body); err != nil {
+		return fmt.Errorf("error updating the metadata: %w", err)
+	}
+	return nil
+}
+
+const pathRoleUpdateMetadataHelpSyn = `
+Update the Keyfactor metadata of every certificate issued by a role.
+`
+
+const pathRoleUpdateMetadataHelpDesc = `
+This sets "metadata" on every stored, non-revoked certificate issued by the role in Keyfactor.
+With "merge" set to true (the default), the current metadata of each certificate is read from
+Keyfactor and the provided fields are merged into it, replacing fields with the same name. With
+"merge" set to false, the metadata is replaced entirely.
+
+Keyfactor is sent at most 10 requests per second. The response maps each serial number to
+"success" or to the error that prevented its update.
+`
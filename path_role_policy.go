@@ -0,0 +1,103 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// defaultPolicyMountPath is the mount path used in generated policies when
+// the request does not carry one.
+const defaultPolicyMountPath = "keyfactor/"
+
+func pathRolePolicy(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "roles/" + framework.GenericNameRegex("name") + "/policy",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the role",
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathRolePolicyRead,
+			},
+
+			HelpSynopsis:    pathRolePolicyHelpSyn,
+			HelpDescription: pathRolePolicyHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathRolePolicyRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	role, err := b.getRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", name)), nil
+	}
+
+	mount := req.MountPoint
+	if mount == "" {
+		mount = defaultPolicyMountPath
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"role":       name,
+			"policy_hcl": rolePolicyHCL(mount, name),
+		},
+	}, nil
+}
+
+// rolePolicyHCL returns a Vault policy granting the permissions needed to
+// issue, read and revoke certificates with the role on the given mount.
+func rolePolicyHCL(mount string, role string) string {
+	mount = strings.TrimSuffix(mount, "/") + "/"
+	rules := []struct {
+		path         string
+		capabilities string
+	}{
+		{"issue/" + role, `["update"]`},
+		{"sign/" + role, `["update"]`},
+		{"certs/", `["list"]`},
+		{"certs/*", `["read"]`},
+		{"revoke", `["update"]`},
+	}
+
+	var policy strings.Builder
+	fmt.Fprintf(&policy, "# Issue and revoke certificates with the %s role.\n", role)
+	for _, rule := range rules {
+		fmt.Fprintf(&policy, "\npath %q {\n  capabilities = %s\n}\n", mount+rule.path, rule.capabilities)
+	}
+	return policy.String()
+}
+
+const pathRolePolicyHelpSyn = `
+Generate a Vault policy for using a role.
+`
+
+const pathRolePolicyHelpDesc = `
+This returns, in "policy_hcl", a Vault policy granting update on the issue and sign paths of
+the role and on revoke, list on certs/ and read on the stored certificates, with paths
+prefixed by the mount of the backend. The policy can be written directly to
+sys/policy/<name>, e.g.
+
+  vault read -field=policy_hcl keyfactor/roles/web/policy | vault policy write web-issuer -
+`
@@ -11,7 +11,13 @@ package kfbackend
 
 import (
 	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net/mail"
+	"regexp"
 	"strings"
 	"time"
 
@@ -20,6 +26,7 @@ import (
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/certutil"
 	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/errutil"
 	"github.com/hashicorp/vault/sdk/helper/parseutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -35,7 +42,18 @@ func pathRoles(b *keyfactorBackend) []*framework.Path {
 				logical.DeleteOperation: b.pathRoleDelete,
 			},
 
-			Fields:          addRoleFields(map[string]*framework.FieldSchema{}),
+			Fields: addRoleFields(map[string]*framework.FieldSchema{
+				"force_revoke": {
+					Type:        framework.TypeBool,
+					Description: `On delete, revoke every non-revoked certificate issued by the role before deleting it.`,
+					Default:     false,
+				},
+				"allow_active_certs": {
+					Type:        framework.TypeBool,
+					Description: `On delete, allow deleting a role that still has active certificates without revoking them.`,
+					Default:     false,
+				},
+			}),
 			HelpSynopsis:    pathRoleHelpSyn,
 			HelpDescription: pathRoleHelpDesc,
 		},
@@ -125,9 +143,16 @@ func (b *keyfactorBackend) getRole(ctx context.Context, s logical.Storage, n str
 	if result.GenerateLease == nil {
 		// All the new roles will have GenerateLease always set to a value. A
 		// nil value indicates that this role needs an upgrade. Set it to
-		// `true` to not alter its current behavior.
+		// `false` to not alter its current behavior, as roles stored before
+		// leases were created never leased their certificates.
 		result.GenerateLease = new(bool)
-		*result.GenerateLease = true
+		modified = true
+	}
+
+	// Upgrade revoke_on_renew in role, which defaults to true
+	if result.RevokeOnRenew == nil {
+		result.RevokeOnRenew = new(bool)
+		*result.RevokeOnRenew = true
 		modified = true
 	}
 
@@ -169,12 +194,62 @@ func (b *keyfactorBackend) getRole(ctx context.Context, s logical.Storage, n str
 }
 
 func (b *keyfactorBackend) pathRoleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	err := req.Storage.Delete(ctx, "role/"+data.Get("name").(string))
+	name := data.Get("name").(string)
+
+	// the certificates issued by the role that have not been revoked
+	now := time.Now()
+	byInstance, errs, err := b.collectRevokeCandidates(ctx, req, func(serial string, cert *x509.Certificate) (bool, error) {
+		info, err := getCertInfo(ctx, req.Storage, serial)
+		if err != nil {
+			return false, err
+		}
+		return info != nil && info.Role == name && now.Before(cert.NotAfter), nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	active := len(errs)
+	for _, candidates := range byInstance {
+		active += len(candidates)
+	}
 
-	return nil, nil
+	var revoked []string
+	switch {
+	case active == 0:
+	case data.Get("force_revoke").(bool):
+		timeout, err := b.resolveOperationTimeout(ctx, req.Storage, operationRevoke, data)
+		if err != nil {
+			return nil, err
+		}
+		revoked, err = b.revokeCandidates(withOperationTimeout(ctx, timeout), req, byInstance, revocationReasonCessationOfOperation, timeout, errs)
+		if err != nil {
+			return nil, err
+		}
+		if len(errs) != 0 {
+			resp := logical.ErrorResponse(fmt.Sprintf("unable to revoke %d certificates issued by role %s; the role was not deleted", len(errs), name))
+			resp.Data["revoked_serials"] = revoked
+			resp.Data["errors"] = errs
+			return resp, nil
+		}
+	case !data.Get("allow_active_certs").(bool):
+		return logical.ErrorResponse(fmt.Sprintf("role %s has %d active certificates; set force_revoke=true to revoke them or allow_active_certs=true to delete the role anyway", name, active)), nil
+	}
+
+	err = req.Storage.Delete(ctx, "role/"+name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(revoked) == 0 {
+		return nil, nil
+	}
+	b.Logger().Info("revoked certificates of deleted role", "role", name, "revoked", len(revoked))
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"revoked_count":   len(revoked),
+			"revoked_serials": revoked,
+		},
+	}, nil
 }
 
 func (b *keyfactorBackend) pathRoleRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -248,6 +323,37 @@ func (b *keyfactorBackend) pathRoleCreate(ctx context.Context, req *logical.Requ
 		PolicyIdentifiers:             data.Get("policy_identifiers").([]string),
 		BasicConstraintsValidForNonCA: data.Get("basic_constraints_valid_for_non_ca").(bool),
 		NotBeforeDuration:             time.Duration(data.Get("not_before_duration").(int)) * time.Second,
+		ProhibitedCommonNames:         data.Get("prohibited_common_names").([]string),
+		AllowWildcardCertificates:     data.Get("allow_wildcard_certificates").(bool),
+		OCSPServerURL:                 data.Get("ocsp_server_url").(string),
+		EmailAddress:                  data.Get("email_address").(string),
+		DNSSANsValidationRegex:        data.Get("dns_sans_validation_regex").(string),
+		DelegateToRole:                data.Get("delegate_to_role").(string),
+		AutoRenew:                     data.Get("auto_renew").(bool),
+		RenewBefore:                   time.Duration(data.Get("renew_before").(int)) * time.Second,
+		RevokeOnRenew:                 new(bool),
+		AllowLoopbackIPSANs:           data.Get("allow_loopback_ip_sans").(bool),
+		AllowMulticastIPSANs:          data.Get("allow_multicast_ip_sans").(bool),
+		AllowLinkLocalIPSANs:          data.Get("allow_link_local_ip_sans").(bool),
+		QuotaMaxIssuances:             data.Get("quota_max_issuances").(int),
+		QuotaPeriod:                   time.Duration(data.Get("quota_period").(int)) * time.Second,
+		MaxSANCount:                   data.Get("max_san_count").(int),
+		MinRSAKeyBits:                 data.Get("min_rsa_key_bits").(int),
+		MinECKeyCurve:                 data.Get("min_ec_key_curve").(string),
+		AllowedSignatureAlgorithms:    data.Get("allowed_signature_algorithms").([]string),
+		AllowedCAs:                    data.Get("allowed_cas").([]string),
+		AllowedCustomFields:           data.Get("allowed_custom_fields").([]string),
+		DNOrder:                       data.Get("dn_order").(string),
+		SubjectSerialNumber:           data.Get("subject_serial_number").(string),
+		SpiffeTrustDomain:             data.Get("spiffe_trust_domain").(string),
+		KeyUsagePurpose:               data.Get("key_usage_purpose").(string),
+		MaxStoredCerts:                data.Get("max_stored_certs").(int),
+		PurgeOldestOnLimit:            data.Get("purge_oldest_on_limit").(bool),
+		MaxDNSSANs:                    data.Get("max_dns_sans").(int),
+		MaxIPSANs:                     data.Get("max_ip_sans").(int),
+		MaxURISANs:                    data.Get("max_uri_sans").(int),
+		MaxEmailSANs:                  data.Get("max_email_sans").(int),
+		DeriveCNFromSAN:               data.Get("derive_cn_from_san").(bool),
 	}
 
 	allowedOtherSANs := data.Get("allowed_other_sans").([]string)
@@ -268,6 +374,7 @@ func (b *keyfactorBackend) pathRoleCreate(ctx context.Context, req *logical.Requ
 	} else {
 		*entry.GenerateLease = data.Get("generate_lease").(bool)
 	}
+	*entry.RevokeOnRenew = data.Get("revoke_on_renew").(bool)
 
 	if entry.KeyType == "rsa" && entry.KeyBits < 2048 {
 		return logical.ErrorResponse("RSA keys < 2048 bits are unsafe and not supported"), nil
@@ -301,6 +408,77 @@ func (b *keyfactorBackend) pathRoleCreate(ctx context.Context, req *logical.Requ
 		}
 	}
 
+	if extraExtensions, ok := data.GetOk("extra_extensions"); ok {
+		entry.ExtraExtensions, err = parseExtraExtensions(extraExtensions.([]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(errwrap.Wrapf("error parsing extra_extensions: {{err}}", err).Error()), nil
+		}
+	}
+
+	if entry.DNSSANsValidationRegex != "" {
+		if _, err := compileDNSSANsRegex(entry.DNSSANsValidationRegex); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid dns_sans_validation_regex: %s", err)), nil
+		}
+	}
+
+	if entry.QuotaMaxIssuances < 0 {
+		return logical.ErrorResponse("quota_max_issuances must not be negative"), nil
+	}
+
+	if entry.MaxSANCount < 0 {
+		return logical.ErrorResponse("max_san_count must not be negative"), nil
+	}
+
+	if entry.MaxDNSSANs < 0 || entry.MaxIPSANs < 0 || entry.MaxURISANs < 0 || entry.MaxEmailSANs < 0 {
+		return logical.ErrorResponse("max_dns_sans, max_ip_sans, max_uri_sans and max_email_sans must not be negative"), nil
+	}
+
+	if entry.MinRSAKeyBits < 0 {
+		return logical.ErrorResponse("min_rsa_key_bits must not be negative"), nil
+	}
+	switch entry.DNOrder {
+	case "", dnOrderRFC4514, dnOrderLegacy:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("unsupported dn_order %q; use %s or %s", entry.DNOrder, dnOrderRFC4514, dnOrderLegacy)), nil
+	}
+	if _, ok := ecCurveBits[entry.MinECKeyCurve]; entry.MinECKeyCurve != "" && !ok {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported min_ec_key_curve %q; use P-256, P-384 or P-521", entry.MinECKeyCurve)), nil
+	}
+	for _, name := range entry.AllowedSignatureAlgorithms {
+		if _, ok := signatureAlgorithms[name]; !ok {
+			return logical.ErrorResponse(fmt.Sprintf("unsupported signature algorithm %q in allowed_signature_algorithms; supported algorithms are %s", name, strings.Join(signatureAlgorithmNames(), ", "))), nil
+		}
+	}
+	if entry.KeyType == "rsa" && entry.KeyBits < entry.MinRSAKeyBits {
+		return logical.ErrorResponse(fmt.Sprintf("key_bits %d is below min_rsa_key_bits %d", entry.KeyBits, entry.MinRSAKeyBits)), nil
+	}
+
+	if entry.DelegateToRole == name {
+		return logical.ErrorResponse("a role cannot delegate to itself"), nil
+	}
+
+	if entry.EmailAddress != "" {
+		if _, err := mail.ParseAddress(entry.EmailAddress); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid email_address %q: %s", entry.EmailAddress, err)), nil
+		}
+	}
+
+	if err := validateSubjectSerialNumber(entry.SubjectSerialNumber); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := validateSpiffeTrustDomain(entry.SpiffeTrustDomain); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := validateKeyUsagePurpose(entry.KeyUsagePurpose); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if entry.MaxStoredCerts < 0 {
+		return logical.ErrorResponse("max_stored_certs cannot be negative"), nil
+	}
+
 	// Store it
 	jsonEntry, err := logical.StorageEntryJSON("role/"+name, entry)
 	if err != nil {
@@ -314,61 +492,226 @@ func (b *keyfactorBackend) pathRoleCreate(ctx context.Context, req *logical.Requ
 }
 
 type roleEntry struct {
-	LeaseMax                      string        `json:"lease_max"`
-	Lease                         string        `json:"lease"`
-	DeprecatedMaxTTL              string        `json:"max_ttl" mapstructure:"max_ttl"`
-	DeprecatedTTL                 string        `json:"ttl" mapstructure:"ttl"`
-	TTL                           time.Duration `json:"ttl_duration" mapstructure:"ttl_duration"`
-	MaxTTL                        time.Duration `json:"max_ttl_duration" mapstructure:"max_ttl_duration"`
-	AllowLocalhost                bool          `json:"allow_localhost" mapstructure:"allow_localhost"`
-	AllowedBaseDomain             string        `json:"allowed_base_domain" mapstructure:"allowed_base_domain"`
-	AllowedDomainsOld             string        `json:"allowed_domains,omitempty"`
-	AllowedDomains                []string      `json:"allowed_domains_list" mapstructure:"allowed_domains"`
-	AllowedDomainsTemplate        bool          `json:"allowed_domains_template"`
-	AllowBaseDomain               bool          `json:"allow_base_domain"`
-	AllowBareDomains              bool          `json:"allow_bare_domains" mapstructure:"allow_bare_domains"`
-	AllowTokenDisplayName         bool          `json:"allow_token_displayname" mapstructure:"allow_token_displayname"`
-	AllowSubdomains               bool          `json:"allow_subdomains" mapstructure:"allow_subdomains"`
-	AllowGlobDomains              bool          `json:"allow_glob_domains" mapstructure:"allow_glob_domains"`
-	AllowAnyName                  bool          `json:"allow_any_name" mapstructure:"allow_any_name"`
-	EnforceHostnames              bool          `json:"enforce_hostnames" mapstructure:"enforce_hostnames"`
-	AllowIPSANs                   bool          `json:"allow_ip_sans" mapstructure:"allow_ip_sans"`
-	ServerFlag                    bool          `json:"server_flag" mapstructure:"server_flag"`
-	ClientFlag                    bool          `json:"client_flag" mapstructure:"client_flag"`
-	CodeSigningFlag               bool          `json:"code_signing_flag" mapstructure:"code_signing_flag"`
-	EmailProtectionFlag           bool          `json:"email_protection_flag" mapstructure:"email_protection_flag"`
-	UseCSRCommonName              bool          `json:"use_csr_common_name" mapstructure:"use_csr_common_name"`
-	UseCSRSANs                    bool          `json:"use_csr_sans" mapstructure:"use_csr_sans"`
-	KeyType                       string        `json:"key_type" mapstructure:"key_type"`
-	KeyBits                       int           `json:"key_bits" mapstructure:"key_bits"`
-	MaxPathLength                 *int          `json:",omitempty" mapstructure:"max_path_length"`
-	KeyUsageOld                   string        `json:"key_usage,omitempty"`
-	KeyUsage                      []string      `json:"key_usage_list" mapstructure:"key_usage"`
-	ExtKeyUsage                   []string      `json:"extended_key_usage_list" mapstructure:"extended_key_usage"`
-	OUOld                         string        `json:"ou,omitempty"`
-	OU                            []string      `json:"ou_list" mapstructure:"ou"`
-	OrganizationOld               string        `json:"organization,omitempty"`
-	Organization                  []string      `json:"organization_list" mapstructure:"organization"`
-	Country                       []string      `json:"country" mapstructure:"country"`
-	Locality                      []string      `json:"locality" mapstructure:"locality"`
-	Province                      []string      `json:"province" mapstructure:"province"`
-	StreetAddress                 []string      `json:"street_address" mapstructure:"street_address"`
-	PostalCode                    []string      `json:"postal_code" mapstructure:"postal_code"`
-	GenerateLease                 *bool         `json:"generate_lease,omitempty"`
-	NoStore                       bool          `json:"no_store" mapstructure:"no_store"`
-	RequireCN                     bool          `json:"require_cn" mapstructure:"require_cn"`
-	AllowedOtherSANs              []string      `json:"allowed_other_sans" mapstructure:"allowed_other_sans"`
-	AllowedSerialNumbers          []string      `json:"allowed_serial_numbers" mapstructure:"allowed_serial_numbers"`
-	AllowedURISANs                []string      `json:"allowed_uri_sans" mapstructure:"allowed_uri_sans"`
-	PolicyIdentifiers             []string      `json:"policy_identifiers" mapstructure:"policy_identifiers"`
-	ExtKeyUsageOIDs               []string      `json:"ext_key_usage_oids" mapstructure:"ext_key_usage_oids"`
-	BasicConstraintsValidForNonCA bool          `json:"basic_constraints_valid_for_non_ca" mapstructure:"basic_constraints_valid_for_non_ca"`
-	NotBeforeDuration             time.Duration `json:"not_before_duration" mapstructure:"not_before_duration"`
+	LeaseMax                      string          `json:"lease_max"`
+	Lease                         string          `json:"lease"`
+	DeprecatedMaxTTL              string          `json:"max_ttl" mapstructure:"max_ttl"`
+	DeprecatedTTL                 string          `json:"ttl" mapstructure:"ttl"`
+	TTL                           time.Duration   `json:"ttl_duration" mapstructure:"ttl_duration"`
+	MaxTTL                        time.Duration   `json:"max_ttl_duration" mapstructure:"max_ttl_duration"`
+	AllowLocalhost                bool            `json:"allow_localhost" mapstructure:"allow_localhost"`
+	AllowedBaseDomain             string          `json:"allowed_base_domain" mapstructure:"allowed_base_domain"`
+	AllowedDomainsOld             string          `json:"allowed_domains,omitempty"`
+	AllowedDomains                []string        `json:"allowed_domains_list" mapstructure:"allowed_domains"`
+	AllowedDomainsTemplate        bool            `json:"allowed_domains_template"`
+	AllowBaseDomain               bool            `json:"allow_base_domain"`
+	AllowBareDomains              bool            `json:"allow_bare_domains" mapstructure:"allow_bare_domains"`
+	AllowTokenDisplayName         bool            `json:"allow_token_displayname" mapstructure:"allow_token_displayname"`
+	AllowSubdomains               bool            `json:"allow_subdomains" mapstructure:"allow_subdomains"`
+	AllowGlobDomains              bool            `json:"allow_glob_domains" mapstructure:"allow_glob_domains"`
+	AllowAnyName                  bool            `json:"allow_any_name" mapstructure:"allow_any_name"`
+	EnforceHostnames              bool            `json:"enforce_hostnames" mapstructure:"enforce_hostnames"`
+	AllowIPSANs                   bool            `json:"allow_ip_sans" mapstructure:"allow_ip_sans"`
+	ServerFlag                    bool            `json:"server_flag" mapstructure:"server_flag"`
+	ClientFlag                    bool            `json:"client_flag" mapstructure:"client_flag"`
+	CodeSigningFlag               bool            `json:"code_signing_flag" mapstructure:"code_signing_flag"`
+	EmailProtectionFlag           bool            `json:"email_protection_flag" mapstructure:"email_protection_flag"`
+	UseCSRCommonName              bool            `json:"use_csr_common_name" mapstructure:"use_csr_common_name"`
+	UseCSRSANs                    bool            `json:"use_csr_sans" mapstructure:"use_csr_sans"`
+	KeyType                       string          `json:"key_type" mapstructure:"key_type"`
+	KeyBits                       int             `json:"key_bits" mapstructure:"key_bits"`
+	MaxPathLength                 *int            `json:",omitempty" mapstructure:"max_path_length"`
+	KeyUsageOld                   string          `json:"key_usage,omitempty"`
+	KeyUsage                      []string        `json:"key_usage_list" mapstructure:"key_usage"`
+	ExtKeyUsage                   []string        `json:"extended_key_usage_list" mapstructure:"extended_key_usage"`
+	OUOld                         string          `json:"ou,omitempty"`
+	OU                            []string        `json:"ou_list" mapstructure:"ou"`
+	OrganizationOld               string          `json:"organization,omitempty"`
+	Organization                  []string        `json:"organization_list" mapstructure:"organization"`
+	Country                       []string        `json:"country" mapstructure:"country"`
+	Locality                      []string        `json:"locality" mapstructure:"locality"`
+	Province                      []string        `json:"province" mapstructure:"province"`
+	StreetAddress                 []string        `json:"street_address" mapstructure:"street_address"`
+	PostalCode                    []string        `json:"postal_code" mapstructure:"postal_code"`
+	GenerateLease                 *bool           `json:"generate_lease,omitempty"`
+	NoStore                       bool            `json:"no_store" mapstructure:"no_store"`
+	RequireCN                     bool            `json:"require_cn" mapstructure:"require_cn"`
+	AllowedOtherSANs              []string        `json:"allowed_other_sans" mapstructure:"allowed_other_sans"`
+	AllowedSerialNumbers          []string        `json:"allowed_serial_numbers" mapstructure:"allowed_serial_numbers"`
+	AllowedURISANs                []string        `json:"allowed_uri_sans" mapstructure:"allowed_uri_sans"`
+	PolicyIdentifiers             []string        `json:"policy_identifiers" mapstructure:"policy_identifiers"`
+	ExtKeyUsageOIDs               []string        `json:"ext_key_usage_oids" mapstructure:"ext_key_usage_oids"`
+	BasicConstraintsValidForNonCA bool            `json:"basic_constraints_valid_for_non_ca" mapstructure:"basic_constraints_valid_for_non_ca"`
+	NotBeforeDuration             time.Duration   `json:"not_before_duration" mapstructure:"not_before_duration"`
+	ProhibitedCommonNames         []string        `json:"prohibited_common_names" mapstructure:"prohibited_common_names"`
+	AllowWildcardCertificates     bool            `json:"allow_wildcard_certificates" mapstructure:"allow_wildcard_certificates"`
+	ExtraExtensions               []roleExtension `json:"extra_extensions" mapstructure:"extra_extensions"`
+	OCSPServerURL                 string          `json:"ocsp_server_url" mapstructure:"ocsp_server_url"`
+	EmailAddress                  string          `json:"email_address" mapstructure:"email_address"`
+	DNSSANsValidationRegex        string          `json:"dns_sans_validation_regex" mapstructure:"dns_sans_validation_regex"`
+	DelegateToRole                string          `json:"delegate_to_role" mapstructure:"delegate_to_role"`
+	AutoRenew                     bool            `json:"auto_renew" mapstructure:"auto_renew"`
+	RenewBefore                   time.Duration   `json:"renew_before" mapstructure:"renew_before"`
+	RevokeOnRenew                 *bool           `json:"revoke_on_renew,omitempty"`
+	AllowLoopbackIPSANs           bool            `json:"allow_loopback_ip_sans" mapstructure:"allow_loopback_ip_sans"`
+	AllowMulticastIPSANs          bool            `json:"allow_multicast_ip_sans" mapstructure:"allow_multicast_ip_sans"`
+	AllowLinkLocalIPSANs          bool            `json:"allow_link_local_ip_sans" mapstructure:"allow_link_local_ip_sans"`
+	QuotaMaxIssuances             int             `json:"quota_max_issuances" mapstructure:"quota_max_issuances"`
+	QuotaPeriod                   time.Duration   `json:"quota_period" mapstructure:"quota_period"`
+	MaxSANCount                   int             `json:"max_san_count" mapstructure:"max_san_count"`
+	MinRSAKeyBits                 int             `json:"min_rsa_key_bits" mapstructure:"min_rsa_key_bits"`
+	MinECKeyCurve                 string          `json:"min_ec_key_curve" mapstructure:"min_ec_key_curve"`
+	AllowedSignatureAlgorithms    []string        `json:"allowed_signature_algorithms" mapstructure:"allowed_signature_algorithms"`
+	AllowedCAs                    []string        `json:"allowed_cas" mapstructure:"allowed_cas"`
+	AllowedCustomFields           []string        `json:"allowed_custom_fields" mapstructure:"allowed_custom_fields"`
+	DNOrder                       string          `json:"dn_order" mapstructure:"dn_order"`
+	SubjectSerialNumber           string          `json:"subject_serial_number" mapstructure:"subject_serial_number"`
+	SpiffeTrustDomain             string          `json:"spiffe_trust_domain" mapstructure:"spiffe_trust_domain"`
+	KeyUsagePurpose               string          `json:"key_usage_purpose" mapstructure:"key_usage_purpose"`
+	MaxStoredCerts                int             `json:"max_stored_certs" mapstructure:"max_stored_certs"`
+	PurgeOldestOnLimit            bool            `json:"purge_oldest_on_limit" mapstructure:"purge_oldest_on_limit"`
+	MaxDNSSANs                    int             `json:"max_dns_sans" mapstructure:"max_dns_sans"`
+	MaxIPSANs                     int             `json:"max_ip_sans" mapstructure:"max_ip_sans"`
+	MaxURISANs                    int             `json:"max_uri_sans" mapstructure:"max_uri_sans"`
+	MaxEmailSANs                  int             `json:"max_email_sans" mapstructure:"max_email_sans"`
+	DeriveCNFromSAN               bool            `json:"derive_cn_from_san" mapstructure:"derive_cn_from_san"`
 
 	// Used internally for signing intermediates
 	AllowExpirationPastCA bool
 }
 
+// roleExtension is a custom X.509 extension added to CSRs generated for a role.
+type roleExtension struct {
+	OID      string `json:"oid" mapstructure:"oid"`
+	ValueHex string `json:"value_hex" mapstructure:"value_hex"`
+	Critical bool   `json:"critical" mapstructure:"critical"`
+}
+
+// parseExtraExtensions validates the raw `extra_extensions` field.  Each entry
+// must have a valid dotted OID and a hex encoded DER value.
+func parseExtraExtensions(raw []interface{}) ([]roleExtension, error) {
+	extensions := make([]roleExtension, 0, len(raw))
+	for _, value := range raw {
+		var extension roleExtension
+		switch v := value.(type) {
+		case string:
+			if err := json.Unmarshal([]byte(v), &extension); err != nil {
+				return nil, fmt.Errorf("%q could not be parsed as an extension object: %w", v, err)
+			}
+		default:
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(encoded, &extension); err != nil {
+				return nil, fmt.Errorf("could not parse extension object: %w", err)
+			}
+		}
+		if _, err := certutil.StringToOid(extension.OID); err != nil {
+			return nil, fmt.Errorf("%q could not be parsed as a valid oid for an extension", extension.OID)
+		}
+		if _, err := hex.DecodeString(extension.ValueHex); err != nil {
+			return nil, fmt.Errorf("value_hex for extension %q is not valid hex: %w", extension.OID, err)
+		}
+		extensions = append(extensions, extension)
+	}
+	return extensions, nil
+}
+
+// pkixExtensions converts the role's extra extensions for inclusion in a CSR.
+func (r *roleEntry) pkixExtensions() ([]pkix.Extension, error) {
+	extensions := make([]pkix.Extension, 0, len(r.ExtraExtensions))
+	for _, extension := range r.ExtraExtensions {
+		oid, err := certutil.StringToOid(extension.OID)
+		if err != nil {
+			return nil, err
+		}
+		value, err := hex.DecodeString(extension.ValueHex)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{
+			Id:       oid,
+			Critical: extension.Critical,
+			Value:    value,
+		})
+	}
+	return extensions, nil
+}
+
+// delegatedRole returns the role certificates requested through role are
+// issued with: the parent named by delegate_to_role, or role itself.  The
+// allowed domains of role must be covered by those of the parent.
+func (b *keyfactorBackend) delegatedRole(ctx context.Context, s logical.Storage, role *roleEntry) (*roleEntry, error) {
+	if role.DelegateToRole == "" {
+		return role, nil
+	}
+	parent, err := b.getRole(ctx, s, role.DelegateToRole)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		return nil, errutil.UserError{Err: fmt.Sprintf("unknown delegate_to_role %q", role.DelegateToRole)}
+	}
+	if parent.DelegateToRole != "" {
+		return nil, errutil.UserError{Err: fmt.Sprintf("role %q delegates to %q, which itself delegates; only one level of delegation is supported", role.DelegateToRole, parent.DelegateToRole)}
+	}
+	for _, domain := range role.AllowedDomains {
+		if !parent.coversAllowedDomain(domain, role.AllowSubdomains) {
+			return nil, errutil.UserError{Err: fmt.Sprintf("allowed domain %q is not allowed by the delegate role %q", domain, role.DelegateToRole)}
+		}
+	}
+	return parent, nil
+}
+
+// coversAllowedDomain reports whether every name an allowed domain of another
+// role permits is allowed by the allowed domains of the role: domain itself
+// and, if subdomains is set, its subdomains.
+func (r *roleEntry) coversAllowedDomain(domain string, subdomains bool) bool {
+	for _, v := range r.AllowedDomains {
+		if v == "*" {
+			return true
+		}
+		if domain == "*" {
+			continue
+		}
+		if r.AllowSubdomains && strings.HasSuffix(domain, "."+v) {
+			return true
+		}
+		if v == domain && (!subdomains || r.AllowSubdomains) {
+			return true
+		}
+	}
+	return false
+}
+
+// coversDomain reports whether the name domain is allowed by the allowed
+// domains of the role.
+func (r *roleEntry) coversDomain(domain string) bool {
+	for _, v := range r.AllowedDomains {
+		if v == "*" || v == domain {
+			return true
+		}
+		if r.AllowSubdomains && domain != "*" && strings.HasSuffix(domain, "."+v) {
+			return true
+		}
+	}
+	return false
+}
+
+// quotaPeriod returns the period the issuance quota of the role applies to.
+func (r *roleEntry) quotaPeriod() time.Duration {
+	if r.QuotaPeriod <= 0 {
+		return defaultQuotaPeriod
+	}
+	return r.QuotaPeriod
+}
+
+// compileDNSSANsRegex compiles a dns_sans_validation_regex, anchored so that
+// it must match the whole name.
+func compileDNSSANsRegex(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile(`^(?:` + expr + `)$`)
+}
+
 func (r *roleEntry) ToResponseData() map[string]interface{} {
 	responseData := map[string]interface{}{
 		"ttl":                                int64(r.TTL.Seconds()),
@@ -409,6 +752,37 @@ func (r *roleEntry) ToResponseData() map[string]interface{} {
 		"policy_identifiers":                 r.PolicyIdentifiers,
 		"basic_constraints_valid_for_non_ca": r.BasicConstraintsValidForNonCA,
 		"not_before_duration":                int64(r.NotBeforeDuration.Seconds()),
+		"prohibited_common_names":            r.ProhibitedCommonNames,
+		"allow_wildcard_certificates":        r.AllowWildcardCertificates,
+		"extra_extensions":                   r.ExtraExtensions,
+		"ocsp_server_url":                    r.OCSPServerURL,
+		"email_address":                      r.EmailAddress,
+		"dns_sans_validation_regex":          r.DNSSANsValidationRegex,
+		"delegate_to_role":                   r.DelegateToRole,
+		"auto_renew":                         r.AutoRenew,
+		"renew_before":                       int64(r.RenewBefore.Seconds()),
+		"allow_loopback_ip_sans":             r.AllowLoopbackIPSANs,
+		"allow_multicast_ip_sans":            r.AllowMulticastIPSANs,
+		"allow_link_local_ip_sans":           r.AllowLinkLocalIPSANs,
+		"quota_max_issuances":                r.QuotaMaxIssuances,
+		"quota_period":                       int64(r.QuotaPeriod.Seconds()),
+		"max_san_count":                      r.MaxSANCount,
+		"min_rsa_key_bits":                   r.MinRSAKeyBits,
+		"min_ec_key_curve":                   r.MinECKeyCurve,
+		"allowed_signature_algorithms":       r.AllowedSignatureAlgorithms,
+		"allowed_cas":                        r.AllowedCAs,
+		"allowed_custom_fields":              r.AllowedCustomFields,
+		"dn_order":                           r.DNOrder,
+		"subject_serial_number":              r.SubjectSerialNumber,
+		"spiffe_trust_domain":                r.SpiffeTrustDomain,
+		"key_usage_purpose":                  r.KeyUsagePurpose,
+		"max_stored_certs":                   r.MaxStoredCerts,
+		"purge_oldest_on_limit":              r.PurgeOldestOnLimit,
+		"max_dns_sans":                       r.MaxDNSSANs,
+		"max_ip_sans":                        r.MaxIPSANs,
+		"max_uri_sans":                       r.MaxURISANs,
+		"max_email_sans":                     r.MaxEmailSANs,
+		"derive_cn_from_san":                 r.DeriveCNFromSAN,
 	}
 	if r.MaxPathLength != nil {
 		responseData["max_path_length"] = r.MaxPathLength
@@ -416,6 +790,9 @@ func (r *roleEntry) ToResponseData() map[string]interface{} {
 	if r.GenerateLease != nil {
 		responseData["generate_lease"] = r.GenerateLease
 	}
+	if r.RevokeOnRenew != nil {
+		responseData["revoke_on_renew"] = r.RevokeOnRenew
+	}
 	return responseData
 }
 
@@ -425,4 +802,8 @@ const pathRoleHelpDesc = `Manage the roles that can be created with this plugin.
 and have been implemented here to maintain compatibility with the Vault PKI secrets engine.
 The Certificate Template configured within Keyfactor Command should be used to set certificate defaults.  
 The Role-specific fields that are verified before passing a certificate issuing request to Command are:
-AllowedDomains, AllowSubdomains.  These can be used to restrict the domains for which certificates can be issued.`
+AllowedDomains, AllowSubdomains, AllowWildcardCertificates.  These can be used to restrict the domains for which certificates can be issued.
+Custom extensions configured with ExtraExtensions are only added to the CSR; the Keyfactor template and CA must be
+configured to preserve them in the issued certificate.
+A role with active certificates can only be deleted with force_revoke=true, which revokes them first, or
+allow_active_certs=true, which leaves them in place.`
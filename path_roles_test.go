@@ -0,0 +1,71 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/helper/errutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCoversAllowedDomain(t *testing.T) {
+	cases := []struct {
+		name             string
+		parent           roleEntry
+		domain           string
+		childSubdomains  bool
+		expectedCoverage bool
+	}{
+		{"same domain", roleEntry{AllowedDomains: []string{"example.com"}}, "example.com", false, true},
+		{"subdomains not allowed by parent", roleEntry{AllowedDomains: []string{"example.com"}}, "example.com", true, false},
+		{"subdomains allowed by parent", roleEntry{AllowedDomains: []string{"example.com"}, AllowSubdomains: true}, "example.com", true, true},
+		{"subdomain of parent domain", roleEntry{AllowedDomains: []string{"example.com"}, AllowSubdomains: true}, "dev.example.com", true, true},
+		{"subdomain without parent subdomains", roleEntry{AllowedDomains: []string{"example.com"}}, "dev.example.com", false, false},
+		{"parent allows any domain", roleEntry{AllowedDomains: []string{"*"}}, "example.com", true, true},
+		{"child allows any domain", roleEntry{AllowedDomains: []string{"example.com"}, AllowSubdomains: true}, "*", false, false},
+		{"other domain", roleEntry{AllowedDomains: []string{"example.com"}, AllowSubdomains: true}, "example.org", false, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if covered := tc.parent.coversAllowedDomain(tc.domain, tc.childSubdomains); covered != tc.expectedCoverage {
+				t.Errorf("coversAllowedDomain(%q, %t) = %t, expected %t", tc.domain, tc.childSubdomains, covered, tc.expectedCoverage)
+			}
+		})
+	}
+}
+
+func TestDelegatedRoleRejectsChildSubdomains(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+	parent, err := logical.StorageEntryJSON("role/parent", &roleEntry{AllowedDomains: []string{"example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, parent); err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := Factory(ctx, logical.TestBackendConfig())
+	if err != nil {
+		t.Fatalf("unable to create the backend: %v", err)
+	}
+	child := &roleEntry{
+		AllowedDomains:  []string{"example.com"},
+		AllowSubdomains: true,
+		DelegateToRole:  "parent",
+	}
+	_, err = backend.(*keyfactorBackend).delegatedRole(ctx, s, child)
+	if _, ok := err.(errutil.UserError); !ok {
+		t.Fatalf("expected a user error for a child allowing subdomains the parent does not, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// defaultSearchPageSize is the number of certificates requested from
+	// Keyfactor per page.
+	defaultSearchPageSize = 100
+
+	// defaultSearchMaxResults is the number of certificates returned by a
+	// search unless max_results is set.
+	defaultSearchMaxResults = 1000
+)
+
+// metadataFieldNameRegex matches the names of Keyfactor metadata fields.
+var metadataFieldNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func pathSearch(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "search/by-metadata",
+			Fields: map[string]*framework.FieldSchema{
+				"field_name": {
+					Type:        framework.TypeString,
+					Description: "The name of the Keyfactor metadata field to match.",
+					Required:    true,
+				},
+				"field_value": {
+					Type:        framework.TypeString,
+					Description: "The value the metadata field must equal.",
+					Required:    true,
+				},
+				"page_size": {
+					Type:        framework.TypeInt,
+					Description: "The number of certificates requested from Keyfactor per page.",
+					Default:     defaultSearchPageSize,
+				},
+				"max_results": {
+					Type:        framework.TypeInt,
+					Description: "The maximum number of certificates to return.",
+					Default:     defaultSearchMaxResults,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathSearchByMetadata,
+			},
+
+			HelpSynopsis:    pathSearchByMetadataHelpSyn,
+			HelpDescription: pathSearchByMetadataHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathSearchByMetadata(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	fieldName := data.Get("field_name").(string)
+	fieldValue := data.Get("field_value").(string)
+	pageSize := data.Get("page_size").(int)
+	maxResults := data.Get("max_results").(int)
+
+	if !metadataFieldNameRegex.MatchString(fieldName) {
+		return logical.ErrorResponse("field_name must contain only letters, digits, hyphens and underscores"), nil
+	}
+	if fieldValue == "" {
+		return logical.ErrorResponse("field_value must be provided"), nil
+	}
+	if !validQueryValue(fieldValue) {
+		return logical.ErrorResponse(`field_value must not contain quotation marks or backslashes`), nil
+	}
+	if pageSize <= 0 || maxResults <= 0 {
+		return logical.ErrorResponse("page_size and max_results must be greater than 0"), nil
+	}
+
+	config, err := b.fetchConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("could not load configuration"), nil
+	}
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	query := url.QueryEscape(fmt.Sprintf(`%s -eq "%s"`, fieldName, fieldValue))
+	certs := []map[string]interface{}{}
+	truncated := false
+	for page := 1; ; page++ {
+		var results KeyfactorCertResponse
+		path := fmt.Sprintf("/Certificates?pq.queryString=%s&pq.pageReturned=%d&pq.returnLimit=%d", query, page, pageSize)
+		if err := b.getKeyfactorJSON(ctx, req.Storage, client, config, path, &results); err != nil {
+			return keyfactorErrorResponse("error searching certificates in Keyfactor", err)
+		}
+
+		for _, result := range results {
+			if len(certs) == maxResults {
+				truncated = true
+				break
+			}
+			template, _ := result.TemplateName.(string)
+			certs = append(certs, map[string]interface{}{
+				"serial_number": normalizeSerial(result.SerialNumber),
+				"common_name":   result.IssuedCN,
+				"not_after":     result.NotAfter.UTC().Format(time.RFC3339),
+				"template":      template,
+				"keyfactor_id":  result.ID,
+			})
+		}
+		if truncated || len(results) < pageSize {
+			break
+		}
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"certificates": certs,
+		},
+	}
+	if truncated {
+		resp.AddWarning(fmt.Sprintf("more than %d certificates matched; only the first %d are returned", maxResults, maxResults))
+	}
+	return resp, nil
+}
+
+// validQueryValue reports whether a value can be quoted in a Keyfactor
+// certificate query, which has no escape for quotation marks or backslashes.
+func validQueryValue(value string) bool {
+	return !strings.ContainsAny(value, `"\`)
+}
+
+const pathSearchByMetadataHelpSyn = `
+Search Keyfactor for certificates by metadata field value.
+`
+
+const pathSearchByMetadataHelpDesc = `
+This searches all certificates in Keyfactor, not only those stored by this backend, for
+certificates whose metadata field "field_name" equals "field_value". The serial number,
+common name, expiry, template and Keyfactor ID of each match are returned.
+
+Keyfactor is queried "page_size" certificates at a time until every match or "max_results"
+matches have been retrieved.
+`
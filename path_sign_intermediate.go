@@ -0,0 +1,189 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// intermediateCAStoragePrefix holds the intermediate CA certificates signed
+// with sign-intermediate, keyed by hex Subject Key Identifier.
+const intermediateCAStoragePrefix = "intermediate_cas/"
+
+// oidExtensionBasicConstraints is the OID of the X.509 basic constraints extension.
+var oidExtensionBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+
+// basicConstraints is the ASN.1 structure of the basic constraints extension.
+type basicConstraints struct {
+	IsCA       bool `asn1:"optional"`
+	MaxPathLen int  `asn1:"optional,default:-1"`
+}
+
+func pathSignIntermediate(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "sign-intermediate",
+			Fields: map[string]*framework.FieldSchema{
+				"csr": {
+					Type:        framework.TypeString,
+					Description: "PEM-format CSR of the intermediate CA, requesting basic constraints with CA:true.",
+					Required:    true,
+				},
+				"path_length": {
+					Type:        framework.TypeInt,
+					Description: "The path length constraint the CSR must request. -1 accepts any path length.",
+					Default:     -1,
+				},
+				"template": {
+					Type:        framework.TypeString,
+					Description: "The Keyfactor CA-profile template to enroll with.",
+					Required:    true,
+				},
+				"ca": {
+					Type:        framework.TypeString,
+					Description: `The CA to sign with in the format "<host\\logical>". If blank, will use the default from configuration.`,
+				},
+				"timeout": {
+					Type:        framework.TypeDurationSecond,
+					Description: "The maximum time to wait for Keyfactor to respond to this request. Cannot be larger than sign_timeout.",
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathSignIntermediateWrite,
+			},
+
+			HelpSynopsis:    pathSignIntermediateHelpSyn,
+			HelpDescription: pathSignIntermediateHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathSignIntermediateWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	templateName := data.Get("template").(string)
+	if templateName == "" {
+		return logical.ErrorResponse("template must be provided"), nil
+	}
+	pathLength := data.Get("path_length").(int)
+	if pathLength < -1 {
+		return logical.ErrorResponse("path_length must be -1 or greater"), nil
+	}
+
+	parsedCSR, csr, err := parseCSRPEM(data.Get("csr").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := parsedCSR.CheckSignature(); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid csr signature: %s", err)), nil
+	}
+	constraints, err := csrBasicConstraints(parsedCSR)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if constraints == nil || !constraints.IsCA {
+		return logical.ErrorResponse("the csr does not request a CA certificate; it must include basic constraints with CA:true"), nil
+	}
+	if pathLength >= 0 && constraints.MaxPathLen != pathLength {
+		return logical.ErrorResponse(fmt.Sprintf("the csr requests a path length of %d but path_length is %d", constraints.MaxPathLen, pathLength)), nil
+	}
+
+	b.Logger().Warn("signing intermediate CA", "entity_id", req.EntityID, "common_name", parsedCSR.Subject.CommonName, "template", templateName)
+
+	timeout, err := b.resolveOperationTimeout(ctx, req.Storage, operationSign, data)
+	if err != nil {
+		return nil, err
+	}
+	certs, serial, requestId, err := b.submitCSR(withOperationTimeout(ctx, timeout), req, "", csr, data.Get("ca").(string), templateName, "{}", nil, "", false)
+	if err != nil {
+		if timeoutResp, ok := timeoutErrorResponse(err, timeout); ok {
+			return timeoutResp, nil
+		}
+		return keyfactorErrorResponse("could not sign intermediate csr", err)
+	}
+
+	cert, err := parseCertificatePEM([]byte(certs[0]))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the intermediate certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return logical.ErrorResponse(fmt.Sprintf("keyfactor issued certificate %s without CA:true; check that template %q is a CA profile", serial, templateName)), nil
+	}
+	if len(cert.SubjectKeyId) == 0 {
+		return nil, fmt.Errorf("the intermediate certificate %s has no subject key identifier", serial)
+	}
+
+	entry := &logical.StorageEntry{
+		Key:   intermediateCAStoragePrefix + hex.EncodeToString(cert.SubjectKeyId),
+		Value: []byte(certs[0]),
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"certificate":          certs[0],
+			"issuing_ca":           b.issuingCA(ctx, req, certs),
+			"serial_number":        serial,
+			"keyfactor_request_id": requestId,
+			"subject_key_id":       hex.EncodeToString(cert.SubjectKeyId),
+		},
+	}, nil
+}
+
+// csrBasicConstraints returns the basic constraints requested by the CSR, or
+// nil if it requests none.
+func csrBasicConstraints(csr *x509.CertificateRequest) (*basicConstraints, error) {
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(oidExtensionBasicConstraints) {
+			continue
+		}
+		constraints := &basicConstraints{MaxPathLen: -1}
+		if _, err := asn1.Unmarshal(ext.Value, constraints); err != nil {
+			return nil, fmt.Errorf("unable to parse the basic constraints of the csr: %w", err)
+		}
+		return constraints, nil
+	}
+	return nil, nil
+}
+
+// getIntermediateCA returns the intermediate CA certificate with the given
+// Subject Key Identifier signed with sign-intermediate, or nil if there is none.
+func getIntermediateCA(ctx context.Context, s logical.Storage, subjectKeyId []byte) (*x509.Certificate, error) {
+	entry, err := s.Get(ctx, intermediateCAStoragePrefix+hex.EncodeToString(subjectKeyId))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	return parseCertificatePEM(entry.Value)
+}
+
+const pathSignIntermediateHelpSyn = `
+Sign an intermediate CA certificate with a Keyfactor CA.
+`
+
+const pathSignIntermediateHelpDesc = `
+This submits the CSR of an intermediate CA to Keyfactor with the given CA-profile "template".
+The CSR must request basic constraints with CA:true, and when "path_length" is 0 or greater it
+must request that path length constraint. The CSR is not checked against a role.
+
+The signed certificate is stored under its Subject Key Identifier so that chains of
+certificates it issues can be built without looking it up in Keyfactor. This path requires
+sudo capability.
+`
@@ -0,0 +1,162 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// spiffeScheme is the URI scheme of SPIFFE IDs.
+const spiffeScheme = "spiffe"
+
+// spiffeTrustDomainRegex matches the trust domains permitted by the SPIFFE ID
+// specification.
+var spiffeTrustDomainRegex = regexp.MustCompile(`^[a-z0-9._-]+$`)
+
+// validateSpiffeTrustDomain checks the spiffe_trust_domain of a role.
+func validateSpiffeTrustDomain(trustDomain string) error {
+	if trustDomain != "" && !spiffeTrustDomainRegex.MatchString(trustDomain) {
+		return fmt.Errorf("invalid spiffe_trust_domain %q; it may only contain lowercase letters, digits, dots, hyphens and underscores", trustDomain)
+	}
+	return nil
+}
+
+// spiffeID returns the SPIFFE ID requested by an SVID CSR, checking that it
+// carries exactly one spiffe URI SAN in the trust domain and no DNS or IP
+// SANs.
+func spiffeID(csr *x509.CertificateRequest, trustDomain string) (string, error) {
+	if len(csr.DNSNames) > 0 || len(csr.IPAddresses) > 0 {
+		return "", errors.New("an SVID must not contain DNS or IP SANs")
+	}
+	if len(csr.URIs) != 1 {
+		return "", fmt.Errorf("an SVID must contain exactly one URI SAN, but the csr contains %d", len(csr.URIs))
+	}
+	id := csr.URIs[0]
+	if id.Scheme != spiffeScheme {
+		return "", fmt.Errorf("the URI SAN %q is not a SPIFFE ID", id.String())
+	}
+	if id.User != nil || id.Port() != "" || id.RawQuery != "" || id.Fragment != "" {
+		return "", fmt.Errorf("the SPIFFE ID %q must not contain a user, port, query or fragment", id.String())
+	}
+	if id.Hostname() != trustDomain {
+		return "", fmt.Errorf("the SPIFFE ID %q is not in the trust domain %q of the role", id.String(), trustDomain)
+	}
+	if id.Path == "" || id.Path == "/" {
+		return "", fmt.Errorf("the SPIFFE ID %q must have a path identifying the workload", id.String())
+	}
+	return id.String(), nil
+}
+
+func pathSignSpiffe(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "sign-spiffe/" + framework.GenericNameRegex("role"),
+			Fields: addNonCACommonFields(map[string]*framework.FieldSchema{
+				"csr": {
+					Type:        framework.TypeString,
+					Description: `PEM-format SVID CSR to be signed, with a single spiffe:// URI SAN and no DNS or IP SANs.`,
+					Required:    true,
+				},
+			}),
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathSignSpiffe,
+			},
+
+			HelpSynopsis:    pathSignSpiffeHelpSyn,
+			HelpDescription: pathSignSpiffeHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathSignSpiffe(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role").(string)
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
+	}
+	if role.SpiffeTrustDomain == "" {
+		return logical.ErrorResponse(fmt.Sprintf("role %q has no spiffe_trust_domain and cannot sign SVIDs", roleName)), nil
+	}
+
+	parsedCSR, csr, err := parseCSRPEM(data.Get("csr").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := parsedCSR.CheckSignature(); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid csr signature: %s", err)), nil
+	}
+	if err := checkMinKeyStrength(role, parsedCSR.PublicKey); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := checkSignatureAlgorithm(role, parsedCSR.SignatureAlgorithm); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	id, err := spiffeID(parsedCSR, role.SpiffeTrustDomain)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	metadata := data.Get("metadata").(string)
+	if metadata == "" {
+		metadata = "{}"
+	}
+	if !b.isValidJSON(metadata) {
+		return logical.ErrorResponse(fmt.Sprintf("'%s' is not a valid JSON string", metadata)), nil
+	}
+
+	timeout, err := b.resolveOperationTimeout(ctx, req.Storage, operationSign, data)
+	if err != nil {
+		return nil, err
+	}
+	certs, serial, requestId, err := b.submitCSR(withOperationTimeout(ctx, timeout), req, roleName, csr, data.Get("ca").(string), data.Get("template").(string), metadata, data.Get("custom_fields").(map[string]string), data.Get("comment").(string), false)
+	if err != nil {
+		if timeoutResp, ok := timeoutErrorResponse(err, timeout); ok {
+			return timeoutResp, nil
+		}
+		return keyfactorErrorResponse("could not sign svid csr", err)
+	}
+	b.logOCSPServerURL(ctx, req.Storage, role)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"certificate":          certs[0],
+			"issuing_ca":           b.issuingCA(ctx, req, certs),
+			"serial_number":        serial,
+			"keyfactor_request_id": requestId,
+			"spiffe_id":            id,
+		},
+	}, nil
+}
+
+const pathSignSpiffeHelpSyn = `
+Sign a SPIFFE SVID CSR according to the trust domain of a role.
+`
+
+const pathSignSpiffeHelpDesc = `
+This path signs X.509 SVID CSRs for SPIFFE workloads. The CSR must contain exactly one URI SAN,
+a spiffe:// ID in the spiffe_trust_domain of the role with a path identifying the workload,
+and no DNS or IP SANs, as required by the SPIFFE X.509-SVID specification. The allowed domains
+of the role are not checked.
+
+The Keyfactor template must produce certificates that meet the X.509-SVID requirements, such
+as copying the URI SAN from the CSR. The SPIFFE ID is returned in "spiffe_id" with the
+standard certificate fields.
+`
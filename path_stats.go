@@ -0,0 +1,162 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// statsDailyStoragePrefix holds the daily counters of issued and revoked
+// certificates, as stats/daily/<YYYY-MM-DD>/<event>.
+const statsDailyStoragePrefix = "stats/daily/"
+
+// Events counted by the daily counters.
+const (
+	statsEventIssued  = "issued"
+	statsEventRevoked = "revoked"
+)
+
+const (
+	// maxStatsHistoryDays is the longest range stats/history returns.
+	maxStatsHistoryDays = 365
+
+	// defaultStatsHistoryDays is the range returned when from is omitted.
+	defaultStatsHistoryDays = 30
+)
+
+func pathStats(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `stats/history`,
+			Fields: map[string]*framework.FieldSchema{
+				"from": {
+					Type:        framework.TypeString,
+					Description: `The first day of the range, as YYYY-MM-DD in UTC. Defaults to 29 days before "to".`,
+				},
+				"to": {
+					Type:        framework.TypeString,
+					Description: `The last day of the range, as YYYY-MM-DD in UTC. Defaults to today.`,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathStatsHistoryRead,
+			},
+
+			HelpSynopsis:    pathStatsHistoryHelpSyn,
+			HelpDescription: pathStatsHistoryHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathStatsHistoryRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if raw := data.Get("to").(string); raw != "" {
+		parsed, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid to %q; must be a date as YYYY-MM-DD", raw)), nil
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -(defaultStatsHistoryDays - 1))
+	if raw := data.Get("from").(string); raw != "" {
+		parsed, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid from %q; must be a date as YYYY-MM-DD", raw)), nil
+		}
+		from = parsed
+	}
+	if from.After(to) {
+		return logical.ErrorResponse("from must not be after to"), nil
+	}
+	if days := int(to.Sub(from).Hours()/24) + 1; days > maxStatsHistoryDays {
+		return logical.ErrorResponse(fmt.Sprintf("the range covers %d days but at most %d may be requested", days, maxStatsHistoryDays)), nil
+	}
+
+	var history []map[string]interface{}
+	var totalIssued, totalRevoked int
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		issued, err := dailyStat(ctx, req.Storage, date, statsEventIssued)
+		if err != nil {
+			return nil, err
+		}
+		revoked, err := dailyStat(ctx, req.Storage, date, statsEventRevoked)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, map[string]interface{}{
+			"date":    date,
+			"issued":  issued,
+			"revoked": revoked,
+		})
+		totalIssued += issued
+		totalRevoked += revoked
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"from":    from.Format(time.DateOnly),
+			"to":      to.Format(time.DateOnly),
+			"history": history,
+			"issued":  totalIssued,
+			"revoked": totalRevoked,
+		},
+	}, nil
+}
+
+// countDailyStat increments the counter of the event for the current UTC day.
+func (b *keyfactorBackend) countDailyStat(ctx context.Context, s logical.Storage, event string) error {
+	b.statsLock.Lock()
+	defer b.statsLock.Unlock()
+
+	date := time.Now().UTC().Format(time.DateOnly)
+	count, err := dailyStat(ctx, s, date, event)
+	if err != nil {
+		return err
+	}
+	entry, err := logical.StorageEntryJSON(statsDailyStoragePrefix+date+"/"+event, count+1)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// dailyStat returns the counter of the event on the given day.
+func dailyStat(ctx context.Context, s logical.Storage, date string, event string) (int, error) {
+	entry, err := s.Get(ctx, statsDailyStoragePrefix+date+"/"+event)
+	if err != nil {
+		return 0, err
+	}
+	if entry == nil {
+		return 0, nil
+	}
+	var count int
+	if err := entry.DecodeJSON(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+const pathStatsHistoryHelpSyn = `
+Read the daily counts of issued and revoked certificates.
+`
+
+const pathStatsHistoryHelpDesc = `
+This returns, in "history", the number of certificates issued and revoked on each UTC day from
+"from" to "to", along with the totals for the range in "issued" and "revoked". The range
+defaults to the last 30 days and may cover at most 365 days. The counters are updated on every
+issuance and revocation; days before they were introduced are reported as zero.
+`
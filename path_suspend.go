@@ -0,0 +1,260 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/errutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const kf_remove_hold_path = "/Certificates/RemoveHold"
+
+// keyfactorRemoveHoldRequest is the body of a Keyfactor request lifting a
+// certificate hold.
+type keyfactorRemoveHoldRequest struct {
+	CertificateIds []int  `json:"CertificateIds"`
+	Comment        string `json:"Comment"`
+	CollectionId   int    `json:"CollectionId"`
+}
+
+func pathSuspend(b *keyfactorBackend) []*framework.Path {
+	fields := map[string]*framework.FieldSchema{
+		"serial": {
+			Type: framework.TypeString,
+			Description: `Certificate serial number, in colon- or
+hyphen-separated octal`,
+			Required: true,
+		},
+		"timeout": {
+			Type:        framework.TypeDurationSecond,
+			Description: `The maximum time to wait for Keyfactor, capped at the configured revoke_timeout.`,
+		},
+	}
+
+	return []*framework.Path{
+		{
+			Pattern: `suspend`,
+			Fields:  fields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathSuspendCert,
+			},
+
+			HelpSynopsis:    pathSuspendHelpSyn,
+			HelpDescription: pathSuspendHelpDesc,
+		},
+		{
+			Pattern: `unsuspend`,
+			Fields:  fields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathUnsuspendCert,
+			},
+
+			HelpSynopsis:    pathUnsuspendHelpSyn,
+			HelpDescription: pathUnsuspendHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathSuspendCert(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	serial := normalizeSerial(data.Get("serial").(string))
+	if len(serial) == 0 {
+		return logical.ErrorResponse("The serial number must be provided"), nil
+	}
+
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+
+	certEntry, err := fetchCertBySerial(ctx, req, "certs/", serial)
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), nil
+		default:
+			return nil, err
+		}
+	}
+	if certEntry == nil {
+		return logical.ErrorResponse(fmt.Sprintf("certificate with serial %s not found", serial)), nil
+	}
+
+	revokedEntry, err := req.Storage.Get(ctx, "revoked/"+serial)
+	if err != nil {
+		return nil, err
+	}
+	if revokedEntry != nil {
+		return logical.ErrorResponse(fmt.Sprintf("certificate with serial %s is revoked and cannot be suspended", serial)), nil
+	}
+
+	suspendedEntry, err := req.Storage.Get(ctx, "suspended/"+serial)
+	if err != nil {
+		return nil, err
+	}
+	if suspendedEntry != nil {
+		var info revocationInfo
+		if err := suspendedEntry.DecodeJSON(&info); err != nil {
+			return nil, fmt.Errorf("error decoding existing suspension info: %w", err)
+		}
+		return suspensionResponse(&info), nil
+	}
+
+	keyfactorId, resp, err := storedKeyfactorId(ctx, req, serial)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	instance, err := getCertInstance(ctx, req.Storage, serial)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := b.resolveOperationTimeout(ctx, req.Storage, operationRevoke, data)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []revokeCandidate{{serial: serial, keyfactorId: keyfactorId, certBytes: certEntry.Value}}
+	err = b.sendBatchRevocation(withOperationTimeout(ctx, timeout), req, instance, candidates, revocationReasonCertificateHold)
+	if timeoutResp, ok := timeoutErrorResponse(err, timeout); ok {
+		return timeoutResp, nil
+	}
+	if err != nil {
+		b.Logger().Error("Suspend failed: " + err.Error())
+		return keyfactorErrorResponse("suspension failed", err)
+	}
+
+	now := time.Now()
+	info := &revocationInfo{
+		CertificateBytes:  certEntry.Value,
+		RevocationTime:    now.Unix(),
+		RevocationTimeUTC: now.UTC(),
+		RevocationReason:  revocationReasonCertificateHold,
+	}
+	entry, err := logical.StorageEntryJSON("suspended/"+serial, info)
+	if err != nil {
+		return nil, fmt.Errorf("error creating suspension entry")
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, fmt.Errorf("error saving suspended certificate: %w", err)
+	}
+	b.Logger().Info("suspended certificate", "serial", serial)
+
+	return suspensionResponse(info), nil
+}
+
+func (b *keyfactorBackend) pathUnsuspendCert(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	serial := normalizeSerial(data.Get("serial").(string))
+	if len(serial) == 0 {
+		return logical.ErrorResponse("The serial number must be provided"), nil
+	}
+
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+
+	suspendedEntry, err := req.Storage.Get(ctx, "suspended/"+serial)
+	if err != nil {
+		return nil, err
+	}
+	if suspendedEntry == nil {
+		return logical.ErrorResponse(fmt.Sprintf("certificate with serial %s is not suspended", serial)), nil
+	}
+
+	keyfactorId, resp, err := storedKeyfactorId(ctx, req, serial)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	instance, err := getCertInstance(ctx, req.Storage, serial)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := b.resolveOperationTimeout(ctx, req.Storage, operationRevoke, data)
+	if err != nil {
+		return nil, err
+	}
+	body := keyfactorRemoveHoldRequest{
+		CertificateIds: []int{keyfactorId},
+		Comment:        "via HashiCorp Vault",
+	}
+	_, err = b.sendJSONToInstance(withOperationTimeout(ctx, timeout), req, instance, http.MethodPost, kf_remove_hold_path, body)
+	if timeoutResp, ok := timeoutErrorResponse(err, timeout); ok {
+		return timeoutResp, nil
+	}
+	if err != nil {
+		b.Logger().Error("Unsuspend failed: " + err.Error())
+		return keyfactorErrorResponse("removing the certificate hold failed", err)
+	}
+
+	if err := req.Storage.Delete(ctx, "suspended/"+serial); err != nil {
+		return nil, fmt.Errorf("hold removed in Keyfactor but unable to delete the local suspension: %w", err)
+	}
+	b.Logger().Info("unsuspended certificate", "serial", serial)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"serial_number": serial,
+			"suspended":     false,
+		},
+	}, nil
+}
+
+// storedKeyfactorId returns the Keyfactor certificate ID stored for serial, or
+// an error response if there is none.
+func storedKeyfactorId(ctx context.Context, req *logical.Request, serial string) (int, *logical.Response, error) {
+	kfIdEntry, err := req.Storage.Get(ctx, "kfId/"+serial)
+	if err != nil {
+		return 0, nil, err
+	}
+	if kfIdEntry == nil {
+		return 0, logical.ErrorResponse(fmt.Sprintf("no Keyfactor certificate ID is stored for serial %s", serial)), nil
+	}
+	keyfactorId, err := decodeKeyfactorId(kfIdEntry)
+	if err != nil {
+		return 0, nil, fmt.Errorf("unable to parse stored certificate ID for serial %s: %w", serial, err)
+	}
+	return keyfactorId, nil, nil
+}
+
+func suspensionResponse(info *revocationInfo) *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"suspended":               true,
+			"suspension_time":         info.RevocationTime,
+			"suspension_time_rfc3339": info.RevocationTimeUTC.Format(time.RFC3339),
+		},
+	}
+}
+
+const pathSuspendHelpSyn = `
+Suspend a certificate by placing it on hold.
+`
+
+const pathSuspendHelpDesc = `
+This revokes the certificate in Keyfactor with the RFC 5280 CertificateHold reason (6), a
+temporary suspension that can be lifted with the unsuspend path. The suspension is recorded
+under "suspended/" rather than "revoked/". Revoked certificates cannot be suspended.
+`
+
+const pathUnsuspendHelpSyn = `
+Lift the suspension of a certificate.
+`
+
+const pathUnsuspendHelpDesc = `
+This removes the hold on a certificate suspended with the suspend path by calling the Keyfactor
+RemoveHold API, and deletes the local suspension record.
+`
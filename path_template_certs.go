@@ -0,0 +1,82 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathTemplateCerts(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `templates/` + framework.GenericNameRegex("template_name") + `/certs/?$`,
+			Fields: map[string]*framework.FieldSchema{
+				"template_name": {
+					Type:        framework.TypeString,
+					Description: `The name of the Keyfactor template.`,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.pathTemplateCertsList,
+			},
+
+			HelpSynopsis:    pathTemplateCertsHelpSyn,
+			HelpDescription: pathTemplateCertsHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathTemplateCertsList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	templateName := data.Get("template_name").(string)
+	if templateName == "" {
+		return logical.ErrorResponse("the template_name must be provided"), nil
+	}
+
+	serials, err := req.Storage.List(ctx, templateCertsStoragePrefix+templateName+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := make(map[string]interface{}, len(serials))
+	revokedCount := 0
+	for _, serial := range serials {
+		revokedEntry, err := req.Storage.Get(ctx, "revoked/"+serial)
+		if err != nil {
+			return nil, err
+		}
+		revoked := revokedEntry != nil
+		if revoked {
+			revokedCount++
+		}
+		keyInfo[serial] = map[string]interface{}{
+			"revoked": revoked,
+		}
+	}
+
+	resp := logical.ListResponseWithInfo(serials, keyInfo)
+	resp.Data["count"] = len(serials)
+	resp.Data["revoked_count"] = revokedCount
+	return resp, nil
+}
+
+const pathTemplateCertsHelpSyn = `
+List the certificates issued with a Keyfactor template.
+`
+
+const pathTemplateCertsHelpDesc = `
+This lists the serial numbers of the certificates issued with the given Keyfactor template,
+read from an index that is updated on every issuance. The response includes the number of
+certificates in "count" and how many of them are revoked in "revoked_count". Certificates
+issued before the index was introduced are not listed.
+`
@@ -0,0 +1,203 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const defaultRevokedRetention = 72 * time.Hour
+
+func pathTidy(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "tidy/?$",
+
+			Fields: map[string]*framework.FieldSchema{
+				"tidy_revoked": {
+					Type:        framework.TypeBool,
+					Description: `Set to true to delete revoked certificate entries for certificates that expired more than "revoked_retention" ago. Otherwise they are only reported.`,
+					Default:     false,
+				},
+				"revoked_retention": {
+					Type:        framework.TypeDurationSecond,
+					Description: `How long after expiry a revoked certificate entry is kept before it is considered for tidying. Defaults to 72h.`,
+					Default:     int(defaultRevokedRetention.Seconds()),
+				},
+				"migrate_keyfactor_ids": {
+					Type:        framework.TypeBool,
+					Description: `Set to true to rewrite every stored Keyfactor certificate ID in the configured keyfactor_id_format.`,
+					Default:     false,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathTidyWrite,
+			},
+
+			HelpSynopsis:    pathTidyHelpSyn,
+			HelpDescription: pathTidyHelpDesc,
+		},
+	}
+}
+
+func (b *keyfactorBackend) pathTidyWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	tidyRevoked := data.Get("tidy_revoked").(bool)
+	migrateKfIds := data.Get("migrate_keyfactor_ids").(bool)
+	retention := time.Duration(data.Get("revoked_retention").(int)) * time.Second
+	if retention < 0 {
+		return logical.ErrorResponse("revoked_retention must not be negative"), nil
+	}
+
+	if (tidyRevoked || migrateKfIds) && b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+
+	certs, err := req.Storage.List(ctx, "certs/")
+	if err != nil {
+		return nil, err
+	}
+	kfIds, err := req.Storage.List(ctx, "kfId/")
+	if err != nil {
+		return nil, err
+	}
+
+	certSet := make(map[string]bool, len(certs))
+	for _, serial := range certs {
+		certSet[serial] = true
+	}
+	kfIdSet := make(map[string]bool, len(kfIds))
+	for _, serial := range kfIds {
+		kfIdSet[serial] = true
+	}
+
+	// (1) Keyfactor IDs stored without a certificate
+	orphanedKfIds := []string{}
+	for _, serial := range kfIds {
+		if !certSet[serial] {
+			orphanedKfIds = append(orphanedKfIds, serial)
+		}
+	}
+
+	// (2) certificates stored without a Keyfactor ID
+	orphanedCerts := []string{}
+	for _, serial := range certs {
+		if !kfIdSet[serial] {
+			orphanedCerts = append(orphanedCerts, serial)
+		}
+	}
+
+	// (3) revoked entries for certificates that expired before the retention period
+	revoked, err := req.Storage.List(ctx, "revoked/")
+	if err != nil {
+		return nil, err
+	}
+	expiredRevoked := []string{}
+	deletedRevoked := []string{}
+	cutoff := time.Now().Add(-retention)
+	for _, serial := range revoked {
+		revokedEntry, err := req.Storage.Get(ctx, "revoked/"+serial)
+		if err != nil {
+			return nil, err
+		}
+		if revokedEntry == nil {
+			continue
+		}
+		var revInfo revocationInfo
+		if err := revokedEntry.DecodeJSON(&revInfo); err != nil {
+			b.Logger().Warn("unable to decode revocation entry during tidy", "serial", serial, "error", err)
+			continue
+		}
+		cert, err := parseCertificatePEM(revInfo.CertificateBytes)
+		if err != nil {
+			b.Logger().Warn("unable to parse revoked certificate during tidy", "serial", serial, "error", err)
+			continue
+		}
+		if cert.NotAfter.After(cutoff) {
+			continue
+		}
+		expiredRevoked = append(expiredRevoked, serial)
+
+		if tidyRevoked {
+			if err := req.Storage.Delete(ctx, "revoked/"+serial); err != nil {
+				return nil, fmt.Errorf("error deleting revoked certificate %s: %w", serial, err)
+			}
+			deletedRevoked = append(deletedRevoked, serial)
+		}
+	}
+
+	// (4) Keyfactor IDs stored in another format than keyfactor_id_format
+	migratedKfIds := 0
+	if migrateKfIds {
+		config, err := b.fetchConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		format := config.keyfactorIdFormat()
+		for _, serial := range kfIds {
+			kfIdEntry, err := req.Storage.Get(ctx, "kfId/"+serial)
+			if err != nil {
+				return nil, err
+			}
+			if kfIdEntry == nil {
+				continue
+			}
+			keyfactorId, requestId, err := decodeKeyfactorIdEntry(kfIdEntry)
+			if err != nil {
+				b.Logger().Warn("unable to decode Keyfactor ID during tidy", "serial", serial, "error", err)
+				continue
+			}
+			migrated, err := keyfactorIdStorageEntry(serial, keyfactorId, requestId, format)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(migrated.Value, kfIdEntry.Value) {
+				continue
+			}
+			if err := req.Storage.Put(ctx, migrated); err != nil {
+				return nil, fmt.Errorf("error migrating the Keyfactor ID of %s: %w", serial, err)
+			}
+			migratedKfIds++
+		}
+	}
+
+	b.Logger().Info("tidy complete", "orphaned_kf_ids", len(orphanedKfIds), "orphaned_certs", len(orphanedCerts), "expired_revoked", len(expiredRevoked), "deleted_revoked", len(deletedRevoked), "migrated_kf_ids", migratedKfIds)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"orphaned_kf_ids": orphanedKfIds,
+			"orphaned_certs":  orphanedCerts,
+			"expired_revoked": expiredRevoked,
+			"deleted_revoked": deletedRevoked,
+			"migrated_kf_ids": migratedKfIds,
+		},
+	}, nil
+}
+
+const pathTidyHelpSyn = `
+Report and clean up inconsistencies in the storage of this secrets engine.
+`
+
+const pathTidyHelpDesc = `
+This endpoint reports Keyfactor certificate IDs stored without a matching certificate,
+certificates stored without a Keyfactor certificate ID, and revoked certificate entries
+for certificates that expired more than "revoked_retention" ago.
+
+Orphaned entries are only reported. Expired revoked entries are deleted when
+"tidy_revoked" is set to true. When "migrate_keyfactor_ids" is set to true, stored
+Keyfactor certificate IDs are rewritten in the configured keyfactor_id_format.
+`
@@ -0,0 +1,170 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// caListCacheKey is the storage key of the cached list of CAs.
+const caListCacheKey = "ca_list_cache"
+
+// keyfactorCA is the subset of a Keyfactor certificate authority the backend uses.
+type keyfactorCA struct {
+	Id          int    `json:"Id"`
+	LogicalName string `json:"LogicalName"`
+	HostName    string `json:"HostName"`
+}
+
+// caListCache is the list of CAs cached in storage.
+type caListCache struct {
+	CAs       []keyfactorCA `json:"cas"`
+	FetchedAt time.Time     `json:"fetched_at"`
+}
+
+func pathWarmUp(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: `warm-up`,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathWarmUpWrite,
+			},
+
+			HelpSynopsis:    pathWarmUpHelpSyn,
+			HelpDescription: pathWarmUpHelpDesc,
+		},
+	}
+}
+
+// pathWarmUpWrite fetches the CA list, the templates of the configured CA
+// and the CA chain from Keyfactor and caches them.  Each step is attempted
+// even if an earlier one fails, and failures are reported as warnings.
+func (b *keyfactorBackend) pathWarmUpWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.fetchConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("the backend must be configured before it can be warmed up"), nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{},
+	}
+
+	cas, err := b.getCAs(ctx, req, true)
+	if err != nil {
+		resp.AddWarning(fmt.Sprintf("unable to fetch the CA list: %s", err))
+	} else {
+		resp.Data["cas"] = len(cas)
+	}
+
+	if config.CertAuthority == "" {
+		resp.AddWarning("no CA is configured; templates and the CA chain were not fetched")
+		return resp, nil
+	}
+	caParts := strings.Split(config.CertAuthority, `\\`)
+	caName := caParts[len(caParts)-1]
+
+	if err := req.Storage.Delete(ctx, templateCachePrefix+strings.ToLower(caName)); err != nil {
+		return nil, err
+	}
+	templates, err := b.getCATemplates(ctx, req, caName)
+	if err != nil {
+		resp.AddWarning(fmt.Sprintf("unable to fetch the templates of CA %s: %s", caName, err))
+	} else {
+		resp.Data["templates"] = len(templates)
+	}
+
+	if err := req.Storage.Delete(ctx, caChainCacheKey); err != nil {
+		return nil, err
+	}
+	chain, err := b.getCAChain(ctx, req)
+	if err != nil {
+		resp.AddWarning(fmt.Sprintf("unable to fetch the CA chain: %s", err))
+	} else {
+		resp.Data["ca_chain_length"] = len(chain)
+	}
+
+	for _, warning := range resp.Warnings {
+		b.Logger().Warn("warm-up: " + warning)
+	}
+
+	return resp, nil
+}
+
+// getCAs returns the certificate authorities known to Keyfactor.  The list is
+// cached in storage for the configured template cache TTL unless refresh is set.
+func (b *keyfactorBackend) getCAs(ctx context.Context, req *logical.Request, refresh bool) ([]keyfactorCA, error) {
+	config, err := b.fetchConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("unable to load configuration")
+	}
+	ttl := config.TemplateCacheTTL
+	if ttl <= 0 {
+		ttl = defaultTemplateCacheTTL
+	}
+
+	if !refresh {
+		cacheEntry, err := req.Storage.Get(ctx, caListCacheKey)
+		if err != nil {
+			return nil, err
+		}
+		if cacheEntry != nil {
+			var cache caListCache
+			if err := cacheEntry.DecodeJSON(&cache); err == nil && time.Since(cache.FetchedAt) < ttl {
+				return cache.CAs, nil
+			}
+		}
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+	var cas []keyfactorCA
+	if err := b.getKeyfactorJSON(ctx, req.Storage, client, config, "/CertificateAuthority", &cas); err != nil {
+		return nil, err
+	}
+
+	cacheEntry, err := logical.StorageEntryJSON(caListCacheKey, &caListCache{
+		CAs:       cas,
+		FetchedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, cacheEntry); err != nil {
+		b.Logger().Warn("unable to cache the CA list", "error", err)
+	}
+
+	return cas, nil
+}
+
+const pathWarmUpHelpSyn = `
+Pre-fetch and cache metadata from Keyfactor.
+`
+
+const pathWarmUpHelpDesc = `
+This fetches the list of CAs, the templates available on the configured CA, and the chain of the
+configured CA from Keyfactor and caches them, so that the first requests after a restart do not
+have to. Steps that fail are reported as warnings, which also surfaces configuration errors early.
+`
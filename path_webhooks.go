@@ -0,0 +1,329 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// webhookPrefix is the storage prefix of the webhooks, keyed by name.
+const webhookPrefix = "webhooks/"
+
+// Certificate lifecycle events webhooks can subscribe to.
+const (
+	webhookEventIssue  = "issue"
+	webhookEventRevoke = "revoke"
+	webhookEventExpiry = "expiry"
+)
+
+const (
+	// defaultWebhookTimeout is used for webhooks without a timeout.
+	defaultWebhookTimeout = 10 * time.Second
+
+	// webhookWorkers is the number of goroutines delivering webhooks.
+	webhookWorkers = 4
+
+	// webhookQueueSize is the number of deliveries that may wait for a
+	// worker; further deliveries are dropped.
+	webhookQueueSize = 100
+
+	// webhookSignatureHeader holds the hex HMAC-SHA256 of the payload.
+	webhookSignatureHeader = "X-Keyfactor-Vault-Signature"
+)
+
+// webhook is a URL notified of certificate lifecycle events.
+type webhook struct {
+	URL     string        `json:"url"`
+	Events  []string      `json:"events"`
+	Secret  string        `json:"secret"`
+	Timeout time.Duration `json:"timeout"`
+}
+
+// webhookPayload is the body POSTed to a webhook.
+type webhookPayload struct {
+	Event        string `json:"event"`
+	SerialNumber string `json:"serial_number"`
+	CommonName   string `json:"common_name"`
+	Timestamp    string `json:"timestamp"`
+}
+
+// webhookDelivery is a payload waiting to be sent to a webhook.
+type webhookDelivery struct {
+	name    string
+	hook    *webhook
+	payload webhookPayload
+}
+
+func pathWebhooks(b *keyfactorBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "webhooks/" + framework.GenericNameRegex("name"),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "The name of the webhook.",
+				},
+				"url": {
+					Type:        framework.TypeString,
+					Description: "The http or https URL the events are POSTed to.",
+				},
+				"events": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: `The events to send: any of "issue", "revoke" and "expiry".`,
+				},
+				"secret": {
+					Type:        framework.TypeString,
+					Description: "The key the payloads are signed with using HMAC-SHA256.",
+					DisplayAttrs: &framework.DisplayAttributes{
+						Sensitive: true,
+					},
+				},
+				"timeout": {
+					Type:        framework.TypeDurationSecond,
+					Description: "The maximum time to wait for the webhook to respond.",
+					Default:     int(defaultWebhookTimeout.Seconds()),
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   b.pathWebhookRead,
+				logical.UpdateOperation: b.pathWebhookWrite,
+				logical.DeleteOperation: b.pathWebhookDelete,
+			},
+
+			HelpSynopsis:    pathWebhookHelpSyn,
+			HelpDescription: pathWebhookHelpDesc,
+		},
+		{
+			Pattern: "webhooks/?$",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.pathWebhookList,
+			},
+
+			HelpSynopsis:    pathWebhookHelpSyn,
+			HelpDescription: pathWebhookHelpDesc,
+		},
+	}
+}
+
+func getWebhook(ctx context.Context, s logical.Storage, name string) (*webhook, error) {
+	entry, err := s.Get(ctx, webhookPrefix+name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result webhook
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (b *keyfactorBackend) pathWebhookRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	hook, err := getWebhook(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if hook == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"url":     hook.URL,
+			"events":  hook.Events,
+			"secret":  redactSensitive(hook.Secret),
+			"timeout": int64(hook.Timeout.Seconds()),
+		},
+	}, nil
+}
+
+func (b *keyfactorBackend) pathWebhookWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	hook := &webhook{
+		URL:     data.Get("url").(string),
+		Events:  data.Get("events").([]string),
+		Secret:  data.Get("secret").(string),
+		Timeout: time.Duration(data.Get("timeout").(int)) * time.Second,
+	}
+
+	parsed, err := url.Parse(hook.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return logical.ErrorResponse("url must be an http or https URL"), nil
+	}
+	if len(hook.Events) == 0 {
+		return logical.ErrorResponse("at least one event must be provided"), nil
+	}
+	for _, event := range hook.Events {
+		switch event {
+		case webhookEventIssue, webhookEventRevoke, webhookEventExpiry:
+		default:
+			return logical.ErrorResponse(fmt.Sprintf("unknown event %q; events must be issue, revoke or expiry", event)), nil
+		}
+	}
+	if hook.Secret == "" {
+		return logical.ErrorResponse("secret must be provided"), nil
+	}
+	if hook.Timeout <= 0 {
+		hook.Timeout = defaultWebhookTimeout
+	}
+
+	entry, err := logical.StorageEntryJSON(webhookPrefix+data.Get("name").(string), hook)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *keyfactorBackend) pathWebhookDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := req.Storage.Delete(ctx, webhookPrefix+data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *keyfactorBackend) pathWebhookList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, webhookPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// notifyWebhooks queues the event for delivery to every webhook subscribed
+// to it.  Deliveries are made in the background by a fixed number of
+// workers; failures are logged and not retried.
+func (b *keyfactorBackend) notifyWebhooks(ctx context.Context, s logical.Storage, event string, serial string, commonName string) {
+	names, err := s.List(ctx, webhookPrefix)
+	if err != nil {
+		b.Logger().Warn("unable to list webhooks", "error", err)
+		return
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	payload := webhookPayload{
+		Event:        event,
+		SerialNumber: serial,
+		CommonName:   commonName,
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, name := range names {
+		hook, err := getWebhook(ctx, s, name)
+		if err != nil {
+			b.Logger().Warn("unable to read webhook", "webhook", name, "error", err)
+			continue
+		}
+		if hook == nil || !slices.Contains(hook.Events, event) {
+			continue
+		}
+
+		b.startWebhookWorkers()
+		select {
+		case b.webhookQueue <- webhookDelivery{name: name, hook: hook, payload: payload}:
+		default:
+			b.Logger().Warn("webhook queue is full, dropping event", "webhook", name, "event", event, "serial", serial)
+		}
+	}
+}
+
+// notifyWebhooksForCert is notifyWebhooks for a PEM-encoded certificate.
+func (b *keyfactorBackend) notifyWebhooksForCert(ctx context.Context, s logical.Storage, event string, serial string, certPEM []byte) {
+	commonName := ""
+	if cert, err := parseCertificatePEM(certPEM); err == nil {
+		commonName = cert.Subject.CommonName
+	}
+	b.notifyWebhooks(ctx, s, event, serial, commonName)
+}
+
+// startWebhookWorkers starts the goroutines delivering webhooks the first
+// time it is called.
+func (b *keyfactorBackend) startWebhookWorkers() {
+	b.webhookWorkersOnce.Do(func() {
+		b.webhookQueue = make(chan webhookDelivery, webhookQueueSize)
+		for i := 0; i < webhookWorkers; i++ {
+			go func() {
+				for delivery := range b.webhookQueue {
+					if err := deliverWebhook(delivery.hook, delivery.payload); err != nil {
+						b.Logger().Warn("webhook delivery failed", "webhook", delivery.name, "event", delivery.payload.Event, "serial", delivery.payload.SerialNumber, "error", err)
+					}
+				}
+			}()
+		}
+	})
+}
+
+// deliverWebhook POSTs the payload to the webhook, signed with its secret.
+func deliverWebhook(hook *webhook, payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write(body)
+
+	ctx, cancel := context.WithTimeout(context.Background(), hook.Timeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(webhookSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+
+	res, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+const pathWebhookHelpSyn = `
+Manage webhooks notified of certificate lifecycle events.
+`
+
+const pathWebhookHelpDesc = `
+A webhook receives a JSON POST with "event", "serial_number", "common_name" and "timestamp" when a
+certificate is issued ("issue"), revoked ("revoke") or approaching its expiry ("expiry"), for the
+events it subscribes to. Each payload is signed with the webhook's "secret": the
+X-Keyfactor-Vault-Signature header holds the hex-encoded HMAC-SHA256 of the request body.
+
+Deliveries are made in the background by a fixed pool of workers and are not retried. Events that
+arrive while the delivery queue is full are dropped and logged. Expiry events are sent by the
+periodic function with the expiry events feature flag.
+`
@@ -0,0 +1,85 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// redactedValue replaces the values of sensitive fields in logged requests.
+const redactedValue = "[REDACTED]"
+
+// maxLoggedMetadataValueLength is the length above which string values of
+// the request metadata are redacted in logged requests.
+const maxLoggedMetadataValueLength = 20
+
+// alwaysRedactedRequestFields are the request fields that are never logged,
+// in addition to the sensitive_request_fields of the configuration.
+var alwaysRedactedRequestFields = []string{"csr", "private_key", "password", "oauth_client_secret", "client_secret"}
+
+// logRequest logs the request data at debug level with sensitive fields
+// redacted.
+func (b *keyfactorBackend) logRequest(ctx context.Context, req *logical.Request) {
+	if !b.Logger().IsDebug() {
+		return
+	}
+	var sensitive []string
+	if config, err := b.fetchConfig(ctx, req.Storage); err == nil && config != nil {
+		sensitive = config.SensitiveRequestFields
+	}
+	arg, _ := json.Marshal(redactRequestData(req.Data, sensitive))
+	b.Logger().Debug("request data", "path", req.Path, "data", string(arg))
+}
+
+// redactRequestData returns a copy of the request data in which the values
+// of sensitive fields are replaced by redactedValue.  The keys of the
+// metadata are kept, but its long string values are redacted.
+func redactRequestData(data map[string]interface{}, sensitive []string) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		switch {
+		case slices.Contains(alwaysRedactedRequestFields, key) || slices.Contains(sensitive, key):
+			redacted[key] = redactedValue
+		case key == "metadata":
+			redacted[key] = redactMetadata(value)
+		default:
+			redacted[key] = value
+		}
+	}
+	return redacted
+}
+
+// redactMetadata redacts the long string values of request metadata, which
+// may be a JSON object or a string holding one.
+func redactMetadata(value interface{}) interface{} {
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		raw, isString := value.(string)
+		if !isString || json.Unmarshal([]byte(raw), &metadata) != nil {
+			if isString && len(raw) <= maxLoggedMetadataValueLength {
+				return raw
+			}
+			return redactedValue
+		}
+	}
+
+	redacted := make(map[string]interface{}, len(metadata))
+	for key, v := range metadata {
+		if s, ok := v.(string); ok && len(s) > maxLoggedMetadataValueLength {
+			v = redactedValue
+		}
+		redacted[key] = v
+	}
+	return redacted
+}
@@ -0,0 +1,58 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// defaultMaxResponseBytes is the largest Keyfactor response body read unless
+// keyfactor_max_response_bytes is set.
+const defaultMaxResponseBytes = 1 << 20
+
+// maxResponseBytes returns the configured response size limit, falling back
+// to the default when it is unset.
+func (c *keyfactorConfig) maxResponseBytes() int64 {
+	if c == nil || c.MaxResponseBytes <= 0 {
+		return defaultMaxResponseBytes
+	}
+	return c.MaxResponseBytes
+}
+
+// contextReader stops reading once its context is done, so that a slowly
+// streamed response cannot outlive the request.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// readKeyfactorResponse reads a Keyfactor response body of at most the
+// configured keyfactor_max_response_bytes.
+func readKeyfactorResponse(ctx context.Context, config *keyfactorConfig, body io.Reader) ([]byte, error) {
+	limit := config.maxResponseBytes()
+	data, err := io.ReadAll(&io.LimitedReader{R: &contextReader{ctx: ctx, r: body}, N: limit + 1})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the Keyfactor response: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("the Keyfactor response is larger than keyfactor_max_response_bytes (%d bytes); "+
+			"increase keyfactor_max_response_bytes if the CA legitimately returns responses this large", limit)
+	}
+	return data, nil
+}
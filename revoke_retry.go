@@ -0,0 +1,187 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// revokeRetryQueuePrefix holds the revocations that could not be sent
+	// to Keyfactor, keyed by serial number.
+	revokeRetryQueuePrefix = "revoke_retry_queue/"
+
+	// maxRevokeRetries is the number of retries after which a revocation is
+	// left in the queue for manual resolution.
+	maxRevokeRetries = 5
+
+	// revokeRetryBackoff is the delay before the first retry; it doubles
+	// with every failed retry.
+	revokeRetryBackoff = 5 * time.Minute
+
+	// revokeRetriesPerRun limits the revocations retried by each run of the
+	// periodic function.
+	revokeRetriesPerRun = 20
+)
+
+// revokeRetry is a revocation waiting to be sent to Keyfactor.
+type revokeRetry struct {
+	Serial      string    `json:"serial"`
+	KeyfactorID int       `json:"keyfactor_id"`
+	Instance    string    `json:"instance,omitempty"`
+	Reason      int       `json:"reason,omitempty"`
+	Retries     int       `json:"retries"`
+	NextRetry   time.Time `json:"next_retry"`
+	LastError   string    `json:"last_error"`
+}
+
+// sendRevocation asks the Keyfactor instance that issued a certificate to
+// revoke it with the given RFC 5280 reason.
+func (b *keyfactorBackend) sendRevocation(ctx context.Context, s logical.Storage, instance string, keyfactorId int, reason int) (*http.Response, error) {
+	config, err := b.fetchConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("unable to load configuration")
+	}
+	instanceConfig, err := config.instanceConfig(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	// get client
+	client, err := b.getInstanceClient(ctx, s, instance)
+	if err != nil {
+		return nil, fmt.Errorf("error getting client: %w", err)
+	}
+
+	b.Logger().Debug("Closing idle connections")
+	client.httpClient.CloseIdleConnections()
+
+	// set up keyfactor api request
+	url := instanceConfig.apiURL(instanceConfig.revokeAPIPath())
+	payload := fmt.Sprintf(`{
+		"CertificateIds": [
+		  %d
+		],
+		"Reason": %d,
+		"Comment": "%s",
+		"EffectiveDate": "%s"},
+		"CollectionId": 0
+	  }`, keyfactorId, reason, "via HashiCorp Vault", time.Now().Format(time.RFC3339))
+	b.Logger().Debug("Sending revocation request.  payload =  " + payload)
+	reqCtx, cancel := keyfactorRequestContext(ctx)
+	defer cancel()
+	httpReq, _ := http.NewRequestWithContext(reqCtx, "POST", url, strings.NewReader(payload))
+
+	httpReq.Header.Add("x-keyfactor-requested-with", "APIClient")
+	httpReq.Header.Add("content-type", "application/json")
+
+	res, r, err := b.sendKeyfactorRequestWithRetries(ctx, s, client, httpReq, config.revokeMaxRetries())
+	if err != nil {
+		return res, err
+	}
+
+	b.Logger().Debug("response received.  Status code " + fmt.Sprint(res.StatusCode) + " response body: \n " + string(r[:]))
+	return res, nil
+}
+
+// keyfactorUnreachable reports whether a failed request never reached
+// Keyfactor or Keyfactor was unavailable, so that it may succeed later.
+func keyfactorUnreachable(res *http.Response, err error) bool {
+	if err == nil {
+		return false
+	}
+	return res == nil || res.StatusCode == http.StatusServiceUnavailable
+}
+
+// queueRevocationRetry queues a revocation that could not be sent to
+// Keyfactor for processRevokeRetryQueue.
+func queueRevocationRetry(ctx context.Context, s logical.Storage, serial string, keyfactorId int, instance string, reason int, cause error) error {
+	entry, err := logical.StorageEntryJSON(revokeRetryQueuePrefix+serial, &revokeRetry{
+		Serial:      serial,
+		KeyfactorID: keyfactorId,
+		Instance:    instance,
+		Reason:      reason,
+		NextRetry:   time.Now().Add(revokeRetryBackoff),
+		LastError:   cause.Error(),
+	})
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// processRevokeRetryQueue retries the queued revocations that are due, with
+// exponential back-off.  Revocations that fail maxRevokeRetries times are
+// logged and left in the queue for manual resolution.
+func (b *keyfactorBackend) processRevokeRetryQueue(ctx context.Context, s logical.Storage) error {
+	serials, err := s.List(ctx, revokeRetryQueuePrefix)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	attempted := 0
+	for _, serial := range serials {
+		if attempted >= revokeRetriesPerRun {
+			break
+		}
+		entry, err := s.Get(ctx, revokeRetryQueuePrefix+serial)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+		var retry revokeRetry
+		if err := entry.DecodeJSON(&retry); err != nil {
+			return err
+		}
+		if retry.Retries >= maxRevokeRetries || now.Before(retry.NextRetry) {
+			continue
+		}
+
+		attempted++
+		_, err = b.sendRevocation(ctx, s, retry.Instance, retry.KeyfactorID, retry.Reason)
+		if err == nil {
+			b.Logger().Info("sent queued revocation to Keyfactor", "serial", serial, "retries", retry.Retries+1)
+			if err := s.Delete(ctx, revokeRetryQueuePrefix+serial); err != nil {
+				return err
+			}
+			continue
+		}
+
+		retry.Retries++
+		retry.LastError = err.Error()
+		retry.NextRetry = now.Add(revokeRetryBackoff << retry.Retries)
+		if retry.Retries >= maxRevokeRetries {
+			b.Logger().Error("giving up on revocation in Keyfactor; resolve it manually", "serial", serial, "keyfactor_id", retry.KeyfactorID, "retries", retry.Retries, "error", err)
+		} else {
+			b.Logger().Warn("retry of revocation in Keyfactor failed", "serial", serial, "retries", retry.Retries, "error", err)
+		}
+		entry, err = logical.StorageEntryJSON(revokeRetryQueuePrefix+serial, &retry)
+		if err != nil {
+			return err
+		}
+		if err := s.Put(ctx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/errutil"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// roleCertsStoragePrefix indexes the serial numbers of the certificates
+// issued with each role, as role_certs/<role>/<serial>.
+const roleCertsStoragePrefix = "role_certs/"
+
+// indexRoleCert records that the certificate with the given serial was
+// issued with the role.
+func indexRoleCert(ctx context.Context, s logical.Storage, roleName string, serial string) error {
+	if roleName == "" {
+		return nil
+	}
+	return s.Put(ctx, &logical.StorageEntry{
+		Key: roleCertsStoragePrefix + roleName + "/" + normalizeSerial(serial),
+	})
+}
+
+// lockRoleCertLimit serializes the issuances of a role with
+// max_stored_certs set, from checkRoleCertLimit to purgeRoleCertsOverLimit,
+// so that concurrent issuances cannot both pass the limit.  It returns the
+// function releasing the lock.
+func (b *keyfactorBackend) lockRoleCertLimit(roleName string, role *roleEntry) func() {
+	if role.MaxStoredCerts <= 0 {
+		return func() {}
+	}
+	lock := locksutil.LockForKey(b.roleCertLocks, roleName)
+	lock.Lock()
+	return lock.Unlock
+}
+
+// checkRoleCertLimit returns a user error if the role already stores
+// max_stored_certs certificates and purge_oldest_on_limit is not set.  Roles
+// that purge are checked by purgeRoleCertsOverLimit once the new certificate
+// has been issued, so that nothing is revoked for an issuance that fails.
+func checkRoleCertLimit(ctx context.Context, s logical.Storage, roleName string, role *roleEntry) error {
+	if role.MaxStoredCerts <= 0 || role.PurgeOldestOnLimit {
+		return nil
+	}
+	serials, err := s.List(ctx, roleCertsStoragePrefix+roleName+"/")
+	if err != nil {
+		return err
+	}
+	if len(serials) >= role.MaxStoredCerts {
+		return errutil.UserError{Err: fmt.Sprintf("role %s has reached its limit of %d stored certificates", roleName, role.MaxStoredCerts)}
+	}
+	return nil
+}
+
+// purgeRoleCertsOverLimit revokes the oldest certificates of a role with
+// purge_oldest_on_limit set and removes them from its index until it stores
+// at most max_stored_certs certificates.
+func (b *keyfactorBackend) purgeRoleCertsOverLimit(ctx context.Context, req *logical.Request, roleName string, role *roleEntry) error {
+	if role.MaxStoredCerts <= 0 || !role.PurgeOldestOnLimit {
+		return nil
+	}
+	serials, err := req.Storage.List(ctx, roleCertsStoragePrefix+roleName+"/")
+	if err != nil {
+		return err
+	}
+	if len(serials) <= role.MaxStoredCerts {
+		return nil
+	}
+
+	oldest, err := b.roleCertsByAge(ctx, req.Storage, serials)
+	if err != nil {
+		return err
+	}
+	for _, serial := range oldest[:len(serials)-role.MaxStoredCerts] {
+		if err := b.purgeRoleCert(ctx, req, roleName, serial); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// roleCertsByAge returns the serials ordered from the oldest certificate to
+// the newest, by the issuance time recorded for each.
+func (b *keyfactorBackend) roleCertsByAge(ctx context.Context, s logical.Storage, serials []string) ([]string, error) {
+	issuedAt := make(map[string]time.Time, len(serials))
+	for _, serial := range serials {
+		info, err := getCertInfo(ctx, s, serial)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil {
+			issuedAt[serial] = info.IssuedAt
+		}
+	}
+	sorted := append([]string(nil), serials...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return issuedAt[sorted[i]].Before(issuedAt[sorted[j]])
+	})
+	return sorted, nil
+}
+
+// purgeRoleCert revokes a certificate of the role, unless it is revoked
+// already, and removes it from the role's index.
+func (b *keyfactorBackend) purgeRoleCert(ctx context.Context, req *logical.Request, roleName string, serial string) error {
+	revoked, err := req.Storage.Get(ctx, "revoked/"+serial)
+	if err != nil {
+		return err
+	}
+	if revoked == nil {
+		resp, err := revokeCert(ctx, b, req, serial, revocationReasonUnspecified, false)
+		if err != nil {
+			return fmt.Errorf("unable to revoke certificate %s to make room in role %s: %w", serial, roleName, err)
+		}
+		if resp != nil && resp.IsError() {
+			return fmt.Errorf("unable to revoke certificate %s to make room in role %s: %w", serial, roleName, resp.Error())
+		}
+	}
+	b.Logger().Warn("purged the oldest certificate of the role to stay within max_stored_certs", "role", roleName, "serial", serial)
+	return req.Storage.Delete(ctx, roleCertsStoragePrefix+roleName+"/"+serial)
+}
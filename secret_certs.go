@@ -0,0 +1,95 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// secretCertsType is the type of the leases of certificates issued by roles
+// with generate_lease set.
+const secretCertsType = "keyfactor"
+
+func secretCerts(b *keyfactorBackend) *framework.Secret {
+	return &framework.Secret{
+		Type: secretCertsType,
+		Fields: map[string]*framework.FieldSchema{
+			"certificate": {
+				Type:        framework.TypeString,
+				Description: `The PEM-encoded certificate.`,
+			},
+			"private_key": {
+				Type:        framework.TypeString,
+				Description: `The PEM-encoded private key for the certificate.`,
+			},
+			"serial_number": {
+				Type:        framework.TypeString,
+				Description: `The serial number of the certificate.`,
+			},
+		},
+
+		Renew:  b.secretCredsRenew,
+		Revoke: b.secretCredsRevoke,
+	}
+}
+
+// secretCredsRenew renews the lease of a certificate up to the expiry of the
+// certificate.
+func (b *keyfactorBackend) secretCredsRenew(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	if req.Secret == nil {
+		return nil, fmt.Errorf("secret is nil in request")
+	}
+
+	serialInt, ok := req.Secret.InternalData["serial_number"]
+	if !ok {
+		return nil, fmt.Errorf("could not find serial in internal secret data")
+	}
+
+	certEntry, err := fetchCertBySerial(ctx, req, "certs/", serialInt.(string))
+	if err != nil {
+		return nil, err
+	}
+	if certEntry == nil {
+		return logical.ErrorResponse(fmt.Sprintf("certificate with serial %s not found", serialInt)), nil
+	}
+	cert, err := parseCertificatePEM(certEntry.Value)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the stored certificate: %w", err)
+	}
+	remaining := time.Until(cert.NotAfter)
+	if remaining <= 0 {
+		return logical.ErrorResponse(fmt.Sprintf("certificate with serial %s has expired", serialInt)), nil
+	}
+
+	resp := &logical.Response{Secret: req.Secret}
+	resp.Secret.TTL = remaining
+	resp.Secret.MaxTTL = remaining
+	return resp, nil
+}
+
+// secretCredsRevoke revokes the certificate of a lease when the lease is
+// revoked or expires.
+func (b *keyfactorBackend) secretCredsRevoke(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	if req.Secret == nil {
+		return nil, fmt.Errorf("secret is nil in request")
+	}
+
+	serialInt, ok := req.Secret.InternalData["serial_number"]
+	if !ok {
+		return nil, fmt.Errorf("could not find serial in internal secret data")
+	}
+
+	return revokeCert(ctx, b, req, serialInt.(string), revocationReasonUnspecified, true)
+}
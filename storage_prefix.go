@@ -0,0 +1,86 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// prefixedStorage prefixes every storage key except the configuration, which
+// holds the prefix and so must stay where the backend can find it, and the
+// feature flags stored beside it.
+type prefixedStorage struct {
+	logical.Storage
+	prefix string
+}
+
+func (s *prefixedStorage) key(key string) string {
+	if key == configPath || key == featuresPath {
+		return key
+	}
+	return s.prefix + key
+}
+
+func (s *prefixedStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	return s.Storage.List(ctx, s.prefix+prefix)
+}
+
+func (s *prefixedStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	entry, err := s.Storage.Get(ctx, s.key(key))
+	if err != nil || entry == nil {
+		return entry, err
+	}
+	entry.Key = key
+	return entry, nil
+}
+
+func (s *prefixedStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	prefixed := *entry
+	prefixed.Key = s.key(entry.Key)
+	return s.Storage.Put(ctx, &prefixed)
+}
+
+func (s *prefixedStorage) Delete(ctx context.Context, key string) error {
+	return s.Storage.Delete(ctx, s.key(key))
+}
+
+// scopedStorage returns the storage to use for a request, prefixed with the
+// configured storage_prefix_override if one is set.  Storage that is already
+// scoped is returned unchanged.
+func (b *keyfactorBackend) scopedStorage(ctx context.Context, s logical.Storage) (logical.Storage, error) {
+	if _, ok := s.(*prefixedStorage); ok {
+		return s, nil
+	}
+	config, err := b.fetchConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || config.StoragePrefixOverride == "" {
+		return s, nil
+	}
+	prefix := strings.TrimSuffix(config.StoragePrefixOverride, "/") + "/"
+	return &prefixedStorage{Storage: s, prefix: prefix}, nil
+}
+
+// HandleRequest scopes the request storage to the storage prefix before
+// handing the request to the framework.
+func (b *keyfactorBackend) HandleRequest(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+	if req != nil && req.Storage != nil {
+		s, err := b.scopedStorage(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		req.Storage = s
+	}
+	return b.Backend.HandleRequest(ctx, req)
+}
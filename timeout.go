@@ -0,0 +1,123 @@
+/*
+ *  Copyright 2024 Keyfactor
+ *  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *  Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions
+ *  and limitations under the License.
+ */
+
+package kfbackend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Operation types with a separately configurable timeout for requests to Keyfactor.
+const (
+	operationIssue  = "issue"
+	operationSign   = "sign"
+	operationRevoke = "revoke"
+)
+
+const defaultOperationTimeout = 60 * time.Second
+
+type operationTimeoutKey struct{}
+
+// operationTimeout returns the configured maximum timeout for the operation type.
+func (c *keyfactorConfig) operationTimeout(operation string) time.Duration {
+	var timeout time.Duration
+	if c != nil {
+		switch operation {
+		case operationIssue:
+			timeout = c.IssueTimeout
+		case operationSign:
+			timeout = c.SignTimeout
+		case operationRevoke:
+			timeout = c.RevokeTimeout
+		}
+	}
+	if timeout <= 0 {
+		timeout = defaultOperationTimeout
+	}
+	return timeout
+}
+
+// resolveOperationTimeout returns the timeout requested with the `timeout`
+// field, capped at the configured timeout for the operation type.
+func (b *keyfactorBackend) resolveOperationTimeout(ctx context.Context, s logical.Storage, operation string, data *framework.FieldData) (time.Duration, error) {
+	config, err := b.fetchConfig(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	timeout := config.operationTimeout(operation)
+
+	if requested, ok := data.GetOk("timeout"); ok {
+		requestedTimeout := time.Duration(requested.(int)) * time.Second
+		if requestedTimeout > 0 && requestedTimeout < timeout {
+			timeout = requestedTimeout
+		}
+	}
+	return timeout, nil
+}
+
+// parseTemplateTimeouts validates the `template_timeouts` field and parses
+// its durations.
+func parseTemplateTimeouts(timeouts map[string]string) (map[string]time.Duration, error) {
+	parsed := make(map[string]time.Duration, len(timeouts))
+	for template, value := range timeouts {
+		if template == "" {
+			return nil, errors.New("template_timeouts must not contain an empty template name")
+		}
+		timeout, err := parseutil.ParseDurationSecond(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout %q for template %q: %w", value, template, err)
+		}
+		if timeout <= 0 {
+			return nil, fmt.Errorf("the timeout for template %q must be positive", template)
+		}
+		parsed[template] = timeout
+	}
+	return parsed, nil
+}
+
+// templateTimeoutsResponseData returns the template timeouts in seconds.
+func (c *keyfactorConfig) templateTimeoutsResponseData() map[string]int64 {
+	data := make(map[string]int64, len(c.TemplateTimeouts))
+	for template, timeout := range c.TemplateTimeouts {
+		data[template] = int64(timeout.Seconds())
+	}
+	return data
+}
+
+// withOperationTimeout records the timeout to apply to requests sent to
+// Keyfactor while handling the operation.  The timeout is only applied to the
+// Keyfactor requests so that storage writes are not interrupted.
+func withOperationTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, operationTimeoutKey{}, timeout)
+}
+
+// keyfactorRequestContext returns the context for a request to Keyfactor,
+// with the operation timeout applied if one has been set.
+func keyfactorRequestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout, ok := ctx.Value(operationTimeoutKey{}).(time.Duration); ok && timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// timeoutErrorResponse returns a user-visible error response if the error was
+// caused by the operation timeout elapsing.
+func timeoutErrorResponse(err error, timeout time.Duration) (*logical.Response, bool) {
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		return nil, false
+	}
+	return logical.ErrorResponse(fmt.Sprintf("keyfactor request timed out after %s", timeout)), true
+}